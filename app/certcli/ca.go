@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func ca(command string, args []string) {
+	usage := func() {
+		fmt.Printf(`'%[1]s' manages a CA directory, which holds a CA's certificate, key, config, and an index of the certificates it issues.
+
+Usage: %[1]s SUBCOMMAND [FLAGS]... [ARGS]...
+
+SUBCOMMAND:
+  init      Create a new self-signed CA in a CA directory.
+  list      List the certificates issued by a CA directory.
+  policy    Show or set the issuance policy enforced by a CA directory.
+  rollover  Replace a CA directory's root with a successor, and create link certificates for the transition.
+  urls      Show or set the OCSP, CA issuer, and CRL URLs embedded in certificates issued by a CA directory.
+
+See each subcommand's help text for more info.
+`, command)
+	}
+
+	subcommands := map[string]cliCommand{
+		"init":     caInit,
+		"list":     caList,
+		"policy":   caPolicy,
+		"rollover": caRollover,
+		"urls":     caURLs,
+	}
+
+	if len(args) == 0 {
+		fmt.Println("No subcommand specified")
+		usage()
+		os.Exit(1)
+	}
+	if args[0] == "--help" || args[0] == "-h" {
+		usage()
+		return
+	}
+	if fn, ok := subcommands[args[0]]; ok {
+		fn(command+" "+args[0], args[1:])
+		return
+	}
+	fmt.Printf("Unrecognized subcommand '%s'\n", args[0])
+	os.Exit(1)
+}
+
+func caInit(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' creates a new self-signed root CA in a CA directory. Files in the directory are PEM encoded.
+Use 'sign --ca-dir' to issue certificates that are recorded in the directory's index.
+
+Usage: %[1]s [FLAGS] DIR COMMON_NAME
+
+DIR:
+  The CA directory to create. It must not exist, or must be empty.
+
+COMMON_NAME:
+  The "CN" field in the certificate.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var policyFile string
+	flags.StringVar(&policyFile, "policy-file", "", "Specifies a JSON issuance policy file to store in the CA config, which 'sign --ca-dir' enforces")
+	caFlags := addCaCertFlags(flags)
+	urlFlags := addCaURLFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 2 {
+		fmt.Println("Must pass DIR and COMMON_NAME arguments")
+		flags.Usage()
+		os.Exit(1)
+	}
+	dirPath := flags.Arg(0)
+	commonName := strings.TrimSpace(flags.Arg(1))
+	if commonName == "" {
+		fmt.Println("Common name is a required parameter")
+		os.Exit(1)
+	}
+
+	opts, err := caFlags.opts(flags)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	urls := urlFlags.urls()
+	if err := urls.Validate(); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	var policy *business.IssuancePolicy
+	if policyFile != "" {
+		if policy, err = business.LoadIssuancePolicy(policyFile); err != nil {
+			fmt.Printf("Failed to load issuance policy '%s': %v\n", policyFile, err)
+			os.Exit(1)
+		}
+	}
+
+	name, err := business.PromptCertNameDetails()
+	if err != nil {
+		fmt.Printf("Error prompting for certificate details: %v\n", err)
+		os.Exit(1)
+	}
+
+	cert, key, err := business.NewCaCert(commonName, name, opts...)
+	if err != nil {
+		fmt.Printf("Error generating CA certificate: %v\n", err)
+		os.Exit(1)
+	}
+	dir, err := business.InitCaDir(dirPath, cert, key)
+	if err != nil {
+		fmt.Printf("Failed to create CA directory '%s': %v\n", dirPath, err)
+		os.Exit(1)
+	}
+	if policy != nil || !urls.IsEmpty() {
+		dir.Config.Policy = policy
+		if !urls.IsEmpty() {
+			dir.Config.URLs = urls
+		}
+		if err := dir.SaveConfig(); err != nil {
+			fmt.Printf("Failed to save CA config: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+func caURLs(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' shows the URLs embedded in certificates issued by 'sign', 'renew', and 'cross-sign' with 'ca-dir', or changes them.
+The OCSP and CA issuer URLs are added to the Authority Information Access extension, and the CRL URLs to the CRL Distribution Points extension.
+Each URL flag replaces the current URLs of that kind. Certificates that were already issued are not changed.
+
+Usage: %[1]s [FLAGS] DIR
+
+DIR:
+  The CA directory.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var clearURLs bool
+	urlFlags := addCaURLFlags(flags)
+	flags.BoolVar(&clearURLs, "clear", false, "Specifies that all URLs should be removed")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass DIR argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	changed := urlFlags.isSet(flags)
+	if changed && clearURLs {
+		fmt.Println("URL flags may not be specified with 'clear'")
+		os.Exit(1)
+	}
+
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	switch {
+	case changed:
+		err = dir.UpdateConfig(func(config *business.CaConfig) error {
+			urls := &business.CaURLs{}
+			if config.URLs != nil {
+				*urls = *config.URLs
+			}
+			if flags.Changed("ocsp-url") {
+				urls.OCSP = urlFlags.ocsp
+			}
+			if flags.Changed("issuer-url") {
+				urls.Issuer = urlFlags.issuer
+			}
+			if flags.Changed("crl-url") {
+				urls.CRL = urlFlags.crl
+			}
+			if err := urls.Validate(); err != nil {
+				return err
+			}
+			config.URLs = urls
+			if urls.IsEmpty() {
+				config.URLs = nil
+			}
+			return nil
+		})
+	case clearURLs:
+		err = dir.UpdateConfig(func(config *business.CaConfig) error {
+			config.URLs = nil
+			return nil
+		})
+	default:
+		if dir.Config.URLs.IsEmpty() {
+			fmt.Println("No URLs are set")
+			return
+		}
+		out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, kind := range []struct {
+			label string
+			urls  []string
+		}{
+			{"OCSP", dir.Config.URLs.OCSP},
+			{"CA Issuer", dir.Config.URLs.Issuer},
+			{"CRL", dir.Config.URLs.CRL},
+		} {
+			for _, url := range kind.urls {
+				fmt.Fprintf(out, "%s\t%s\n", kind.label, url)
+			}
+		}
+		out.Flush()
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to save URLs: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// caURLFlags holds the flags for the URLs embedded in issued certificates.
+type caURLFlags struct {
+	ocsp   []string
+	issuer []string
+	crl    []string
+}
+
+func addCaURLFlags(flags *pflag.FlagSet) *caURLFlags {
+	f := &caURLFlags{}
+	flags.StringSliceVar(&f.ocsp, "ocsp-url", nil, "Specifies the URL of an OCSP responder for the CA, such as one run with 'ocsp-serve'")
+	flags.StringSliceVar(&f.issuer, "issuer-url", nil, "Specifies a URL where the DER encoded CA certificate may be downloaded")
+	flags.StringSliceVar(&f.crl, "crl-url", nil, "Specifies a URL where the CA's full CRL is published")
+	return f
+}
+
+// isSet reports whether any URL flag was specified.
+func (f *caURLFlags) isSet(flags *pflag.FlagSet) bool {
+	return flags.Changed("ocsp-url") || flags.Changed("issuer-url") || flags.Changed("crl-url")
+}
+
+func (f *caURLFlags) urls() *business.CaURLs {
+	return &business.CaURLs{OCSP: f.ocsp, Issuer: f.issuer, CRL: f.crl}
+}
+
+func caPolicy(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' shows the issuance policy enforced by 'sign --ca-dir', or replaces it with 'set' or 'clear'.
+The policy is a JSON object with these optional fields:
+  allowedDnsSuffixes        Domains that DNS names, URI hosts, and email domains must be in, including subdomains
+  deniedDnsSuffixes         Domains that DNS names, URI hosts, and email domains must not be in, which take precedence
+  wildcards                 'allow' or 'deny' wildcard DNS names
+  permittedCidrs            IP ranges that IP SANs and IP URI hosts must be in
+  commonNamePattern         A regular expression that must match the whole common name
+  requiredSubjectFields     Subject fields that must be set, from CN, C, O, OU, L, ST, STREET, POSTALCODE
+  forcedOrganization        O values that replace the CSR's
+  forcedOrganizationalUnit  OU values that replace the CSR's
+
+Usage: %[1]s [FLAGS] DIR
+
+DIR:
+  The CA directory.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		setFile     string
+		clearPolicy bool
+	)
+
+	flags.StringVar(&setFile, "set", "", "Specifies a JSON issuance policy file to replace the current policy with")
+	flags.BoolVar(&clearPolicy, "clear", false, "Specifies that the current policy should be removed")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass DIR argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if setFile != "" && clearPolicy {
+		fmt.Println("Only one of 'set' and 'clear' may be specified")
+		os.Exit(1)
+	}
+
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	switch {
+	case setFile != "":
+		policy, loadErr := business.LoadIssuancePolicy(setFile)
+		if loadErr != nil {
+			fmt.Printf("Failed to load issuance policy '%s': %v\n", setFile, loadErr)
+			os.Exit(1)
+		}
+		err = dir.UpdateConfig(func(config *business.CaConfig) error {
+			config.Policy = policy
+			return nil
+		})
+	case clearPolicy:
+		err = dir.UpdateConfig(func(config *business.CaConfig) error {
+			config.Policy = nil
+			return nil
+		})
+	default:
+		if dir.Config.Policy == nil {
+			fmt.Println("No issuance policy is set")
+			return
+		}
+		data, err := json.MarshalIndent(dir.Config.Policy, "", "  ")
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		fmt.Println(string(data))
+		return
+	}
+	if err != nil {
+		fmt.Printf("Failed to save issuance policy: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func caRollover(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' replaces a CA directory's root with a newly generated successor.
+The successor keeps the old root's subject, key type, SANs, path length, name constraints, and lifetime, unless flags override them.
+The old root and key are moved to 'rollovers/<new-serial>/' in the CA directory, along with:
+  new-with-old.cer  The new root signed by the old root. Serve it as an intermediate to clients that only trust the old root.
+  old-with-new.cer  The old root signed by the new root. Serve it as an intermediate to clients that only trust the new root.
+  trust-bundle.pem  Both roots, to distribute to clients during the transition.
+
+If the current CA key is encrypted, the new key is encrypted with the same passphrase, unless 'encrypt-key' is specified
+to choose a new passphrase, or 'no-encrypt-key' is specified to write it unencrypted.
+Certificates issued by the old root stay in the index, and may be moved to the new root with 'renew --ca-dir'.
+Until the old root expires, 'crl' keeps signing a CRL of the certificates it issued with its key, and 'ocsp-serve' keeps answering for them.
+Delta CRLs are only signed by the current root, so a full CRL must be generated before the next delta CRL.
+
+Usage: %[1]s [FLAGS] DIR
+
+DIR:
+  The CA directory.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		expireMonths int
+		expireDays   int
+		keyTypeName  string
+		keyBits      int
+		bundleOut    string
+		noEncrypt    bool
+	)
+
+	flags.IntVar(&expireMonths, "expire-months", 0, "Specifies the new root's validity time, in months. This takes precedence over 'expire-days'. Default is the old root's lifetime")
+	flags.IntVar(&expireDays, "expire-days", 0, "Specifies the new root's validity time, in days")
+	flags.StringVar(&keyTypeName, "key-type", "", "Specifies the type of key to generate. May be one of "+strings.Join(business.KeyTypeNames(), ", ")+". Default is the old root's key type")
+	flags.IntVar(&keyBits, "key-bits", 4096, "Specifies the RSA key size in bits. Only valid with the 'rsa' key type")
+	flags.StringVar(&bundleOut, "bundle-out", "", "Specifies a path to write a copy of the transition trust bundle to")
+	flags.BoolVar(&noEncrypt, "no-encrypt-key", false, "Specifies that the new CA key should be written unencrypted, even if the current CA key is encrypted")
+	caPassFlags := addPassphraseFlags(flags, "ca-key-", "current CA key, if it's encrypted")
+	encFlags := addEncryptFlags(flags, "", "new CA key")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass DIR argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	var opts []business.CaCertOpt
+	if keyTypeName != "" {
+		keyType, err := business.ParseKeyType(keyTypeName)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, business.CaKeyType(keyType))
+		if keyType == business.KeyTypeRSA {
+			opts = append(opts, business.CaKeyBits(keyBits))
+		}
+	}
+	if flags.Changed("key-bits") {
+		if keyTypeName == "" {
+			opts = append(opts, business.CaKeyBits(keyBits))
+		} else if !strings.EqualFold(keyTypeName, "rsa") {
+			fmt.Println("The 'key-bits' flag is only valid with the 'rsa' key type")
+			os.Exit(1)
+		}
+	}
+	switch {
+	case expireMonths > 0:
+		opts = append(opts, business.CaExpirationMonths(expireMonths))
+	case expireDays > 0:
+		opts = append(opts, business.CaExpirationDays(expireDays))
+	}
+
+	caPassphrase, err := caPassFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	passphrase, kdf, err := encFlags.encryption("New CA key passphrase")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if passphrase != nil {
+		if noEncrypt {
+			fmt.Println("The 'no-encrypt-key' flag can't be used with 'encrypt-key', 'passphrase-env', or 'passphrase-file'")
+			os.Exit(1)
+		}
+		opts = append(opts, business.CaEncryptKey(passphrase, kdf))
+	} else if noEncrypt {
+		opts = append(opts, business.CaPlaintextKey())
+	}
+
+	rollover, err := dir.Rollover(caPassphrase, opts...)
+	if err != nil {
+		fmt.Printf("Failed to roll over CA: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Replaced root %s (expires %s) with %s (expires %s)\n", rollover.OldSerial, rollover.OldNotAfter.Format(time.RFC3339),
+		rollover.NewSerial, rollover.NewNotAfter.Format(time.RFC3339))
+	fmt.Printf("Link certificates and trust bundle written to '%s'\n", rollover.Path)
+
+	if bundleOut != "" {
+		bundle, err := ioutil.ReadFile(rollover.TrustBundleFile())
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(bundleOut, bundle, 0644); err != nil {
+			fmt.Printf("Failed to write trust bundle to '%s': %v\n", bundleOut, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func caList(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' lists the certificates issued by a CA directory.
+
+Usage: %[1]s [FLAGS] DIR
+
+DIR:
+  The CA directory.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		status         string
+		expiringWithin time.Duration
+	)
+
+	flags.StringVar(&status, "status", "", "Specifies that only certificates with this status should be listed. May be one of valid, revoked, expired")
+	flags.DurationVar(&expiringWithin, "expiring-within", 0, "Specifies that only valid certificates expiring within this duration should be listed, such as '720h'")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass DIR argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	entries, err := dir.Index()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	now := time.Now()
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "SERIAL\tSTATUS\tNOT AFTER\tPROFILE\tSUBJECT\tSANS")
+	for _, entry := range entries {
+		entryStatus := entry.StatusAt(now)
+		if status != "" && string(entryStatus) != strings.ToLower(status) {
+			continue
+		}
+		if expiringWithin > 0 && (entryStatus != business.CertStatusValid || entry.NotAfter.After(now.Add(expiringWithin))) {
+			continue
+		}
+		var sans []string
+		for _, names := range [][]string{entry.DNSNames, entry.IPAddresses, entry.URIs, entry.EmailAddresses} {
+			sans = append(sans, names...)
+		}
+		fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%s\t%s\n", entry.Serial, entryStatus, entry.NotAfter.Format(time.RFC3339), entry.Profile, entry.Subject, strings.Join(sans, ","))
+	}
+	out.Flush()
+}
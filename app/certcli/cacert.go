@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
 	"github.com/spf13/pflag"
 	"io/ioutil"
 	"net"
@@ -13,7 +15,7 @@ import (
 func cacert(command string, args []string) {
 	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Printf(`'%[1]s' creates a new DER encoded, self-signed root CA cert
+		fmt.Printf(`'%[1]s' creates a new self-signed root CA cert, DER encoded by default
 
 Usage: %[1]s [FLAGS] COMMON_NAME
 
@@ -26,21 +28,15 @@ Flags:
 	}
 
 	var (
-		commonName   string
-		caCertPath   string
-		caKeyPath    string
-		expireMonths int
-		expireDays   int
-		sans         []string
-		ips          []net.IP
+		commonName string
+		caCertPath string
+		caKeyPath  string
 	)
 
 	flags.StringVar(&caCertPath, "cert-out", "", "Specifies a different output path for the CA cert. Default is './<common-name>.cer'")
 	flags.StringVar(&caKeyPath, "key-out", "", "Specifies a different output path for the CA key. Default is './<common-name>.key'")
-	flags.IntVar(&expireMonths, "expire-months", 0, "Specifies the certificate's validity time, in months. This takes precedence over 'expire-days'")
-	flags.IntVar(&expireDays, "expire-days", 0, "Specifies the certificate's validity time, in days.")
-	flags.StringSliceVar(&sans, "san", nil, "Specifies a Subject Alternative Name used for this server cert")
-	flags.IPSliceVar(&ips, "ip", nil, "Specifies an IP used for this server cert")
+	caFlags := addCaCertFlags(flags)
+	outFormat := addOutFormatFlag(flags)
 	if err := flags.Parse(args); err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
@@ -64,20 +60,11 @@ Flags:
 		caKeyPath = commonName + ".key"
 	}
 
-	var opts []business.CaCertOpt
-
-	switch {
-	case expireMonths > 0:
-		opts = append(opts, business.CaExpirationMonths(expireMonths))
-	case expireDays > 0:
-		opts = append(opts, business.CaExpirationDays(expireDays))
-	}
-
-	for _, san := range sans {
-		opts = append(opts, business.CaSubjectAlternativeName(san))
-	}
-	for _, ip := range ips {
-		opts = append(opts, business.CaIpAddress(ip))
+	encoding := parseOutFormat(*outFormat)
+	opts, err := caFlags.opts(flags)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
 	}
 
 	name, err := business.PromptCertNameDetails()
@@ -92,12 +79,96 @@ Flags:
 		os.Exit(1)
 	}
 
-	if err := ioutil.WriteFile(caCertPath, cert, 0600); err != nil {
+	if err := ioutil.WriteFile(caCertPath, format.EncodeCerts(encoding, cert), 0600); err != nil {
 		fmt.Printf("Failed to write certificate to '%s': %v\n", caCertPath, err)
 		os.Exit(1)
 	}
-	if err := ioutil.WriteFile(caKeyPath, key, 0600); err != nil {
+	if err := ioutil.WriteFile(caKeyPath, format.EncodePrivateKey(encoding, key), 0600); err != nil {
 		fmt.Printf("Failed to write key to '%s': %v\n", caKeyPath, err)
 		os.Exit(1)
 	}
 }
+
+// caCertFlags holds the flags shared by the commands that create a self-signed CA.
+type caCertFlags struct {
+	expireMonths int
+	expireDays   int
+	sans         []string
+	ips          []net.IP
+	uris         []string
+	emails       []string
+	keyTypeName  string
+	keyBits      int
+	pass         *passphraseFlags
+	constraints  *caConstraintFlags
+}
+
+func addCaCertFlags(flags *pflag.FlagSet) *caCertFlags {
+	f := &caCertFlags{}
+	flags.IntVar(&f.expireMonths, "expire-months", 0, "Specifies the certificate's validity time, in months. This takes precedence over 'expire-days'")
+	flags.IntVar(&f.expireDays, "expire-days", 0, "Specifies the certificate's validity time, in days.")
+	flags.StringSliceVar(&f.sans, "san", nil, "Specifies a Subject Alternative Name used for this server cert")
+	flags.IPSliceVar(&f.ips, "ip", nil, "Specifies an IP used for this server cert")
+	flags.StringSliceVar(&f.uris, "uri", nil, "Specifies a URI SAN, such as a SPIFFE trust domain like 'spiffe://cluster.local'")
+	flags.StringSliceVar(&f.emails, "email", nil, "Specifies an email SAN")
+	flags.StringVar(&f.keyTypeName, "key-type", "rsa", "Specifies the type of key to generate. May be one of "+strings.Join(business.KeyTypeNames(), ", "))
+	flags.IntVar(&f.keyBits, "key-bits", 4096, "Specifies the RSA key size in bits. Only valid with the 'rsa' key type")
+	f.pass = addEncryptFlags(flags, "", "CA key")
+	f.constraints = addCaConstraintFlags(flags, "Default is -1, which is unlimited")
+	return f
+}
+
+// opts validates the parsed flags and returns the matching CA options.
+func (f *caCertFlags) opts(flags *pflag.FlagSet) ([]business.CaCertOpt, error) {
+	keyType, err := business.ParseKeyType(f.keyTypeName)
+	if err != nil {
+		return nil, err
+	}
+	if flags.Changed("key-bits") && keyType != business.KeyTypeRSA {
+		return nil, errors.New("the 'key-bits' flag is only valid with the 'rsa' key type")
+	}
+
+	passphrase, kdf, err := f.pass.encryption("CA key passphrase")
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []business.CaCertOpt{business.CaKeyType(keyType), business.CaKeyBits(f.keyBits)}
+	if passphrase != nil {
+		opts = append(opts, business.CaEncryptKey(passphrase, kdf))
+	}
+
+	switch {
+	case f.expireMonths > 0:
+		opts = append(opts, business.CaExpirationMonths(f.expireMonths))
+	case f.expireDays > 0:
+		opts = append(opts, business.CaExpirationDays(f.expireDays))
+	}
+
+	for _, san := range f.sans {
+		opts = append(opts, business.CaSubjectAlternativeName(san))
+	}
+	for _, ip := range f.ips {
+		opts = append(opts, business.CaIpAddress(ip))
+	}
+	for _, rawURI := range f.uris {
+		uri, err := business.ParseURISAN(rawURI)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, business.CaURI(uri))
+	}
+	for _, email := range f.emails {
+		if err := business.ValidateEmailSAN(email); err != nil {
+			return nil, err
+		}
+		opts = append(opts, business.CaEmailAddress(email))
+	}
+
+	nameConstraints, err := f.constraints.nameConstraints()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, business.CaMaxPathLen(f.constraints.maxPathLen), business.CaNameConstraints(nameConstraints))
+	return opts, nil
+}
@@ -15,7 +15,8 @@ func certinfo(command string, args []string) {
 Usage: %[1]s FILE [FILE]...
 
 FILE:
-  A file containing a PEM or DER encoded certificate.`, command)
+  A file containing one or more PEM or DER encoded certificates, or a PKCS#7 (.p7b) bundle.
+  Every certificate in the file is displayed.`, command)
 	}
 	if err := flags.Parse(args); err != nil {
 		fmt.Println(err.Error())
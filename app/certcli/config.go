@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	configEnvVar      = "CERTCLI_CONFIG"
+	defaultConfigFile = ".certcli.json"
+)
+
+// cliConfig holds project-wide defaults for flags. It's read from the file named by CERTCLI_CONFIG, or './.certcli.json'.
+type cliConfig struct {
+	// OutFormat is the default for the 'out-format' flag of commands that write artifacts.
+	OutFormat string `json:"outFormat"`
+}
+
+var config cliConfig
+
+func loadConfig() cliConfig {
+	cfg := cliConfig{
+		OutFormat: "der",
+	}
+
+	path, explicit := os.LookupEnv(configEnvVar)
+	if !explicit {
+		path = defaultConfigFile
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !explicit {
+			return cfg
+		}
+		fmt.Printf("Failed to read config file '%s': %v\n", path, err)
+		os.Exit(1)
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		fmt.Printf("Failed to parse config file '%s': %v\n", path, err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// addOutFormatFlag registers the 'out-format' flag, defaulting to the configured format.
+func addOutFormatFlag(flags *pflag.FlagSet) *string {
+	return flags.String("out-format", config.OutFormat, "Specifies the encoding of output files. May be one of der, pem")
+}
+
+func parseOutFormat(outFormat string) format.Encoding {
+	encoding, err := format.ParseEncoding(outFormat)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return encoding
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+	"net"
+)
+
+// caConstraintFlags holds the flags that limit what an issued CA may sign.
+type caConstraintFlags struct {
+	maxPathLen   int
+	permitDNS    []string
+	excludeDNS   []string
+	permitIP     []string
+	excludeIP    []string
+	permitEmail  []string
+	excludeEmail []string
+	permitURI    []string
+	excludeURI   []string
+}
+
+func addCaConstraintFlags(flags *pflag.FlagSet, pathLenDesc string) *caConstraintFlags {
+	f := &caConstraintFlags{}
+	flags.IntVar(&f.maxPathLen, "max-path-len", -1, "Specifies the number of CAs allowed below this CA, where 0 allows none. "+pathLenDesc)
+	flags.StringSliceVar(&f.permitDNS, "permit-dns", nil, "Specifies a DNS domain that the CA may issue for, including subdomains. A leading '.' or '*.' permits only subdomains")
+	flags.StringSliceVar(&f.excludeDNS, "exclude-dns", nil, "Specifies a DNS domain that the CA may not issue for, including subdomains. A leading '.' or '*.' excludes only subdomains")
+	flags.StringSliceVar(&f.permitIP, "permit-ip", nil, "Specifies a CIDR range of IPs that the CA may issue for, such as '10.0.0.0/8'")
+	flags.StringSliceVar(&f.excludeIP, "exclude-ip", nil, "Specifies a CIDR range of IPs that the CA may not issue for")
+	flags.StringSliceVar(&f.permitEmail, "permit-email", nil, "Specifies an email address, host, or '.domain' that the CA may issue for")
+	flags.StringSliceVar(&f.excludeEmail, "exclude-email", nil, "Specifies an email address, host, or '.domain' that the CA may not issue for")
+	flags.StringSliceVar(&f.permitURI, "permit-uri", nil, "Specifies a URI host that the CA may issue for, including subdomains. A leading '.' or '*.' permits only subdomains")
+	flags.StringSliceVar(&f.excludeURI, "exclude-uri", nil, "Specifies a URI host that the CA may not issue for, including subdomains. A leading '.' or '*.' excludes only subdomains")
+	return f
+}
+
+// isSet reports whether any constraint was specified.
+func (f *caConstraintFlags) isSet(flags *pflag.FlagSet) bool {
+	for _, name := range []string{"max-path-len", "permit-dns", "exclude-dns", "permit-ip", "exclude-ip", "permit-email", "exclude-email", "permit-uri", "exclude-uri"} {
+		if flags.Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// nameConstraints parses the IP ranges and returns the name constraints.
+func (f *caConstraintFlags) nameConstraints() (business.NameConstraints, error) {
+	permitIP, err := parseCIDRs(f.permitIP)
+	if err != nil {
+		return business.NameConstraints{}, err
+	}
+	excludeIP, err := parseCIDRs(f.excludeIP)
+	if err != nil {
+		return business.NameConstraints{}, err
+	}
+	return business.NameConstraints{
+		PermittedDNSDomains:     f.permitDNS,
+		ExcludedDNSDomains:      f.excludeDNS,
+		PermittedIPRanges:       permitIP,
+		ExcludedIPRanges:        excludeIP,
+		PermittedEmailAddresses: f.permitEmail,
+		ExcludedEmailAddresses:  f.excludeEmail,
+		PermittedURIDomains:     f.permitURI,
+		ExcludedURIDomains:      f.excludeURI,
+	}, nil
+}
+
+func parseCIDRs(cidrs []string) ([]*net.IPNet, error) {
+	var ranges []*net.IPNet
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range '%s', must be in CIDR notation such as '10.0.0.0/8'", cidr)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	return ranges, nil
+}
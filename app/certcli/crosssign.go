@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+)
+
+func crossSign(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' re-issues an existing CA certificate under a different CA, keeping its subject, public key, and subject key ID.
+Certificates issued by the CA then chain to either issuer, which lets clients that only trust the issuing CA trust the cross-signed CA.
+For example, to migrate from an old root, cross-sign the new root with the old root and serve the result as an intermediate.
+
+Usage: %[1]s [FLAGS] CA_CERT_FILE ISSUER_CERT ISSUER_KEY
+       %[1]s [FLAGS] --ca-dir DIR CA_CERT_FILE
+
+CA_CERT_FILE:
+  The CA certificate to cross-sign.
+
+ISSUER_CERT:
+  The certificate of the CA that signs the cross-signed certificate.
+
+ISSUER_KEY:
+  The issuing CA's key.
+
+With 'ca-dir', the issuing CA's certificate and key are read from the CA directory, and the cross-signed certificate is recorded in its index.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		certOut  string
+		caDirArg string
+	)
+
+	flags.StringVar(&certOut, "cert-out", "", "Specifies a different output path for the certificate. Default is './<subject-common-name>-cross.cer'")
+	flags.StringVar(&caDirArg, "ca-dir", "", "Specifies a CA directory created by 'ca init' to sign with and record the certificate in")
+	validFlags := addValidityFlags(flags, "Default is until the original certificate expires")
+	outFormat := addOutFormatFlag(flags)
+	passFlags := addPassphraseFlags(flags, "ca-key-", "issuing CA key, if it's encrypted")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	var caDir *business.CaDir
+	if caDirArg != "" {
+		if flags.NArg() != 1 {
+			fmt.Println("Must pass only the CA_CERT_FILE argument with 'ca-dir'")
+			flags.Usage()
+			os.Exit(1)
+		}
+		var err error
+		caDir, err = business.OpenCaDir(caDirArg)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	} else if flags.NArg() < 3 {
+		fmt.Println("Must pass CA_CERT_FILE, ISSUER_CERT, and ISSUER_KEY arguments")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	validity, err := validFlags.validity()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	encoding := parseOutFormat(*outFormat)
+	caPassphrase, err := passFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	certFile := flags.Arg(0)
+	caCertFile := flags.Arg(1)
+	caKeyFile := flags.Arg(2)
+	opts := []business.CrossSignOpt{business.CrossSignCaKeyPassphrase(caPassphrase), business.CrossSignValidity(validity)}
+	if caDir != nil {
+		caCertFile = caDir.CertFile()
+		caKeyFile = caDir.KeyFile()
+		opts = append(opts, business.CrossSignRecordIn(caDir))
+	}
+
+	cert, err := business.CrossSignCert(certFile, caCertFile, caKeyFile, opts...)
+	if err != nil {
+		fmt.Printf("Failed to cross-sign certificate: %v\n", err)
+		os.Exit(1)
+	}
+
+	if certOut == "" {
+		commonName, err := business.CertCommonName(cert)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		certOut = commonName + "-cross.cer"
+	}
+	if err := ioutil.WriteFile(certOut, format.EncodeCerts(encoding, cert), 0600); err != nil {
+		fmt.Printf("Failed to write cross-signed cert to '%s': %v\n", certOut, err)
+		os.Exit(1)
+	}
+}
@@ -3,16 +3,18 @@ package main
 import (
 	"fmt"
 	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
 	"github.com/spf13/pflag"
 	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 )
 
 func createCsr(command string, args []string) {
 	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Printf(`'%[1]s' creates a new DER encoded Certificate Signing Request with the given details.
+		fmt.Printf(`'%[1]s' creates a new Certificate Signing Request, DER encoded by default, with the given details.
 
 Usage: %[1]s [FLAGS] COMMON_NAME
 
@@ -25,19 +27,29 @@ Flags:
 	}
 
 	var (
-		commonName string
-		csrPath    string
-		keyPath    string
-		sans       []string
-		ips        []net.IP
-		clientCert bool
+		commonName  string
+		csrPath     string
+		keyPath     string
+		sans        []string
+		ips         []net.IP
+		uris        []string
+		emails      []string
+		clientCert  bool
+		keyBits     int
+		keyTypeName string
 	)
 
 	flags.StringVar(&csrPath, "csr-out", "", "Specifies a different output path for the CSR. Default is './<common-name>.csr'.")
 	flags.StringVar(&keyPath, "key-out", "", "Specifies a different output path for the private key. Default is './<common-name>.key'.")
-	flags.StringSliceVar(&sans, "san", nil, "Specifies a Subject Alternative Name used for this CSR. At least one of 'san' or 'ip' must be specified, unless 'is-client' is specified.")
-	flags.IPSliceVar(&ips, "ip", nil, "Specifies an IP used for this CSR. At least one of 'san' or 'ip' must be specified, unless 'is-client' is specified.")
-	flags.BoolVar(&clientCert, "is-client", false, "Specifies that this CSR is for client authentication, so no SAN or IP will be allowed")
+	flags.StringSliceVar(&sans, "san", nil, "Specifies a DNS Subject Alternative Name used for this CSR. At least one SAN flag must be specified, unless 'is-client' is specified.")
+	flags.IPSliceVar(&ips, "ip", nil, "Specifies an IP used for this CSR. At least one SAN flag must be specified, unless 'is-client' is specified.")
+	flags.StringSliceVar(&uris, "uri", nil, "Specifies a URI SAN used for this CSR, such as a SPIFFE ID like 'spiffe://cluster.local/ns/default/sa/web'")
+	flags.StringSliceVar(&emails, "email", nil, "Specifies an email SAN used for this CSR, such as for an S/MIME certificate")
+	flags.BoolVar(&clientCert, "is-client", false, "Specifies that this CSR is for client authentication, so no DNS SAN or IP will be allowed. URI and email SANs are allowed")
+	flags.StringVar(&keyTypeName, "key-type", "rsa", "Specifies the type of key to generate. May be one of "+strings.Join(business.KeyTypeNames(), ", "))
+	flags.IntVar(&keyBits, "key-bits", 4096, "Specifies the RSA key size in bits. Only valid with the 'rsa' key type")
+	outFormat := addOutFormatFlag(flags)
+	passFlags := addEncryptFlags(flags, "", "private key")
 	if err := flags.Parse(args); err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
@@ -55,17 +67,37 @@ Flags:
 	if keyPath == "" {
 		keyPath = commonName + ".key"
 	}
-	if len(sans) == 0 && len(ips) == 0 && !clientCert {
-		fmt.Println("At least one IP and/or SAN must be specified")
+	if len(sans) == 0 && len(ips) == 0 && len(uris) == 0 && len(emails) == 0 && !clientCert {
+		fmt.Println("At least one SAN, IP, URI, or email must be specified")
 		flags.Usage()
 		os.Exit(1)
 	} else if clientCert && (len(sans) > 0 || len(ips) > 0) {
-		fmt.Println("No SAN or IP is allowed for client authentication")
+		fmt.Println("No DNS SAN or IP is allowed for client authentication")
 		flags.Usage()
 		os.Exit(1)
 	}
 
-	var opts []business.CsrOpt
+	encoding := parseOutFormat(*outFormat)
+	keyType, err := business.ParseKeyType(keyTypeName)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if flags.Changed("key-bits") && keyType != business.KeyTypeRSA {
+		fmt.Println("The 'key-bits' flag is only valid with the 'rsa' key type")
+		os.Exit(1)
+	}
+
+	passphrase, kdf, err := passFlags.encryption("Private key passphrase")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	opts := []business.CsrOpt{business.CsrKeyType(keyType), business.CsrKeyBits(keyBits)}
+	if passphrase != nil {
+		opts = append(opts, business.CsrEncryptKey(passphrase, kdf))
+	}
 
 	for _, san := range sans {
 		opts = append(opts, business.CsrAddSan(san))
@@ -73,6 +105,21 @@ Flags:
 	for _, ip := range ips {
 		opts = append(opts, business.CsrAddIP(ip))
 	}
+	for _, rawURI := range uris {
+		uri, err := business.ParseURISAN(rawURI)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, business.CsrAddURI(uri))
+	}
+	for _, email := range emails {
+		if err := business.ValidateEmailSAN(email); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, business.CsrAddEmail(email))
+	}
 
 	name, err := business.PromptCertNameDetails()
 	if err != nil {
@@ -81,10 +128,16 @@ Flags:
 	}
 
 	csr, priv, err := business.NewGeneratedCsr(commonName, name, opts...)
-	if err := ioutil.WriteFile(csrPath, csr, 0600); err != nil {
+	if err != nil {
+		fmt.Printf("Error generating CSR: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(csrPath, format.EncodeCSR(encoding, csr), 0600); err != nil {
 		fmt.Printf("Failed to write CSR to file '%s': %v\n", csrPath, err)
+		os.Exit(1)
 	}
-	if err := ioutil.WriteFile(keyPath, priv, 0600); err != nil {
+	if err := ioutil.WriteFile(keyPath, format.EncodePrivateKey(encoding, priv), 0600); err != nil {
 		fmt.Printf("Failed to write private key to file '%s': %v\n", keyPath, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+)
+
+// extensionFlags holds the flags that add extensions and certificate policies at signing time.
+type extensionFlags struct {
+	extensions         []string
+	criticalExtensions []string
+	certPolicies       []string
+	mustStaple         bool
+}
+
+func addExtensionFlags(flags *pflag.FlagSet) *extensionFlags {
+	f := &extensionFlags{}
+	flags.StringArrayVar(&f.extensions, "extension", nil, "Specifies an extension as 'OID=VALUE', replacing a profile extension with the same OID. "+
+		"VALUE is DER prefixed with 'hex:' or 'base64:', or a typed value prefixed with 'utf8:', 'ia5:', 'printable:', 'int:', 'bool:', 'oid:', or 'null:'. May be given more than once")
+	flags.StringArrayVar(&f.criticalExtensions, "critical-extension", nil, "Specifies a critical extension, in the same form as 'extension'")
+	flags.StringArrayVar(&f.certPolicies, "cert-policy", nil, "Specifies a certificate policy as 'OID[;cps=URI][;notice=TEXT]', in addition to the profile's policies. May be given more than once")
+	flags.BoolVar(&f.mustStaple, "must-staple", false, "Specifies that the TLS feature extension should be added, so clients require an OCSP response stapled by the server")
+	return f
+}
+
+// opts parses the extension flags and returns the matching sign options.
+func (f *extensionFlags) opts() ([]business.SignOpt, error) {
+	var opts []business.SignOpt
+	for _, specs := range []struct {
+		specs    []string
+		critical bool
+	}{
+		{f.extensions, false},
+		{f.criticalExtensions, true},
+	} {
+		for _, spec := range specs.specs {
+			ext, err := business.ParseCustomExtension(spec, specs.critical)
+			if err != nil {
+				return nil, err
+			}
+			opts = append(opts, business.SignExtension(ext))
+		}
+	}
+	for _, spec := range f.certPolicies {
+		policy, err := business.ParseCertPolicy(spec)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, business.SignCertPolicy(policy))
+	}
+	if f.mustStaple {
+		opts = append(opts, business.SignMustStaple())
+	}
+	return opts, nil
+}
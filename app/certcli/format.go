@@ -12,12 +12,16 @@ import (
 func formatFile(command string, args []string) {
 	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
 	flags.Usage = func() {
-		fmt.Printf(`'%[1]s' changes a certificate to another supported format.
+		fmt.Printf(`'%[1]s' changes a certificate, CSR, CRL, or key to another supported format.
+The input encoding and the type of each PEM block or DER object are detected automatically.
+PKCS#7 (.p7b) inputs are expanded into the certificates and CRLs they contain.
+OpenSSH private keys and SSH public keys in authorized_keys format are also accepted, and can be produced with 'key-encoding'.
 
-Usage: %[1]s [FLAGS]... TYPE IN_FILE OUT_FILE
+Usage: %[1]s [FLAGS]... [TYPE] IN_FILE OUT_FILE
 
 TYPE:
-  The type of file to format. May be one of 'cert' or 'private_key'.
+  Optionally requires every object in IN_FILE to be of this type.
+  May be one of 'cert', 'csr', 'crl', 'private_key', or 'public_key'.
 
 IN_FILE:
   The file to use as input.
@@ -30,14 +34,23 @@ Flags:
 	}
 
 	var (
-		sourceFormat format.Encoding
-		targetFormat format.Encoding
+		sourceFormat  format.Encoding
+		targetFormat  format.Encoding
+		keepEncrypted bool
+		keyEncoding   string
+		publicKey     bool
+		toP7b         bool
 	)
 
-	flags.Bool("from-der", false, "Specifies that the source format is DER")
-	flags.Bool("from-pem", false, "Specifies that the source format is PEM")
+	flags.Bool("from-der", false, "Requires the source format to be DER")
+	flags.Bool("from-pem", false, "Requires the source format to be PEM")
 	flags.Bool("to-der", false, "Specifies that the target format is DER")
 	flags.Bool("to-pem", false, "Specifies that the target format is PEM")
+	flags.BoolVar(&toP7b, "to-p7b", false, "Specifies that all certificates and CRLs should be bundled into a PKCS#7 (.p7b) file. This is DER encoded unless 'to-pem' is specified")
+	flags.BoolVar(&keepEncrypted, "keep-encrypted", false, "Specifies that an encrypted private key should be output without decrypting it")
+	flags.StringVar(&keyEncoding, "key-encoding", "", "Specifies that keys should be converted to another encoding. May be one of pkcs1, pkcs8, sec1, pkix, pkcs1-public, openssh, ssh. The OpenSSH formats are text, so they imply 'to-pem'")
+	flags.BoolVar(&publicKey, "public-key", false, "Specifies that the public key should be extracted from private keys as PKIX (SubjectPublicKeyInfo). Same as 'key-encoding=pkix'")
+	passFlags := addPassphraseFlags(flags, "", "private key, if it's encrypted")
 	if err := flags.Parse(args); err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
@@ -55,10 +68,11 @@ Flags:
 		}
 	})
 
-	if sourceFormat == 0 {
-		fmt.Println("Must specify a source format")
-		flags.Usage()
-		os.Exit(1)
+	if targetFormat == 0 && toP7b {
+		targetFormat = format.EncodingDer
+	}
+	if targetFormat == 0 && isOpenSSHEncoding(keyEncoding) {
+		targetFormat = format.EncodingPem
 	}
 	if targetFormat == 0 {
 		fmt.Println("Must specify a target format")
@@ -66,38 +80,92 @@ Flags:
 		os.Exit(1)
 	}
 
-	if sourceFormat == targetFormat {
-		fmt.Println("Formats are the same, exiting")
-		os.Exit(0)
-	}
-
-	if flags.NArg() < 3 {
-		fmt.Println("Must specify TYPE, IN_FILE, and OUT_FILE")
+	var typeArg, inArg, outArg string
+	switch flags.NArg() {
+	case 2:
+		inArg, outArg = flags.Arg(0), flags.Arg(1)
+	case 3:
+		typeArg, inArg, outArg = flags.Arg(0), flags.Arg(1), flags.Arg(2)
+	default:
+		fmt.Println("Must specify IN_FILE and OUT_FILE")
 		flags.Usage()
 		os.Exit(1)
 	}
-	typeArg, inArg, outArg := flags.Arg(0), flags.Arg(1), flags.Arg(2)
-	switch strings.ToLower(typeArg) {
-	case "cert":
-		fallthrough
-	case "certificate":
-		if err := format.Cert(sourceFormat, targetFormat, inArg, outArg); err != nil {
-			if errors.Is(err, format.ErrCancelOverwrite) {
-				fmt.Println(err.Error())
-				os.Exit(0)
-			}
+
+	var opts []format.ConvertOpt
+	if sourceFormat != 0 {
+		opts = append(opts, format.ConvertFrom(sourceFormat))
+	}
+	if typeArg != "" {
+		filter, ok := objectTypeFilter(typeArg)
+		if !ok {
+			fmt.Printf("Unknown file type '%s'\n", typeArg)
+			os.Exit(1)
+		}
+		opts = append(opts, format.ConvertOnly(filter))
+	}
+	if toP7b {
+		opts = append(opts, format.ConvertToPKCS7())
+	}
+	if publicKey {
+		if keyEncoding != "" {
+			fmt.Println("Only one of 'key-encoding' and 'public-key' may be specified")
+			os.Exit(1)
+		}
+		keyEncoding = "pkix"
+	}
+	if keyEncoding != "" {
+		target, err := format.ParseKeyEncoding(keyEncoding)
+		if err != nil {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
-	case "key":
-		fallthrough
-	case "private_key":
-		if err := format.Key(sourceFormat, targetFormat, inArg, outArg); err != nil {
+		opts = append(opts, format.ConvertKeysTo(target))
+	}
+	if !keepEncrypted {
+		passphrase, err := passFlags.source("Private key passphrase", false)
+		if err != nil {
 			fmt.Println(err.Error())
 			os.Exit(1)
 		}
-	default:
-		fmt.Printf("Unknown file type '%s'", typeArg)
+		opts = append(opts, format.DecryptKeys(passphrase))
+	}
+
+	if err := format.Convert(targetFormat, inArg, outArg, opts...); err != nil {
+		fmt.Println(err.Error())
+		if errors.Is(err, format.ErrCancelOverwrite) {
+			os.Exit(0)
+		}
 		os.Exit(1)
 	}
 }
+
+func objectTypeFilter(typeArg string) (func(format.ObjectType) bool, bool) {
+	only := func(objType format.ObjectType) func(format.ObjectType) bool {
+		return func(t format.ObjectType) bool {
+			return t == objType
+		}
+	}
+
+	switch strings.ToLower(typeArg) {
+	case "cert", "certificate":
+		return only(format.ObjectCertificate), true
+	case "csr":
+		return only(format.ObjectCSR), true
+	case "crl":
+		return only(format.ObjectCRL), true
+	case "key", "private_key":
+		return format.ObjectType.IsPrivateKey, true
+	case "public_key":
+		return func(t format.ObjectType) bool {
+			return t == format.ObjectPKIXPublicKey || t == format.ObjectPKCS1PublicKey || t == format.ObjectSSHPublicKey
+		}, true
+	default:
+		return nil, false
+	}
+}
+
+func isOpenSSHEncoding(keyEncoding string) bool {
+	target, err := format.ParseKeyEncoding(keyEncoding)
+	return err == nil && (target == format.ObjectOpenSSHPrivateKey || target == format.ObjectSSHPublicKey)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+)
+
+func jwk(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' exports a certificate, public key, or private key as a JSON Web Key (JWK).
+If more than one file is given, or 'jwks' is specified, a JWK set is created with a key for each file.
+
+Usage: %[1]s [FLAGS] FILE [FILE]...
+
+FILE:
+  A file containing PEM or DER encoded certificates, a public key, or a private key.
+  If it contains certificates, the first is the key's certificate and all are included in 'x5c'.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		asSet   bool
+		private bool
+		use     string
+		kid     string
+		outPath string
+	)
+
+	flags.BoolVar(&asSet, "jwks", false, "Specifies that a JWK set should be output, even for a single file")
+	flags.BoolVar(&private, "private", false, "Specifies that the private key members should be included. The file must contain a private key")
+	flags.StringVar(&use, "use", "sig", "Specifies the 'use' member of each key. An empty value omits it")
+	flags.StringVar(&kid, "kid", "", "Specifies the 'kid' member, instead of the RFC 7638 thumbprint. Only valid for a single key")
+	flags.StringVar(&outPath, "out", "", "Specifies a file to write the JSON to. Default is to print it")
+	passFlags := addPassphraseFlags(flags, "", "private key, if it's encrypted")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Need at least 1 path argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if kid != "" && flags.NArg() > 1 {
+		fmt.Println("The 'kid' flag may only be used with a single file")
+		os.Exit(1)
+	}
+
+	passphrase, err := passFlags.source("Private key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	opts := []business.JwkOpt{business.JwkUse(use), business.JwkKeyPassphrase(passphrase)}
+	if kid != "" {
+		opts = append(opts, business.JwkKid(kid))
+	}
+	if private {
+		opts = append(opts, business.JwkIncludePrivate())
+	}
+
+	var result interface{}
+	if asSet || flags.NArg() > 1 {
+		result, err = business.NewJwksFromFiles(flags.Args(), opts...)
+	} else {
+		result, err = business.NewJwkFromFile(flags.Arg(0), opts...)
+	}
+	if err != nil {
+		fmt.Printf("Failed to create JWK: %v\n", err)
+		os.Exit(1)
+	}
+
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		fmt.Printf("Failed to encode JWK: %v\n", err)
+		os.Exit(1)
+	}
+	if outPath == "" {
+		fmt.Println(string(out))
+		return
+	}
+	if err := ioutil.WriteFile(outPath, append(out, '\n'), 0600); err != nil {
+		fmt.Printf("Failed to write JWK to '%s': %v\n", outPath, err)
+		os.Exit(1)
+	}
+}
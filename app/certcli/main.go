@@ -21,9 +21,24 @@ COMMAND:
   cert-info View the details of a PEM or DER encoded certificate.
   csr       Generate a CSR with provided details.
   sign      Sign a CSR with a given CA cert and key and create a client cert, server cert, or sub-CA.
-  format    Change the encoding of a certificate or private key between PEM and DER.
+  format    Change the encoding of a certificate, CSR, CRL, or key between PEM and DER.
+  pkcs12    Pack a certificate and key into a PKCS#12 (.p12/.pfx) file, or unpack one.
+  jwk       Export certificates and keys as a JSON Web Key or JWK set.
+  ssh-sign  Sign an SSH public key to create an OpenSSH user or host certificate.
+  ssh-ca    Print an SSH CA's public key for TrustedUserCAKeys or known_hosts.
+  ca        Create and inspect a CA directory that records every certificate it issues.
+  revoke    Revoke a certificate issued from a CA directory.
+  crl       Sign a full or delta CRL of the certificates revoked in a CA directory.
+  renew     Reissue a certificate with a new validity period, optionally with a new key.
+  cross-sign
+            Re-issue a CA certificate under a different CA, keeping its subject and key.
+  ocsp-serve
+            Run an OCSP responder for the certificates issued from a CA directory.
 
 See each command's help text for more info.
+
+Project-wide defaults may be set in a JSON config file, './.certcli.json' or the path in CERTCLI_CONFIG.
+  outFormat  The default 'out-format' of commands that write artifacts, 'der' or 'pem'.
 `)
 	}
 
@@ -37,12 +52,24 @@ See each command's help text for more info.
 		return
 	}
 
+	config = loadConfig()
+
 	cmdMap := map[string]cliCommand{
-		"root-ca":   cacert,
-		"cert-info": certinfo,
-		"csr":       createCsr,
-		"sign":      sign,
-		"format":    formatFile,
+		"root-ca":    cacert,
+		"cert-info":  certinfo,
+		"csr":        createCsr,
+		"sign":       sign,
+		"format":     formatFile,
+		"pkcs12":     pkcs12,
+		"jwk":        jwk,
+		"ssh-sign":   sshSign,
+		"ssh-ca":     sshCa,
+		"ca":         ca,
+		"revoke":     revoke,
+		"crl":        crl,
+		"renew":      renew,
+		"cross-sign": crossSign,
+		"ocsp-serve": ocspServe,
 	}
 
 	for command, fn := range cmdMap {
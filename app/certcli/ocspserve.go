@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+	"net/http"
+	"os"
+	"time"
+)
+
+func ocspServe(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' runs an OCSP responder for the certificates issued from a CA directory.
+Requests are answered with GET or POST at the root path. Revocations from 'revoke' take effect immediately.
+Certificates issued by roots replaced by 'ca rollover' are answered for until the old root expires, signed with its key.
+
+Usage: %[1]s [FLAGS] DIR
+
+DIR:
+  The CA directory.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		listen     string
+		signerCert string
+		signerKey  string
+		validity   time.Duration
+	)
+
+	flags.StringVar(&listen, "listen", ":8888", "Specifies the address to listen on")
+	flags.StringVar(&signerCert, "signer-cert", "", "Specifies a delegated OCSP signing certificate issued by the CA. Responses are signed by the CA key by default")
+	flags.StringVar(&signerKey, "signer-key", "", "Specifies the key of the delegated OCSP signing certificate")
+	flags.DurationVar(&validity, "validity", time.Hour, "Specifies how long responses may be cached by clients")
+	passFlags := addPassphraseFlags(flags, "key-", "signing key, if it's encrypted")
+	prevPassFlags := addPassphraseFlags(flags, "previous-ca-key-", "keys of roots replaced by 'ca rollover', if it differs from the signing key's")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass DIR argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if (signerCert == "") != (signerKey == "") {
+		fmt.Println("The 'signer-cert' and 'signer-key' flags must be specified together")
+		os.Exit(1)
+	}
+	if validity <= 0 {
+		fmt.Println("The 'validity' duration must be positive")
+		os.Exit(1)
+	}
+
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	passphrase, err := passFlags.source("Signing key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	opts := []business.OcspOpt{business.OcspKeyPassphrase(passphrase), business.OcspResponseValidity(validity)}
+	if signerCert != "" {
+		opts = append(opts, business.OcspDelegatedSigner(signerCert, signerKey))
+	}
+	if prevPassFlags.isSet() {
+		prevPassphrase, err := prevPassFlags.source("Previous CA key passphrase", false)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, business.OcspPreviousCaKeyPassphrase(prevPassphrase))
+	}
+	responder, err := business.NewOcspResponder(dir, opts...)
+	if err != nil {
+		fmt.Printf("Failed to start OCSP responder: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Serving OCSP for '%s' on %s\n", dir.Config.Name, listen)
+	if err := http.ListenAndServe(listen, responder); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"github.com/spf13/pflag"
+	"strings"
+)
+
+type passphraseFlags struct {
+	prefix  string
+	envVar  string
+	file    string
+	kdfName string
+	encrypt bool
+}
+
+// addPassphraseFlags registers the flags used to read a key passphrase. The prefix distinguishes multiple keys in the same command.
+func addPassphraseFlags(flags *pflag.FlagSet, prefix, keyDesc string) *passphraseFlags {
+	p := &passphraseFlags{prefix: prefix}
+	flags.StringVar(&p.envVar, prefix+"passphrase-env", "", fmt.Sprintf("Specifies an environment variable holding the passphrase for the %s", keyDesc))
+	flags.StringVar(&p.file, prefix+"passphrase-file", "", fmt.Sprintf("Specifies a file whose first line is the passphrase for the %s", keyDesc))
+	return p
+}
+
+// addEncryptFlags registers the passphrase flags, plus flags to request encrypted output.
+// The encryption flags are named after the prefix, such as 'encrypt-ca-key' and 'ca-key-kdf' for the prefix 'ca-key-',
+// or 'encrypt-key' and 'key-kdf' without one.
+func addEncryptFlags(flags *pflag.FlagSet, prefix, keyDesc string) *passphraseFlags {
+	p := addPassphraseFlags(flags, prefix, keyDesc)
+	keyName := strings.TrimSuffix(prefix, "-")
+	if keyName == "" {
+		keyName = "key"
+	}
+	flags.BoolVar(&p.encrypt, "encrypt-"+keyName, false, fmt.Sprintf("Specifies that the %[1]s should be written as an encrypted PKCS#8 key. The passphrase is prompted for unless '%[2]spassphrase-env' or '%[2]spassphrase-file' is specified", keyDesc, prefix))
+	flags.StringVar(&p.kdfName, keyName+"-kdf", "pbkdf2", fmt.Sprintf("Specifies the key derivation function used to encrypt the %s. May be one of pbkdf2, scrypt", keyDesc))
+	return p
+}
+
+// isSet returns true if the passphrase is read from an environment variable or file, rather than prompted for.
+func (p *passphraseFlags) isSet() bool {
+	return p.envVar != "" || p.file != ""
+}
+
+// source returns the passphrase source selected by the flags, falling back to an interactive prompt.
+func (p *passphraseFlags) source(prompt string, confirm bool) (format.PassphraseFunc, error) {
+	switch {
+	case p.envVar != "" && p.file != "":
+		return nil, fmt.Errorf("only one of '%[1]spassphrase-env' and '%[1]spassphrase-file' may be specified", p.prefix)
+	case p.envVar != "":
+		return format.PassphraseFromEnv(p.envVar), nil
+	case p.file != "":
+		return format.PassphraseFromFile(p.file), nil
+	default:
+		return format.PassphrasePrompt(prompt, confirm), nil
+	}
+}
+
+// encryption returns the passphrase source and KDF to use for output keys, or a nil source if encryption wasn't requested.
+func (p *passphraseFlags) encryption(prompt string) (format.PassphraseFunc, format.KDF, error) {
+	var kdf format.KDF
+	switch strings.ToLower(p.kdfName) {
+	case "pbkdf2":
+		kdf = format.KDFPBKDF2
+	case "scrypt":
+		kdf = format.KDFScrypt
+	default:
+		return nil, 0, fmt.Errorf("unknown key derivation function '%s'", p.kdfName)
+	}
+	if !p.encrypt && p.envVar == "" && p.file == "" {
+		return nil, kdf, nil
+	}
+	passphrase, err := p.source(prompt, true)
+	return passphrase, kdf, err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+)
+
+func pkcs12(command string, args []string) {
+	usage := func() {
+		fmt.Printf(`'%[1]s' packs a certificate and private key into a password protected PKCS#12 (.p12/.pfx) file, or unpacks one.
+
+Usage: %[1]s SUBCOMMAND [FLAGS]... [ARGS]...
+
+SUBCOMMAND:
+  export  Pack a certificate, its private key, and optionally a CA chain into a PKCS#12 file.
+  import  Unpack the certificate, private key, and CA chain from a PKCS#12 file.
+
+See each subcommand's help text for more info.
+`, command)
+	}
+
+	subcommands := map[string]cliCommand{
+		"export": pkcs12Export,
+		"import": pkcs12Import,
+	}
+
+	if len(args) == 0 {
+		fmt.Println("No subcommand specified")
+		usage()
+		os.Exit(1)
+	}
+	if args[0] == "--help" || args[0] == "-h" {
+		usage()
+		return
+	}
+	if fn, ok := subcommands[args[0]]; ok {
+		fn(command+" "+args[0], args[1:])
+		return
+	}
+	fmt.Printf("Unrecognized subcommand '%s'\n", args[0])
+	os.Exit(1)
+}
+
+func pkcs12Export(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' packs a certificate and private key into a password protected PKCS#12 (.p12/.pfx) file.
+
+Usage: %[1]s [FLAGS] CERT KEY OUT_FILE
+
+CERT:
+  A PEM or DER encoded certificate.
+
+KEY:
+  The certificate's PEM or DER encoded private key.
+
+OUT_FILE:
+  The PKCS#12 file to create.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		chainFiles []string
+		legacy     bool
+	)
+
+	flags.StringSliceVar(&chainFiles, "chain", nil, "Specifies a file of CA certificates to include in the exported bundle. May be given more than once")
+	flags.BoolVar(&legacy, "legacy", false, "Specifies that the exported bundle should use RC2 and 3DES encryption for older clients, instead of AES")
+	p12Flags := addPassphraseFlags(flags, "p12-", "PKCS#12 bundle")
+	keyFlags := addPassphraseFlags(flags, "key-", "private key")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 3 {
+		fmt.Println("Must pass CERT, KEY, and OUT_FILE arguments")
+		flags.Usage()
+		os.Exit(1)
+	}
+	certFile, keyFile, outFile := flags.Arg(0), flags.Arg(1), flags.Arg(2)
+
+	keyPassphrase, err := keyFlags.source("Private key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	password := readPkcs12Password(p12Flags, true)
+
+	opts := []business.Pkcs12Opt{business.Pkcs12KeyPassphrase(keyPassphrase)}
+	for _, chainFile := range chainFiles {
+		opts = append(opts, business.Pkcs12AddChain(chainFile))
+	}
+	if legacy {
+		opts = append(opts, business.Pkcs12Legacy())
+	}
+
+	p12, err := business.NewPkcs12Bundle(certFile, keyFile, password, opts...)
+	if err != nil {
+		fmt.Printf("Failed to create PKCS#12 bundle: %v\n", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(outFile, p12, 0600); err != nil {
+		fmt.Printf("Failed to write PKCS#12 bundle to '%s': %v\n", outFile, err)
+		os.Exit(1)
+	}
+}
+
+func pkcs12Import(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' unpacks the certificate, private key, and CA chain from a PKCS#12 (.p12/.pfx) file.
+
+Usage: %[1]s [FLAGS] IN_FILE
+
+IN_FILE:
+  The PKCS#12 file to unpack.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		certOut  string
+		keyOut   string
+		chainOut string
+	)
+
+	flags.StringVar(&certOut, "cert-out", "", "Specifies a different output path for the imported certificate. Default is './<common-name>.cer'")
+	flags.StringVar(&keyOut, "key-out", "", "Specifies a different output path for the imported private key. Default is './<common-name>.key'")
+	flags.StringVar(&chainOut, "chain-out", "", "Specifies a different output path for the imported CA chain. Default is './<common-name>-chain.cer'")
+	outFormat := addOutFormatFlag(flags)
+	p12Flags := addPassphraseFlags(flags, "p12-", "PKCS#12 bundle")
+	keyFlags := addEncryptFlags(flags, "key-", "private key")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass the IN_FILE argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	inFile := flags.Arg(0)
+
+	encoding := parseOutFormat(*outFormat)
+	keyPassphrase, kdf, err := keyFlags.encryption("Private key passphrase")
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	password := readPkcs12Password(p12Flags, false)
+
+	contents, err := business.ReadPkcs12Bundle(inFile, password, keyPassphrase, kdf)
+	if err != nil {
+		fmt.Printf("Failed to read PKCS#12 bundle: %v\n", err)
+		os.Exit(1)
+	}
+	commonName, err := business.CertCommonName(contents.Cert)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if certOut == "" {
+		certOut = commonName + ".cer"
+	}
+	if keyOut == "" {
+		keyOut = commonName + ".key"
+	}
+	if chainOut == "" {
+		chainOut = commonName + "-chain.cer"
+	}
+
+	if err := ioutil.WriteFile(certOut, format.EncodeCerts(encoding, contents.Cert), 0600); err != nil {
+		fmt.Printf("Failed to write certificate to '%s': %v\n", certOut, err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(keyOut, format.EncodePrivateKey(encoding, contents.Key), 0600); err != nil {
+		fmt.Printf("Failed to write private key to '%s': %v\n", keyOut, err)
+		os.Exit(1)
+	}
+	if len(contents.Chain) > 0 {
+		if err := ioutil.WriteFile(chainOut, format.EncodeCerts(encoding, contents.Chain...), 0600); err != nil {
+			fmt.Printf("Failed to write CA chain to '%s': %v\n", chainOut, err)
+			os.Exit(1)
+		}
+	}
+}
+
+func readPkcs12Password(p12Flags *passphraseFlags, confirm bool) string {
+	source, err := p12Flags.source("PKCS#12 password", confirm)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	password, err := source()
+	if err != nil {
+		fmt.Printf("Failed to read PKCS#12 password: %v\n", err)
+		os.Exit(1)
+	}
+	return string(password)
+}
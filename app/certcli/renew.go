@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+func renew(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' reissues a certificate with the same subject, SANs, and usages, but a new serial number and validity period.
+By default, the new certificate is valid for as long as the original was, starting now. The existing key is reused unless 'rekey' is specified.
+Outputs are named after the subject's common name by default, like 'sign' and 'csr', so they may replace the original files.
+
+Usage: %[1]s [FLAGS] CERT_FILE CA_CERT CA_KEY
+       %[1]s [FLAGS] --ca-dir DIR CERT_FILE
+
+CERT_FILE:
+  The certificate to renew. It must have been issued by the CA.
+
+CA_CERT:
+  The CA's certificate.
+
+CA_KEY:
+  The CA key to use to sign the renewed certificate.
+
+With 'ca-dir', the CA certificate and key are read from the CA directory, and the renewed certificate is recorded in its index.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		rekey       bool
+		keyTypeName string
+		keyBits     int
+		certOut     string
+		keyOut      string
+		caDirArg    string
+		policyFile  string
+		minRsa      int
+		minEcdsa    int
+	)
+
+	flags.BoolVar(&rekey, "rekey", false, "Specifies that a new key should be generated, of the same type and size as the old key unless 'key-type' is specified")
+	flags.StringVar(&keyTypeName, "key-type", "", "Specifies the type of key to generate with 'rekey'. May be one of "+strings.Join(business.KeyTypeNames(), ", "))
+	flags.IntVar(&keyBits, "key-bits", 4096, "Specifies the RSA key size in bits with 'rekey'. Only valid with the 'rsa' key type")
+	flags.StringVar(&certOut, "cert-out", "", "Specifies a different output path for the certificate. Default is './<subject-common-name>.cer'")
+	flags.StringVar(&keyOut, "key-out", "", "Specifies a different output path for the new key. Default is './<subject-common-name>.key'")
+	flags.StringVar(&caDirArg, "ca-dir", "", "Specifies a CA directory created by 'ca init' to sign with and record the certificate in")
+	flags.StringVar(&policyFile, "policy-file", "", "Specifies a JSON issuance policy file that the renewed certificate must satisfy. With 'ca-dir', the directory's policy is enforced too")
+	flags.IntVar(&minRsa, "min-rsa-bits", business.DefaultKeyStrengthPolicy.MinRSABits, "Specifies the minimum RSA key size accepted for the renewed certificate")
+	flags.IntVar(&minEcdsa, "min-ecdsa-bits", business.DefaultKeyStrengthPolicy.MinECDSABits, "Specifies the minimum ECDSA curve size accepted for the renewed certificate")
+	validFlags := addValidityFlags(flags, "Default is the length of the original certificate's validity period")
+	outFormat := addOutFormatFlag(flags)
+	caPassFlags := addPassphraseFlags(flags, "ca-key-", "CA key, if it's encrypted")
+	keyPassFlags := addEncryptFlags(flags, "", "new key")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	var caDir *business.CaDir
+	if caDirArg != "" {
+		if flags.NArg() != 1 {
+			fmt.Println("Must pass only the CERT_FILE argument with 'ca-dir'")
+			flags.Usage()
+			os.Exit(1)
+		}
+		var err error
+		caDir, err = business.OpenCaDir(caDirArg)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	} else if flags.NArg() < 3 {
+		fmt.Println("Must pass CERT_FILE, CA_CERT, and CA_KEY arguments")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if !rekey && (keyTypeName != "" || flags.Changed("key-bits") || flags.Changed("key-out") || flags.Changed("encrypt-key")) {
+		fmt.Println("The 'key-type', 'key-bits', 'key-out', and 'encrypt-key' flags require 'rekey'")
+		os.Exit(1)
+	}
+
+	validity, err := validFlags.validity()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	encoding := parseOutFormat(*outFormat)
+	caPassphrase, err := caPassFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	certFile := flags.Arg(0)
+	caCertFile := flags.Arg(1)
+	caKeyFile := flags.Arg(2)
+	opts := []business.RenewOpt{
+		business.RenewCaKeyPassphrase(caPassphrase),
+		business.RenewValidity(validity),
+		business.RenewKeyPolicy(business.KeyStrengthPolicy{
+			MinRSABits:   minRsa,
+			MinECDSABits: minEcdsa,
+		}),
+	}
+	if policyFile != "" {
+		policy, err := business.LoadIssuancePolicy(policyFile)
+		if err != nil {
+			fmt.Printf("Failed to load issuance policy '%s': %v\n", policyFile, err)
+			os.Exit(1)
+		}
+		opts = append(opts, business.RenewPolicy(policy))
+	}
+	if caDir != nil {
+		caCertFile = caDir.CertFile()
+		caKeyFile = caDir.KeyFile()
+		opts = append(opts, business.RenewRecordIn(caDir))
+	}
+	if rekey {
+		opts = append(opts, business.RenewRekey())
+		if keyTypeName != "" {
+			keyType, err := business.ParseKeyType(keyTypeName)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			if flags.Changed("key-bits") && keyType != business.KeyTypeRSA {
+				fmt.Println("The 'key-bits' flag is only valid with the 'rsa' key type")
+				os.Exit(1)
+			}
+			opts = append(opts, business.RenewKeyType(keyType, keyBits))
+		}
+		passphrase, kdf, err := keyPassFlags.encryption("New key passphrase")
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if passphrase != nil {
+			opts = append(opts, business.RenewEncryptKey(passphrase, kdf))
+		}
+	}
+
+	cert, key, err := business.RenewCert(certFile, caCertFile, caKeyFile, opts...)
+	var policyErr *business.PolicyError
+	if errors.As(err, &policyErr) {
+		fmt.Println("Failed to renew certificate, it violates the issuance policy:")
+		for _, violation := range policyErr.Violations {
+			fmt.Printf("  %s\n", violation)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Printf("Failed to renew certificate: %v\n", err)
+		os.Exit(1)
+	}
+
+	commonName, err := business.CertCommonName(cert)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	if certOut == "" {
+		certOut = commonName + ".cer"
+	}
+	if err := ioutil.WriteFile(certOut, format.EncodeCerts(encoding, cert), 0600); err != nil {
+		fmt.Printf("Failed to write renewed cert to '%s': %v\n", certOut, err)
+		os.Exit(1)
+	}
+	if key != nil {
+		if keyOut == "" {
+			keyOut = commonName + ".key"
+		}
+		if err := ioutil.WriteFile(keyOut, format.EncodePrivateKey(encoding, key), 0600); err != nil {
+			fmt.Printf("Failed to write new key to '%s': %v\n", keyOut, err)
+			os.Exit(1)
+		}
+	}
+}
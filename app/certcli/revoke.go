@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func revoke(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' marks a certificate issued from a CA directory as revoked. Run 'crl' afterward to publish the change.
+A certificate revoked with 'certificateHold' may later be revoked permanently, or released with 'removeFromCRL'.
+
+Usage: %[1]s [FLAGS] DIR SERIAL|CERT_FILE
+
+DIR:
+  The CA directory that issued the certificate.
+
+SERIAL:
+  The certificate's serial number in hex, as listed by 'ca list'. Colons are allowed.
+
+CERT_FILE:
+  The PEM or DER encoded certificate to revoke, if the serial number isn't known.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var reasonName string
+
+	flags.StringVar(&reasonName, "reason", "unspecified", "Specifies the RFC 5280 reason code. May be one of "+strings.Join(business.RevocationReasonNames(), ", "))
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 2 {
+		fmt.Println("Must pass DIR and SERIAL or CERT_FILE arguments")
+		flags.Usage()
+		os.Exit(1)
+	}
+	reason, err := business.ParseRevocationReason(reasonName)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	var entry *business.IndexEntry
+	target := flags.Arg(1)
+	if _, statErr := os.Stat(target); statErr == nil {
+		entry, err = dir.RevokeCertFile(target, reason, time.Now())
+	} else {
+		entry, err = dir.Revoke(target, reason, time.Now())
+	}
+	if err != nil {
+		fmt.Printf("Failed to revoke certificate: %v\n", err)
+		os.Exit(1)
+	}
+	if reason == business.ReasonRemoveFromCRL {
+		fmt.Printf("Released certificate %s from hold\n", entry.Serial)
+		return
+	}
+	fmt.Printf("Revoked certificate %s (%s)\n", entry.Serial, entry.RevocationReason)
+}
+
+func crl(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' signs a Certificate Revocation List of the certificates revoked in a CA directory.
+The CRL is stored in the CA directory as 'ca.crl', or 'delta.crl' for a delta CRL, and optionally written elsewhere too.
+A full CRL also updates 'rollovers/<new-serial>/old-ca.crl' for each root replaced by 'ca rollover' that hasn't expired,
+listing the revoked certificates that root issued. It's signed with the old root's key.
+
+Usage: %[1]s [FLAGS] DIR
+
+DIR:
+  The CA directory.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		nextUpdate time.Duration
+		delta      bool
+		outPath    string
+	)
+
+	flags.DurationVar(&nextUpdate, "next-update", 7*24*time.Hour, "Specifies how long until the next CRL is due, which sets the CRL's nextUpdate field")
+	flags.BoolVar(&delta, "delta", false, "Specifies that a delta CRL should be created, with only the changes since the last full CRL")
+	flags.StringVar(&outPath, "out", "", "Specifies an additional path to write the CRL to")
+	outFormat := addOutFormatFlag(flags)
+	passFlags := addPassphraseFlags(flags, "ca-key-", "CA key, if it's encrypted")
+	prevPassFlags := addPassphraseFlags(flags, "previous-ca-key-", "keys of roots replaced by 'ca rollover', if it differs from the CA key's")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass DIR argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if nextUpdate <= 0 {
+		fmt.Println("The 'next-update' duration must be positive")
+		os.Exit(1)
+	}
+
+	encoding := parseOutFormat(*outFormat)
+	caPassphrase, err := passFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	dir, err := business.OpenCaDir(flags.Arg(0))
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	opts := []business.CrlOpt{business.CrlNextUpdate(nextUpdate), business.CrlCaKeyPassphrase(caPassphrase)}
+	if prevPassFlags.isSet() {
+		prevPassphrase, err := prevPassFlags.source("Previous CA key passphrase", false)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		opts = append(opts, business.CrlPreviousCaKeyPassphrase(prevPassphrase))
+	}
+	if delta {
+		opts = append(opts, business.CrlDelta())
+	}
+	der, err := dir.NewCrl(opts...)
+	if err != nil {
+		fmt.Printf("Failed to create CRL: %v\n", err)
+		os.Exit(1)
+	}
+
+	if outPath != "" {
+		out, err := format.Encode(encoding, []format.Object{{Type: format.ObjectCRL, Der: der}})
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if err := ioutil.WriteFile(outPath, out, 0644); err != nil {
+			fmt.Printf("Failed to write CRL to '%s': %v\n", outPath, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -1,11 +1,15 @@
 package main
 
 import (
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"github.com/drognisep/certserver/business"
+	"github.com/drognisep/certserver/business/format"
 	"github.com/spf13/pflag"
 	"io/ioutil"
 	"os"
+	"strings"
 )
 
 func sign(command string, args []string) {
@@ -14,6 +18,7 @@ func sign(command string, args []string) {
 		fmt.Printf(`'%[1]s' signs a Certificate Signing Request with a CA cert. This can be used to create sub-CAs.
 
 Usage: %[1]s [FLAGS] CSR_FILE CA_CERT CA_KEY
+       %[1]s [FLAGS] --ca-dir DIR CSR_FILE
 
 CSR_FILE:
   The CSR file that should be signed by the CA.
@@ -24,52 +29,194 @@ CA_CERT:
 CA_KEY:
   The CA key to use to sign the CSR.
 
+With 'ca-dir', the CA certificate and key are read from the CA directory, and the issued certificate is recorded in its index.
+The OCSP, issuer, and CRL URLs set with 'ca urls' are embedded in the certificate, unless the URL flags are specified to replace them.
+
+Extensions and certificate policies may be added with the extension flags, or in a profile. For example,
+'--cert-policy "2.23.140.1.2.2;cps=https://example.com/cps"' adds a policy with a CPS URI.
+
+With a CA profile such as 'is-ca', the path length and name constraint flags limit what the sub-CA may issue. For example,
+'--permit-dns .team.internal' creates a sub-CA that may only issue for subdomains of 'team.internal'.
+
 Flags:
 %s`, command, flags.FlagUsages())
 	}
 
 	var (
-		isCA     bool
-		isClient bool
-		certOut  string
+		isCA        bool
+		isClient    bool
+		profileName string
+		profileFile string
+		policyFile  string
+		certOut     string
+		minRsa      int
+		minEcdsa    int
+		caChain     []string
+		chainOut    string
+		fullOut     string
+		caDirArg    string
 	)
 
-	flags.BoolVar(&isCA, "is-ca", false, "Specifies that the output certificate should be for a CA")
-	flags.BoolVar(&isClient, "is-client", false, "Specifies that the output certificate should be for client auth")
+	flags.BoolVar(&isCA, "is-ca", false, "Specifies that the output certificate should be for a CA. Same as '--profile ca'")
+	flags.BoolVar(&isClient, "is-client", false, "Specifies that the output certificate should be for client auth. Same as '--profile client'")
+	flags.StringVar(&profileName, "profile", business.ProfileServer, "Specifies the certificate profile, which sets the key usages, extensions, and default validity. Built-in profiles are "+strings.Join(business.BuiltinProfiles().Names(), ", "))
+	flags.StringVar(&profileFile, "profile-file", "", "Specifies a YAML or JSON file of profiles, which may replace built-in profiles. Default is 'profiles.yaml' in the CA directory with 'ca-dir', if it exists")
+	flags.StringVar(&policyFile, "policy-file", "", "Specifies a JSON issuance policy file that the CSR must satisfy. With 'ca-dir', the directory's policy is enforced too")
 	flags.StringVar(&certOut, "cert-out", "", "Specifies a different output path for the certificate. Default is './<subject-common-name>.cer'.")
-
+	flags.IntVar(&minRsa, "min-rsa-bits", business.DefaultKeyStrengthPolicy.MinRSABits, "Specifies the minimum RSA key size accepted in the CSR")
+	flags.IntVar(&minEcdsa, "min-ecdsa-bits", business.DefaultKeyStrengthPolicy.MinECDSABits, "Specifies the minimum ECDSA curve size accepted in the CSR")
+	flags.StringSliceVar(&caChain, "ca-chain", nil, "Specifies a file of intermediate and root certificates above CA_CERT, used to build the output chain. May be given more than once")
+	flags.StringVar(&chainOut, "chain-out", "", "Specifies a path to write the CA chain to, starting with CA_CERT and ending with the root if it's known")
+	flags.StringVar(&fullOut, "fullchain-out", "", "Specifies a path to write a PEM file with the new certificate followed by the CA chain")
+	flags.StringVar(&caDirArg, "ca-dir", "", "Specifies a CA directory created by 'ca init' to sign with and record the certificate in")
+	extFlags := addExtensionFlags(flags)
+	urlFlags := addCaURLFlags(flags)
+	validFlags := addValidityFlags(flags, "Default is the profile's validity period")
+	constraintFlags := addCaConstraintFlags(flags, "Default is one less than the issuing CA's, or unlimited if it has none")
+	outFormat := addOutFormatFlag(flags)
+	passFlags := addPassphraseFlags(flags, "ca-key-", "CA key, if it's encrypted")
 	if err := flags.Parse(args); err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
 	}
 
-	if flags.NArg() < 3 {
+	var caDir *business.CaDir
+	if caDirArg != "" {
+		if flags.NArg() != 1 {
+			fmt.Println("Must pass only the CSR_FILE argument with 'ca-dir'")
+			flags.Usage()
+			os.Exit(1)
+		}
+		var err error
+		caDir, err = business.OpenCaDir(caDirArg)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+	} else if flags.NArg() < 3 {
 		fmt.Println("Must pass CSR_FILE, CA_CERT, and CA_KEY arguments")
 		flags.Usage()
 		os.Exit(1)
 	}
 
-	if isCA && isClient {
-		fmt.Println("Only one of 'is-ca' and 'is-client' may be specified")
+	if (isCA && isClient) || ((isCA || isClient) && flags.Changed("profile")) {
+		fmt.Println("Only one of 'is-ca', 'is-client', and 'profile' may be specified")
 		flags.Usage()
 		os.Exit(1)
 	}
-
-	var certType business.CertType
 	switch {
 	case isCA:
-		certType = business.CertTypeCA
+		profileName = business.ProfileCA
 	case isClient:
-		certType = business.CertTypeClientAuth
-	default:
-		certType = business.CertTypeServerAuth
+		profileName = business.ProfileClient
+	}
+
+	var profiles business.Profiles
+	var err error
+	if profileFile == "" && caDir != nil {
+		profiles, err = caDir.Profiles()
+	} else {
+		profiles, err = business.LoadProfiles(profileFile)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	profile, err := profiles.Get(profileName)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if !profile.IsCA && constraintFlags.isSet(flags) {
+		fmt.Println("The path length and name constraint flags require a CA profile")
+		os.Exit(1)
+	}
+
+	validity, err := validFlags.validity()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	encoding := parseOutFormat(*outFormat)
+	caPassphrase, err := passFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
 	}
 
 	csrFile := flags.Arg(0)
 	caCertFile := flags.Arg(1)
 	caKeyFile := flags.Arg(2)
+	signOpts := []business.SignOpt{
+		business.SignKeyPolicy(business.KeyStrengthPolicy{
+			MinRSABits:   minRsa,
+			MinECDSABits: minEcdsa,
+		}),
+		business.SignCaKeyPassphrase(caPassphrase),
+		business.SignValidity(validity),
+	}
+	if profile.IsCA {
+		nameConstraints, err := constraintFlags.nameConstraints()
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		signOpts = append(signOpts, business.SignNameConstraints(nameConstraints))
+		if flags.Changed("max-path-len") {
+			signOpts = append(signOpts, business.SignMaxPathLen(constraintFlags.maxPathLen))
+		}
+	}
+	extOpts, err := extFlags.opts()
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	signOpts = append(signOpts, extOpts...)
+	if policyFile != "" {
+		policy, err := business.LoadIssuancePolicy(policyFile)
+		if err != nil {
+			fmt.Printf("Failed to load issuance policy '%s': %v\n", policyFile, err)
+			os.Exit(1)
+		}
+		signOpts = append(signOpts, business.SignPolicy(policy))
+	}
+	if caDir != nil {
+		caCertFile = caDir.CertFile()
+		caKeyFile = caDir.KeyFile()
+		signOpts = append(signOpts, business.SignRecordIn(caDir))
+	}
+	if urlFlags.isSet(flags) {
+		urls := urlFlags.urls()
+		if err := urls.Validate(); err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		signOpts = append(signOpts, business.SignURLs(urls))
+	}
+
+	var chain []*x509.Certificate
+	if chainOut != "" || fullOut != "" {
+		chain, err = business.BuildCaChain(caCertFile, caChain...)
+		if err != nil {
+			fmt.Printf("Failed to build CA chain: %v\n", err)
+			os.Exit(1)
+		}
+	} else if len(caChain) > 0 {
+		fmt.Println("The 'ca-chain' flag requires 'chain-out' or 'fullchain-out'")
+		os.Exit(1)
+	}
 
-	cert, commonName, err := business.SignCsr(csrFile, caCertFile, caKeyFile, certType)
+	cert, commonName, err := business.SignCsr(csrFile, caCertFile, caKeyFile, profile, signOpts...)
+	var policyErr *business.PolicyError
+	if errors.As(err, &policyErr) {
+		fmt.Println("Failed to create signed certificate, the CSR violates the issuance policy:")
+		for _, violation := range policyErr.Violations {
+			fmt.Printf("  %s\n", violation)
+		}
+		os.Exit(1)
+	}
 	if err != nil {
 		fmt.Printf("Failed to create signed certificate: %v\n", err)
 		os.Exit(1)
@@ -79,8 +226,26 @@ Flags:
 	if certOut != "" {
 		out = certOut
 	}
-	if err := ioutil.WriteFile(out, cert, 0600); err != nil {
+	if err := ioutil.WriteFile(out, format.EncodeCerts(encoding, cert), 0600); err != nil {
 		fmt.Printf("Failed to write signed cert to '%s': %v\n", out, err)
 		os.Exit(1)
 	}
+
+	var chainDer [][]byte
+	for _, chainCert := range chain {
+		chainDer = append(chainDer, chainCert.Raw)
+	}
+	if chainOut != "" {
+		if err := ioutil.WriteFile(chainOut, format.EncodeCerts(encoding, chainDer...), 0600); err != nil {
+			fmt.Printf("Failed to write CA chain to '%s': %v\n", chainOut, err)
+			os.Exit(1)
+		}
+	}
+	if fullOut != "" {
+		fullChain := append([][]byte{cert}, chainDer...)
+		if err := ioutil.WriteFile(fullOut, format.EncodeCerts(format.EncodingPem, fullChain...), 0600); err != nil {
+			fmt.Printf("Failed to write full chain to '%s': %v\n", fullOut, err)
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+func sshSign(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' signs an SSH public key with a CA key to create an OpenSSH user or host certificate.
+
+Usage: %[1]s [FLAGS] PUBLIC_KEY CA_KEY
+
+PUBLIC_KEY:
+  The key to certify. May be an OpenSSH public key (id_*.pub), or a PEM or DER certificate, CSR, public key, or private key.
+
+CA_KEY:
+  The CA key to sign with. May be a key created by root-ca, or a dedicated OpenSSH private key.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		isHost       bool
+		keyId        string
+		principals   []string
+		validFrom    string
+		validFor     time.Duration
+		criticalOpts []string
+		extensions   []string
+		noExtensions bool
+		certOut      string
+		userValidity = 24 * time.Hour
+		hostValidity = 90 * 24 * time.Hour
+	)
+
+	flags.BoolVar(&isHost, "host", false, "Specifies that a host certificate should be created instead of a user certificate")
+	flags.StringVar(&keyId, "key-id", "", "Specifies the key ID logged by sshd when the certificate is used. Default is the first principal")
+	flags.StringSliceVarP(&principals, "principal", "n", nil, "Specifies a user name or host name the certificate is valid for. May be comma separated or given more than once. At least one is required")
+	flags.StringVar(&validFrom, "valid-from", "", "Specifies the start of the validity period as an RFC 3339 time. Default is now")
+	flags.DurationVar(&validFor, "valid-for", userValidity, fmt.Sprintf("Specifies how long the certificate is valid for. Default is %s for user certificates and %s for host certificates", userValidity, hostValidity))
+	flags.StringArrayVar(&criticalOpts, "critical-option", nil, "Specifies a critical option as NAME or NAME=VALUE, such as 'force-command=/bin/true' or 'source-address=10.0.0.0/8'. May be given more than once")
+	flags.StringArrayVar(&extensions, "extension", nil, "Specifies an extension as NAME or NAME=VALUE, such as 'permit-pty'. May be given more than once")
+	flags.BoolVar(&noExtensions, "no-default-extensions", false, "Specifies that the default user certificate extensions should be left out")
+	flags.StringVar(&certOut, "cert-out", "", "Specifies a different output path for the certificate. Default is PUBLIC_KEY with '.pub' replaced by '-cert.pub'")
+	passFlags := addPassphraseFlags(flags, "ca-key-", "CA key, if it's encrypted")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 2 {
+		fmt.Println("Must pass PUBLIC_KEY and CA_KEY arguments")
+		flags.Usage()
+		os.Exit(1)
+	}
+	if len(principals) == 0 {
+		fmt.Println("Must specify at least one 'principal'")
+		os.Exit(1)
+	}
+
+	caPassphrase, err := passFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	opts := []business.SshSignOpt{business.SshCaKeyPassphrase(caPassphrase)}
+	if isHost {
+		opts = append(opts, business.SshHostCert())
+		if !flags.Changed("valid-for") {
+			validFor = hostValidity
+		}
+	}
+	if noExtensions {
+		opts = append(opts, business.SshClearExtensions())
+	}
+	if keyId != "" {
+		opts = append(opts, business.SshKeyId(keyId))
+	}
+	for _, principal := range principals {
+		opts = append(opts, business.SshPrincipal(principal))
+	}
+
+	start := time.Now()
+	if validFrom != "" {
+		start, err = time.Parse(time.RFC3339, validFrom)
+		if err != nil {
+			fmt.Printf("Invalid 'valid-from' time: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if validFor <= 0 {
+		fmt.Println("The 'valid-for' duration must be positive")
+		os.Exit(1)
+	}
+	opts = append(opts, business.SshValidity(start, validFor))
+
+	for _, opt := range criticalOpts {
+		name, value, _ := strings.Cut(opt, "=")
+		opts = append(opts, business.SshCriticalOption(name, value))
+	}
+	for _, ext := range extensions {
+		name, value, _ := strings.Cut(ext, "=")
+		opts = append(opts, business.SshExtension(name, value))
+	}
+
+	pubKeyFile := flags.Arg(0)
+	caKeyFile := flags.Arg(1)
+	cert, err := business.SignSshKey(pubKeyFile, caKeyFile, opts...)
+	if err != nil {
+		fmt.Printf("Failed to create SSH certificate: %v\n", err)
+		os.Exit(1)
+	}
+
+	out := certOut
+	if out == "" {
+		out = strings.TrimSuffix(pubKeyFile, ".pub") + "-cert.pub"
+	}
+	if err := ioutil.WriteFile(out, cert, 0644); err != nil {
+		fmt.Printf("Failed to write SSH certificate to '%s': %v\n", out, err)
+		os.Exit(1)
+	}
+}
+
+func sshCa(command string, args []string) {
+	flags := pflag.NewFlagSet(command, pflag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Printf(`'%[1]s' prints the public key of an SSH CA so that sshd and ssh will trust the certificates it signs.
+By default a line for sshd's TrustedUserCAKeys file is printed. With 'host', an @cert-authority line for known_hosts is printed.
+
+Usage: %[1]s [FLAGS] CA_FILE
+
+CA_FILE:
+  The CA's certificate, public key, or private key. May also be an OpenSSH public or private key.
+
+Flags:
+%s`, command, flags.FlagUsages())
+	}
+
+	var (
+		hosts   []string
+		outPath string
+	)
+
+	flags.StringSliceVar(&hosts, "host", nil, "Specifies a known_hosts host pattern that the CA is trusted for, such as '*.example.com'. May be comma separated or given more than once")
+	flags.StringVar(&outPath, "out", "", "Specifies a file to append the line to. Default is to print it")
+	passFlags := addPassphraseFlags(flags, "", "CA key, if CA_FILE is an encrypted private key")
+	if err := flags.Parse(args); err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Println("Must pass CA_FILE argument")
+		flags.Usage()
+		os.Exit(1)
+	}
+
+	passphrase, err := passFlags.source("CA key passphrase", false)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	line, err := business.SshCaPublicKeyLine(flags.Arg(0), hosts, passphrase)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+
+	if outPath == "" {
+		fmt.Print(string(line))
+		return
+	}
+	file, err := os.OpenFile(outPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fmt.Printf("Failed to open '%s': %v\n", outPath, err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	if _, err := file.Write(line); err != nil {
+		fmt.Printf("Failed to write to '%s': %v\n", outPath, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business"
+	"github.com/spf13/pflag"
+	"time"
+)
+
+// validityFlags holds the flags that set the validity period of an issued certificate.
+type validityFlags struct {
+	validFor     time.Duration
+	validFrom    string
+	backdate     time.Duration
+	issuerExpiry string
+}
+
+func addValidityFlags(flags *pflag.FlagSet, validForDesc string) *validityFlags {
+	f := &validityFlags{}
+	flags.DurationVar(&f.validFor, "valid-for", 0, "Specifies how long the certificate is valid for from the start time, such as '24h'. "+validForDesc)
+	flags.StringVar(&f.validFrom, "valid-from", "", "Specifies the start of the validity period as an RFC 3339 time, which may be in the future. Default is now")
+	flags.DurationVar(&f.backdate, "backdate", 0, "Specifies how far before the start time the certificate becomes valid, to tolerate clock skew, such as '5m'")
+	flags.StringVar(&f.issuerExpiry, "issuer-expiry", "clamp", "Specifies what to do if the certificate would outlive the CA certificate. 'clamp' shortens the validity period, 'fail' refuses to issue")
+	return f
+}
+
+// validity validates the parsed flags and returns the validity period settings.
+func (f *validityFlags) validity() (business.Validity, error) {
+	var validity business.Validity
+	if f.validFor < 0 || f.backdate < 0 {
+		return validity, errors.New("the 'valid-for' and 'backdate' durations must not be negative")
+	}
+	issuerExpiry, err := business.ParseIssuerExpiry(f.issuerExpiry)
+	if err != nil {
+		return validity, err
+	}
+	validity.Duration = f.validFor
+	validity.Backdate = f.backdate
+	validity.IssuerExpiry = issuerExpiry
+	if f.validFrom != "" {
+		validity.Start, err = time.Parse(time.RFC3339, f.validFrom)
+		if err != nil {
+			return validity, fmt.Errorf("invalid 'valid-from' time: %w", err)
+		}
+	}
+	return validity, nil
+}
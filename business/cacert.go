@@ -1,22 +1,32 @@
 package business
 
 import (
+	"crypto"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"github.com/drognisep/certserver/business/format"
 	"github.com/google/uuid"
 	"math/big"
 	"net"
+	"net/url"
 	"time"
 )
 
 type CaCertOpts struct {
-	Name           pkix.Name
-	ExpirationDate time.Time
-	IpAddresses    []net.IP
-	SANs           []string
-	KeyBits        int
+	Name            pkix.Name
+	ExpirationDate  time.Time
+	IpAddresses     []net.IP
+	SANs            []string
+	URIs            []*url.URL
+	EmailAddresses  []string
+	KeyBits         int
+	KeyType         KeyType
+	KeyPassphrase   format.PassphraseFunc
+	KeyKDF          format.KDF
+	PlaintextKey    bool
+	MaxPathLen      int
+	NameConstraints NameConstraints
 }
 
 type CaCertOpt func(opts *CaCertOpts)
@@ -45,20 +55,87 @@ func CaSubjectAlternativeName(name string) CaCertOpt {
 	}
 }
 
+// CaURI adds a URI SAN, such as a SPIFFE trust domain ID. Use ParseURISAN to parse and validate it.
+func CaURI(uri *url.URL) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.URIs = append(opts.URIs, uri)
+	}
+}
+
+// CaEmailAddress adds an email SAN.
+func CaEmailAddress(email string) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.EmailAddresses = append(opts.EmailAddresses, email)
+	}
+}
+
+func CaKeyBits(bits int) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.KeyBits = bits
+	}
+}
+
+func CaKeyType(keyType KeyType) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.KeyType = keyType
+	}
+}
+
+// CaEncryptKey causes the CA key to be output as an encrypted PKCS#8 key.
+func CaEncryptKey(passphrase format.PassphraseFunc, kdf format.KDF) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.KeyPassphrase = passphrase
+		opts.KeyKDF = kdf
+	}
+}
+
+// CaPlaintextKey causes Rollover to write the new CA key unencrypted, even if the key it replaces was encrypted.
+func CaPlaintextKey() CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.PlaintextKey = true
+	}
+}
+
+// CaMaxPathLen sets the number of CAs allowed below the root, where -1 is unlimited. Default is unlimited.
+func CaMaxPathLen(maxPathLen int) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.MaxPathLen = maxPathLen
+	}
+}
+
+// CaNameConstraints limits the names the root and the CAs below it may issue certificates for.
+func CaNameConstraints(constraints NameConstraints) CaCertOpt {
+	return func(opts *CaCertOpts) {
+		opts.NameConstraints = constraints
+	}
+}
+
 func NewCaCert(commonName string, name pkix.Name, opts ...CaCertOpt) (cert []byte, key []byte, err error) {
 	caOpts := CaCertOpts{
 		Name:           name,
 		ExpirationDate: time.Now().AddDate(0, 3, 0),
 		KeyBits:        4096,
+		KeyType:        KeyTypeRSA,
+		KeyKDF:         format.KDFPBKDF2,
+		MaxPathLen:     -1,
 	}
 	caOpts.Name.CommonName = commonName
 	for _, opt := range opts {
 		opt(&caOpts)
 	}
 
+	cert, _, key, err = newCaCert(caOpts)
+	return cert, key, err
+}
+
+// newCaCert creates a self-signed CA and returns its key as a signer, as well as encoded with the options.
+func newCaCert(caOpts CaCertOpts) (cert []byte, priv crypto.Signer, key []byte, err error) {
+	if err := validateSANs(caOpts.URIs, caOpts.EmailAddresses); err != nil {
+		return nil, nil, nil, err
+	}
 	serial, err := generateSerialNumber()
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	caOpts.Name.SerialNumber = serial.String()
 
@@ -68,14 +145,20 @@ func NewCaCert(commonName string, name pkix.Name, opts ...CaCertOpt) (cert []byt
 		NotBefore:             time.Now(),
 		NotAfter:              caOpts.ExpirationDate,
 		IsCA:                  true,
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 		BasicConstraintsValid: true,
 		DNSNames:              caOpts.SANs,
 		IPAddresses:           caOpts.IpAddresses,
+		URIs:                  caOpts.URIs,
+		EmailAddresses:        caOpts.EmailAddresses,
+	}
+	applyMaxPathLen(&caCert, caOpts.MaxPathLen)
+	if err := caOpts.NameConstraints.apply(&caCert); err != nil {
+		return nil, nil, nil, err
 	}
 
-	return generateCaCertAndKeys(err, caOpts.KeyBits, &caCert)
+	return generateCaCertAndKeys(&caOpts, &caCert)
 }
 
 func generateSerialNumber() (*big.Int, error) {
@@ -93,21 +176,19 @@ func generateSerialNumber() (*big.Int, error) {
 	return &serial, nil
 }
 
-func generateCaCertAndKeys(err error, keyBits int, template *x509.Certificate) ([]byte, []byte, error) {
-	priv, err := generateRsaKeypair(keyBits)
+func generateCaCertAndKeys(caOpts *CaCertOpts, template *x509.Certificate) ([]byte, crypto.Signer, []byte, error) {
+	priv, err := generateKeypair(caOpts.KeyType, caOpts.KeyBits)
 	if err != nil {
-		return nil, nil, err
+		return nil, nil, nil, err
 	}
 	cert, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
-	privDer := x509.MarshalPKCS1PrivateKey(priv)
-
-	return cert, privDer, nil
-}
-
-func generateRsaKeypair(keyBits int) (*rsa.PrivateKey, error) {
-	priv, err := rsa.GenerateKey(rand.Reader, keyBits)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
-	return priv, nil
+	privDer, err := encodePrivateKey(priv, caOpts.KeyPassphrase, caOpts.KeyKDF)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return cert, priv, privDer, nil
 }
@@ -0,0 +1,407 @@
+package business
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	caDirCertFile   = "ca.cer"
+	caDirKeyFile    = "ca.key"
+	caDirConfigFile = "config.json"
+	caDirIndexFile  = "index.json"
+	caDirCertsDir   = "certs"
+	caDirCrlFile    = "ca.crl"
+	caDirDeltaFile  = "delta.crl"
+	caDirProfiles   = "profiles.yaml"
+	caDirLockFile   = ".lock"
+
+	// caDirLockTimeout is how long to wait for another process to release the directory's lock file.
+	caDirLockTimeout = 10 * time.Second
+)
+
+var (
+	ErrCaDirExists    = errors.New("the CA directory already exists and is not empty")
+	ErrNotCaDir       = errors.New("the directory is not a CA directory")
+	ErrSerialNotFound = errors.New("no certificate with that serial number is in the CA index")
+	ErrCaDirLocked    = errors.New("timed out waiting for another process to unlock the CA directory")
+)
+
+type CertStatus string
+
+const (
+	CertStatusValid   CertStatus = "valid"
+	CertStatusRevoked CertStatus = "revoked"
+	CertStatusExpired CertStatus = "expired"
+)
+
+// CaConfig is the configuration stored in a CA directory.
+type CaConfig struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+
+	// CrlNumber is the number of the last CRL issued. Full and delta CRLs share the sequence.
+	CrlNumber int64 `json:"crlNumber"`
+	// BaseCrlNumber and BaseCrlTime identify the last full CRL, which delta CRLs are relative to.
+	BaseCrlNumber int64      `json:"baseCrlNumber,omitempty"`
+	BaseCrlTime   *time.Time `json:"baseCrlTime,omitempty"`
+
+	// Policy is enforced on every CSR signed with the directory.
+	Policy *IssuancePolicy `json:"policy,omitempty"`
+	// URLs are embedded in every certificate signed, renewed, or cross-signed with the directory.
+	URLs *CaURLs `json:"urls,omitempty"`
+
+	// Rollovers lists each time the root was replaced with a successor, oldest first.
+	Rollovers []CaRollover `json:"rollovers,omitempty"`
+}
+
+// IndexEntry is the record of one certificate issued by a CA directory.
+type IndexEntry struct {
+	// Serial is the certificate's serial number in lower case hex.
+	Serial         string    `json:"serial"`
+	Subject        string    `json:"subject"`
+	DNSNames       []string  `json:"dnsNames,omitempty"`
+	IPAddresses    []string  `json:"ipAddresses,omitempty"`
+	URIs           []string  `json:"uris,omitempty"`
+	EmailAddresses []string  `json:"emailAddresses,omitempty"`
+	NotBefore      time.Time `json:"notBefore"`
+	NotAfter       time.Time `json:"notAfter"`
+	Profile        string    `json:"profile"`
+	// Issuer is the serial number of the directory's root that signed the certificate, which may be a root replaced with Rollover.
+	Issuer string     `json:"issuer,omitempty"`
+	Status CertStatus `json:"status"`
+
+	RevokedAt        *time.Time       `json:"revokedAt,omitempty"`
+	RevocationReason RevocationReason `json:"revocationReason,omitempty"`
+	// HoldReleasedAt is set when a certificate on hold is made valid again with removeFromCRL.
+	HoldReleasedAt *time.Time `json:"holdReleasedAt,omitempty"`
+	// StatusChangedAt is the last time the entry was revoked or released, used to select entries for delta CRLs.
+	StatusChangedAt *time.Time `json:"statusChangedAt,omitempty"`
+}
+
+// StatusAt returns the entry's status, reporting valid certificates past their NotAfter as expired.
+func (e *IndexEntry) StatusAt(now time.Time) CertStatus {
+	if e.Status == CertStatusValid && now.After(e.NotAfter) {
+		return CertStatusExpired
+	}
+	return e.Status
+}
+
+// CaDir is a directory holding a CA's certificate, key, configuration, and an index of every certificate it has issued.
+//
+//	ca.cer         The CA certificate, PEM encoded
+//	ca.key         The CA key, PEM encoded and optionally encrypted
+//	config.json    The CaConfig
+//	index.json     The IndexEntry list
+//	certs/         A PEM copy of each issued certificate, named by serial number
+//	ca.crl         The latest full CRL, DER encoded
+//	delta.crl      The latest delta CRL, DER encoded
+//	profiles.yaml  Optional certificate profiles used by 'sign --ca-dir', in addition to the built-in profiles
+//	rollovers/     A directory for each root replaced by 'ca rollover', holding the old root, its key, its CRL, and the link certificates
+//	.lock          Held while the index or config is changed
+type CaDir struct {
+	Path   string
+	Config CaConfig
+
+	// locks counts the nested calls holding the lock file.
+	locks int
+}
+
+// InitCaDir creates a CA directory from a DER CA certificate and key. The directory must not exist, or must be empty.
+func InitCaDir(path string, cert, key []byte) (*CaDir, error) {
+	caCert, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	if entries, err := ioutil.ReadDir(path); err == nil && len(entries) > 0 {
+		return nil, ErrCaDirExists
+	}
+	if err := os.MkdirAll(filepath.Join(path, caDirCertsDir), 0700); err != nil {
+		return nil, err
+	}
+
+	dir := &CaDir{
+		Path: path,
+		Config: CaConfig{
+			Name:    caCert.Subject.CommonName,
+			Created: time.Now().UTC(),
+		},
+	}
+	if err := ioutil.WriteFile(dir.CertFile(), format.EncodeCerts(format.EncodingPem, cert), 0600); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(dir.KeyFile(), format.EncodePrivateKey(format.EncodingPem, key), 0600); err != nil {
+		return nil, err
+	}
+	if err := dir.SaveConfig(); err != nil {
+		return nil, err
+	}
+	if err := dir.saveIndex([]*IndexEntry{}); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// OpenCaDir loads the configuration of an existing CA directory.
+func OpenCaDir(path string) (*CaDir, error) {
+	data, err := ioutil.ReadFile(filepath.Join(path, caDirConfigFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w: '%s'", ErrNotCaDir, path)
+		}
+		return nil, err
+	}
+	dir := &CaDir{Path: path}
+	if err := dir.parseConfig(data); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+func (d *CaDir) parseConfig(data []byte) error {
+	var config CaConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse CA config: %w", err)
+	}
+	for i := range config.Rollovers {
+		config.Rollovers[i].Path = filepath.Join(d.Path, caDirRolloversDir, config.Rollovers[i].NewSerial)
+	}
+	d.Config = config
+	return nil
+}
+
+// lock takes the directory's lock file, waiting for another process to release it, and reloads the config in case
+// it was changed since the directory was opened. Every change to the index or config is made with the lock held, so
+// concurrent commands can't lose each other's changes. Reading doesn't need the lock, since both files are replaced
+// atomically. Calls may be nested, and the returned function releases the lock.
+func (d *CaDir) lock() (func(), error) {
+	unlock := func() {
+		d.locks--
+	}
+	if d.locks > 0 {
+		d.locks++
+		return unlock, nil
+	}
+
+	path := filepath.Join(d.Path, caDirLockFile)
+	deadline := time.Now().Add(caDirLockTimeout)
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n", os.Getpid())
+			file.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w, remove '%s' if no other process is using it", ErrCaDirLocked, path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	d.locks++
+	unlockFile := func() {
+		unlock()
+		os.Remove(path)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(d.Path, caDirConfigFile))
+	if err == nil {
+		err = d.parseConfig(data)
+	}
+	if err != nil {
+		unlockFile()
+		return nil, err
+	}
+	return unlockFile, nil
+}
+
+// CertFile returns the path of the CA certificate.
+func (d *CaDir) CertFile() string {
+	return filepath.Join(d.Path, caDirCertFile)
+}
+
+// KeyFile returns the path of the CA key.
+func (d *CaDir) KeyFile() string {
+	return filepath.Join(d.Path, caDirKeyFile)
+}
+
+// IssuedCertFile returns the path of the stored copy of an issued certificate.
+func (d *CaDir) IssuedCertFile(serial string) string {
+	return filepath.Join(d.Path, caDirCertsDir, strings.ToLower(serial)+".cer")
+}
+
+// CrlFile returns the path of the latest full CRL.
+func (d *CaDir) CrlFile() string {
+	return filepath.Join(d.Path, caDirCrlFile)
+}
+
+// DeltaCrlFile returns the path of the latest delta CRL.
+func (d *CaDir) DeltaCrlFile() string {
+	return filepath.Join(d.Path, caDirDeltaFile)
+}
+
+// Profiles returns the built-in profiles, along with the profiles in the directory's profiles.yaml if it exists.
+func (d *CaDir) Profiles() (Profiles, error) {
+	path := filepath.Join(d.Path, caDirProfiles)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return BuiltinProfiles(), nil
+	}
+	return LoadProfiles(path)
+}
+
+// SaveConfig writes the CA configuration. Use UpdateConfig to change an opened directory's configuration, so changes
+// made by other processes since it was opened aren't lost.
+func (d *CaDir) SaveConfig() error {
+	data, err := json.MarshalIndent(d.Config, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(d.Path, caDirConfigFile), append(data, '\n'))
+}
+
+// UpdateConfig reloads the CA configuration with the directory locked, and saves it after the update changes it.
+func (d *CaDir) UpdateConfig(update func(config *CaConfig) error) error {
+	unlock, err := d.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := update(&d.Config); err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+// Index returns every entry in the CA index, in the order the certificates were issued.
+func (d *CaDir) Index() ([]*IndexEntry, error) {
+	data, err := ioutil.ReadFile(filepath.Join(d.Path, caDirIndexFile))
+	if err != nil {
+		return nil, err
+	}
+	var entries []*IndexEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse CA index: %w", err)
+	}
+	return entries, nil
+}
+
+// Lookup returns the index entry for a serial number given in hex.
+func (d *CaDir) Lookup(serial string) (*IndexEntry, error) {
+	entries, err := d.Index()
+	if err != nil {
+		return nil, err
+	}
+	serial = normalizeSerial(serial)
+	for _, entry := range entries {
+		if entry.Serial == serial {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSerialNotFound, serial)
+}
+
+// Record adds a newly issued DER certificate to the index and stores a copy of it.
+func (d *CaDir) Record(cert []byte, profile string) (*IndexEntry, error) {
+	parsed, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	entry := &IndexEntry{
+		Serial:         parsed.SerialNumber.Text(16),
+		Subject:        parsed.Subject.String(),
+		DNSNames:       parsed.DNSNames,
+		EmailAddresses: parsed.EmailAddresses,
+		NotBefore:      parsed.NotBefore.UTC(),
+		NotAfter:       parsed.NotAfter.UTC(),
+		Profile:        profile,
+		Status:         CertStatusValid,
+	}
+	for _, ip := range parsed.IPAddresses {
+		entry.IPAddresses = append(entry.IPAddresses, ip.String())
+	}
+	for _, uri := range parsed.URIs {
+		entry.URIs = append(entry.URIs, uri.String())
+	}
+
+	unlock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	roots, err := d.roots()
+	if err != nil {
+		return nil, err
+	}
+	if root := issuerOf(parsed, roots); root != nil {
+		entry.Issuer = root.serial
+	}
+
+	entries, err := d.Index()
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(d.IssuedCertFile(entry.Serial), format.EncodeCerts(format.EncodingPem, cert), 0600); err != nil {
+		return nil, err
+	}
+	if err := d.saveIndex(append(entries, entry)); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// loadCa reads the CA certificate and key from the directory.
+func (d *CaDir) loadCa(passphrase format.PassphraseFunc) (*x509.Certificate, crypto.Signer, error) {
+	caCert, err := LoadCertFromFile(d.CertFile())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	caKey, err := LoadPrivateKeyFromFile(d.KeyFile(), passphrase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA key: %w", err)
+	}
+	return caCert, caKey, nil
+}
+
+func (d *CaDir) saveIndex(entries []*IndexEntry) error {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(d.Path, caDirIndexFile), append(data, '\n'))
+}
+
+// normalizeSerial accepts a hex serial number with optional colons or a 0x prefix, as printed by various tools.
+func normalizeSerial(serial string) string {
+	serial = strings.ToLower(strings.TrimSpace(serial))
+	serial = strings.TrimPrefix(serial, "0x")
+	serial = strings.ReplaceAll(serial, ":", "")
+	return strings.TrimLeft(serial, "0")
+}
+
+// writeFileAtomic replaces the file by renaming a temporary file over it, so readers never see a partial write.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
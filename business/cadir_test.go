@@ -0,0 +1,111 @@
+package business
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCaDir creates a CA directory with an ECDSA root, which is much faster to generate than the default RSA key.
+func newTestCaDir(t *testing.T, opts ...CaCertOpt) *CaDir {
+	t.Helper()
+	opts = append([]CaCertOpt{CaKeyType(KeyTypeECDSAP256), CaExpirationDays(365)}, opts...)
+	cert, key, err := NewCaCert("Test Root", pkix.Name{Organization: []string{"Test"}}, opts...)
+	if err != nil {
+		t.Fatalf("NewCaCert: %v", err)
+	}
+	dir, err := InitCaDir(filepath.Join(t.TempDir(), "ca"), cert, key)
+	if err != nil {
+		t.Fatalf("InitCaDir: %v", err)
+	}
+	return dir
+}
+
+// issueTestCert signs a server certificate for the DNS name with the directory's current root, and records it.
+func issueTestCert(t *testing.T, dir *CaDir, dnsName string, opts ...SignOpt) *x509.Certificate {
+	t.Helper()
+	csr, _, err := NewGeneratedCsr(dnsName, pkix.Name{}, CsrKeyType(KeyTypeECDSAP256), CsrAddSan(dnsName))
+	if err != nil {
+		t.Fatalf("NewGeneratedCsr: %v", err)
+	}
+	csrFile := filepath.Join(t.TempDir(), dnsName+".csr")
+	if err := ioutil.WriteFile(csrFile, csr, 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]SignOpt{SignRecordIn(dir), SignCaKeyPassphrase(testPassphrase)}, opts...)
+	der, _, err := SignCsr(csrFile, dir.CertFile(), dir.KeyFile(), nil, opts...)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestRecordIssuer(t *testing.T) {
+	dir := newTestCaDir(t)
+	caCert, err := LoadCertFromFile(dir.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := issueTestCert(t, dir, "www.example.com")
+
+	entry, err := dir.Lookup(cert.SerialNumber.Text(16))
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if entry.Issuer != caCert.SerialNumber.Text(16) {
+		t.Errorf("expected issuer %s, got %s", caCert.SerialNumber.Text(16), entry.Issuer)
+	}
+	if entry.Profile != ProfileServer {
+		t.Errorf("expected a nil profile to default to '%s', got '%s'", ProfileServer, entry.Profile)
+	}
+	if len(entry.DNSNames) != 1 || entry.DNSNames[0] != "www.example.com" {
+		t.Errorf("unexpected DNS names %v", entry.DNSNames)
+	}
+}
+
+func TestConcurrentIndexUpdates(t *testing.T) {
+	dir := newTestCaDir(t)
+	var certs []*x509.Certificate
+	for i := 0; i < 8; i++ {
+		certs = append(certs, issueTestCert(t, dir, fmt.Sprintf("host%d.example.com", i)))
+	}
+
+	// Each revocation opens the directory separately, as concurrent commands would.
+	errs := make(chan error, len(certs))
+	for _, cert := range certs {
+		go func(serial string) {
+			opened, err := OpenCaDir(dir.Path)
+			if err == nil {
+				_, err = opened.Revoke(serial, ReasonKeyCompromise, time.Now())
+			}
+			errs <- err
+		}(cert.SerialNumber.Text(16))
+	}
+	for range certs {
+		if err := <-errs; err != nil {
+			t.Fatalf("Revoke: %v", err)
+		}
+	}
+
+	entries, err := dir.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Status != CertStatusRevoked {
+			t.Errorf("expected %s to be revoked", entry.Serial)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir.Path, caDirLockFile)); !os.IsNotExist(err) {
+		t.Errorf("expected the lock file to be removed, got %v", err)
+	}
+}
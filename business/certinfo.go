@@ -7,7 +7,11 @@ import (
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"github.com/drognisep/certserver/business/format"
 	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
 	"text/template"
 )
 
@@ -15,13 +19,16 @@ var (
 	ErrNotACertificate = errors.New("the file is not in a known format or does not contain a certificate")
 )
 
+// ShowCertDetails prints every certificate in the file, including each certificate in a PKCS#7 bundle.
 func ShowCertDetails(file string) error {
-	cert, err := LoadCertFromFile(file)
+	certs, err := LoadCertsFromFile(file)
 	if err != nil {
 		return err
 	}
-	if err := printCert(cert); err != nil {
-		return err
+	for _, cert := range certs {
+		if err := printCert(cert); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -39,6 +46,10 @@ func LoadCertFromFile(filepath string) (*x509.Certificate, error) {
 		var err error
 		cert, err = decodeCert(block)
 		if cert == nil || err != nil {
+			if pkcs7Certs, err := decodePKCS7Certs(block); err == nil {
+				cert = pkcs7Certs[0]
+				break
+			}
 			continue
 		}
 		break
@@ -49,6 +60,50 @@ func LoadCertFromFile(filepath string) (*x509.Certificate, error) {
 	return cert, nil
 }
 
+// LoadCertsFromFile returns every certificate found in the file, in the order they appear.
+func LoadCertsFromFile(filepath string) ([]*x509.Certificate, error) {
+	fileBytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	// DER certificates may be concatenated, so each block is parsed as a list.
+	var certs []*x509.Certificate
+	for _, block := range decodePem(fileBytes) {
+		blockCerts, err := x509.ParseCertificates(block)
+		if err != nil {
+			if blockCerts, err = decodePKCS7Certs(block); err != nil {
+				continue
+			}
+		}
+		certs = append(certs, blockCerts...)
+	}
+	if len(certs) == 0 {
+		return nil, ErrNotACertificate
+	}
+	return certs, nil
+}
+
+func decodePKCS7Certs(der []byte) ([]*x509.Certificate, error) {
+	certDers, _, err := format.DecodePKCS7(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(certDers) == 0 {
+		return nil, ErrNotACertificate
+	}
+	return x509.ParseCertificates(bytes.Join(certDers, nil))
+}
+
+// CertCommonName returns the subject common name of a DER encoded certificate, which is used to name output files.
+func CertCommonName(der []byte) (string, error) {
+	cert, err := decodeCert(der)
+	if err != nil {
+		return "", err
+	}
+	return cert.Subject.CommonName, nil
+}
+
 // This will effectively be a no-op if the input bytes are not PEM encoded.
 func decodePem(fileBytes []byte) [][]byte {
 	var blockBytes [][]byte
@@ -82,9 +137,19 @@ var (
 	certTemplateText = `
 Discovered {{if .IsCA }}CA{{else}}Server{{end}} cert
 Common Name:     {{ .Subject.CommonName }}
-S/N:             {{ .SerialNumber }}
+S/N:             {{ .SerialString }}
 SANs:            {{ .DNSNames }}
 IPs:             {{ .IPAddresses }}
+URIs:            {{ .URIs }}
+Emails:          {{ .EmailAddresses }}{{ if .IsCA }}
+Max Path Length: {{ .PathLenString }}{{ range .NameConstraintLines }}
+{{ . }}{{ end }}{{ end }}{{ with .SubjectKeyId }}
+Subject Key ID:  {{ keyId . }}{{ end }}{{ with .AuthorityKeyId }}
+Authority Key ID: {{ keyId . }}{{ end }}{{ with .OCSPServer }}
+OCSP Servers:    {{ . }}{{ end }}{{ with .IssuingCertificateURL }}
+CA Issuers:      {{ . }}{{ end }}{{ with .CRLDistributionPoints }}
+CRL Dist Points: {{ . }}{{ end }}{{ with .PolicyIdentifiers }}
+Policies:        {{ . }}{{ end }}
 
 Effective:       {{ .NotBefore.String }}
 Expiration:      {{ .NotAfter.String }}
@@ -119,7 +184,7 @@ Locality:            {{ .Issuer.Locality }}
 Province:            {{ .Issuer.Province }}
 Postal Code:         {{ .Issuer.PostalCode }}{{end}}
 `
-	certTemplate = template.Must(template.New("cert-template").Parse(certTemplateText))
+	certTemplate = template.Must(template.New("cert-template").Funcs(template.FuncMap{"keyId": keyIdString}).Parse(certTemplateText))
 )
 
 type certTemplateParams struct {
@@ -147,6 +212,66 @@ func printCert(cert *x509.Certificate) error {
 	return nil
 }
 
+// keyIdString formats a key ID as colon separated hex, as openssl does.
+func keyIdString(keyId []byte) string {
+	parts := make([]string, len(keyId))
+	for i, b := range keyId {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// SerialString formats the serial number as colon separated lower case hex, which matches the CA index once the
+// colons are removed.
+func (p *certTemplateParams) SerialString() string {
+	serial := p.SerialNumber.Text(16)
+	if len(serial)%2 == 1 {
+		serial = "0" + serial
+	}
+	parts := make([]string, 0, len(serial)/2)
+	for i := 0; i < len(serial); i += 2 {
+		parts = append(parts, serial[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+func (p *certTemplateParams) PathLenString() string {
+	if !p.BasicConstraintsValid || p.MaxPathLen < 0 {
+		return "Unlimited"
+	}
+	return strconv.Itoa(p.MaxPathLen)
+}
+
+// NameConstraintLines lists the CA's name constraints, one kind per line.
+func (p *certTemplateParams) NameConstraintLines() []string {
+	ipRanges := func(ranges []*net.IPNet) []string {
+		var cidrs []string
+		for _, ipRange := range ranges {
+			cidrs = append(cidrs, ipRange.String())
+		}
+		return cidrs
+	}
+	var lines []string
+	for _, constraint := range []struct {
+		label string
+		names []string
+	}{
+		{"Permitted DNS:", p.PermittedDNSDomains},
+		{"Excluded DNS:", p.ExcludedDNSDomains},
+		{"Permitted IPs:", ipRanges(p.PermittedIPRanges)},
+		{"Excluded IPs:", ipRanges(p.ExcludedIPRanges)},
+		{"Permitted Email:", p.PermittedEmailAddresses},
+		{"Excluded Email:", p.ExcludedEmailAddresses},
+		{"Permitted URIs:", p.PermittedURIDomains},
+		{"Excluded URIs:", p.ExcludedURIDomains},
+	} {
+		if len(constraint.names) > 0 {
+			lines = append(lines, fmt.Sprintf("%-17s%v", constraint.label, constraint.names))
+		}
+	}
+	return lines
+}
+
 func (p *certTemplateParams) SelfSigned() bool {
 	subject := p.Subject
 	issuer := p.Issuer
@@ -0,0 +1,31 @@
+package business
+
+import (
+	"crypto/x509"
+	"math/big"
+	"testing"
+)
+
+func TestSerialString(t *testing.T) {
+	tests := map[string]struct {
+		serial int64
+		want   string
+	}{
+		"single byte":       {serial: 0x7f, want: "7f"},
+		"odd digit count":   {serial: 0xabc, want: "0a:bc"},
+		"multiple bytes":    {serial: 0x1234abcd, want: "12:34:ab:cd"},
+		"leading zero byte": {serial: 0x0102, want: "01:02"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			params := &certTemplateParams{Certificate: &x509.Certificate{SerialNumber: big.NewInt(tc.serial)}}
+			got := params.SerialString()
+			if got != tc.want {
+				t.Fatalf("expected %s, got %s", tc.want, got)
+			}
+			if normalizeSerial(got) != params.SerialNumber.Text(16) {
+				t.Fatalf("expected %s to match the index serial %s", got, params.SerialNumber.Text(16))
+			}
+		})
+	}
+}
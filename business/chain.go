@@ -0,0 +1,58 @@
+package business
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+)
+
+// BuildCaChain orders the issuing CA certificate and any intermediates from the chain files into a path towards the root.
+// The first certificate is always the issuing CA. Certificates in the chain files that aren't part of the path are ignored,
+// and the path ends early if the root isn't provided.
+func BuildCaChain(caCertFile string, chainFiles ...string) ([]*x509.Certificate, error) {
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate '%s': %w", caCertFile, err)
+	}
+	var pool []*x509.Certificate
+	for _, chainFile := range chainFiles {
+		certs, err := LoadCertsFromFile(chainFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA chain '%s': %w", chainFile, err)
+		}
+		pool = append(pool, certs...)
+	}
+
+	chain := []*x509.Certificate{caCert}
+	current := caCert
+	for !isSelfSigned(current) {
+		issuer := findIssuer(current, pool)
+		if issuer == nil {
+			break
+		}
+		for _, cert := range chain {
+			if cert.Equal(issuer) {
+				return nil, fmt.Errorf("the CA chain contains a loop at '%s'", issuer.Subject.CommonName)
+			}
+		}
+		chain = append(chain, issuer)
+		current = issuer
+	}
+	return chain, nil
+}
+
+func findIssuer(cert *x509.Certificate, candidates []*x509.Certificate) *x509.Certificate {
+	for _, candidate := range candidates {
+		if !bytes.Equal(cert.RawIssuer, candidate.RawSubject) {
+			continue
+		}
+		if err := cert.CheckSignatureFrom(candidate); err == nil {
+			return candidate
+		}
+	}
+	return nil
+}
+
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
@@ -0,0 +1,63 @@
+package business
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+// newTestSubCa signs a sub-CA certificate and writes its DER certificate and key to files.
+func newTestSubCa(t *testing.T, name, caCertFile, caKeyFile string, opts ...SignOpt) (certFile, keyFile string) {
+	t.Helper()
+	csrFile, keyFile := newTestCsr(t, name)
+	der, _, err := SignCsr(csrFile, caCertFile, caKeyFile, BuiltinProfiles()[ProfileCA], opts...)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	return writeTestFile(t, name+".cer", der), keyFile
+}
+
+func TestBuildCaChain(t *testing.T) {
+	rootFile, rootKeyFile := newTestCa(t)
+	issuingFile, issuingKeyFile := newTestSubCa(t, "issuing.example.com", rootFile, rootKeyFile)
+	subFile, _ := newTestSubCa(t, "sub.example.com", issuingFile, issuingKeyFile)
+	otherFile, _ := newTestCa(t)
+
+	certs := func(files ...string) []*x509.Certificate {
+		var certs []*x509.Certificate
+		for _, file := range files {
+			cert, err := LoadCertFromFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certs = append(certs, cert)
+		}
+		return certs
+	}
+	tests := map[string]struct {
+		caCertFile string
+		chainFiles []string
+		want       []*x509.Certificate
+	}{
+		"self-signed CA":          {caCertFile: rootFile, want: certs(rootFile)},
+		"path to the root":        {caCertFile: subFile, chainFiles: []string{rootFile, issuingFile}, want: certs(subFile, issuingFile, rootFile)},
+		"unrelated certs ignored": {caCertFile: issuingFile, chainFiles: []string{otherFile, rootFile}, want: certs(issuingFile, rootFile)},
+		"missing root":            {caCertFile: subFile, chainFiles: []string{issuingFile}, want: certs(subFile, issuingFile)},
+		"no chain files":          {caCertFile: subFile, want: certs(subFile)},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			chain, err := BuildCaChain(tc.caCertFile, tc.chainFiles...)
+			if err != nil {
+				t.Fatalf("BuildCaChain: %v", err)
+			}
+			if len(chain) != len(tc.want) {
+				t.Fatalf("expected a chain of %d certificates, got %d", len(tc.want), len(chain))
+			}
+			for i, cert := range chain {
+				if !cert.Equal(tc.want[i]) {
+					t.Fatalf("unexpected certificate %d in the chain: %s", i, cert.Subject.CommonName)
+				}
+			}
+		})
+	}
+}
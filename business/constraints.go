@@ -0,0 +1,162 @@
+package business
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	ErrPathLenExceeded       = errors.New("the CA's path length constraint doesn't allow issuing another CA")
+	ErrNameNotPermitted      = errors.New("the CA's name constraints don't permit a name in the certificate")
+	ErrCaOnlyConstraint      = errors.New("path length and name constraints may only be set on CA certificates")
+	ErrInvalidNameConstraint = errors.New("invalid name constraint")
+)
+
+// NameConstraints lists the names that a CA and the CAs below it may issue certificates for.
+// DNS and URI domains match the domain and its subdomains, or only subdomains with a leading '.' or '*.'.
+// Email constraints may be a mailbox, a host, or a domain with a leading '.'.
+type NameConstraints struct {
+	PermittedDNSDomains     []string
+	ExcludedDNSDomains      []string
+	PermittedIPRanges       []*net.IPNet
+	ExcludedIPRanges        []*net.IPNet
+	PermittedEmailAddresses []string
+	ExcludedEmailAddresses  []string
+	PermittedURIDomains     []string
+	ExcludedURIDomains      []string
+}
+
+// IsEmpty reports whether there are no constraints.
+func (n NameConstraints) IsEmpty() bool {
+	return len(n.PermittedDNSDomains) == 0 && len(n.ExcludedDNSDomains) == 0 &&
+		len(n.PermittedIPRanges) == 0 && len(n.ExcludedIPRanges) == 0 &&
+		len(n.PermittedEmailAddresses) == 0 && len(n.ExcludedEmailAddresses) == 0 &&
+		len(n.PermittedURIDomains) == 0 && len(n.ExcludedURIDomains) == 0
+}
+
+// apply validates the constraints and sets them on the template as a critical extension, as RFC 5280 requires.
+func (n NameConstraints) apply(template *x509.Certificate) error {
+	if n.IsEmpty() {
+		return nil
+	}
+	var err error
+	if template.PermittedDNSDomains, err = normalizeDomainConstraints("DNS", n.PermittedDNSDomains); err != nil {
+		return err
+	}
+	if template.ExcludedDNSDomains, err = normalizeDomainConstraints("DNS", n.ExcludedDNSDomains); err != nil {
+		return err
+	}
+	if template.PermittedURIDomains, err = normalizeDomainConstraints("URI", n.PermittedURIDomains); err != nil {
+		return err
+	}
+	if template.ExcludedURIDomains, err = normalizeDomainConstraints("URI", n.ExcludedURIDomains); err != nil {
+		return err
+	}
+	if template.PermittedEmailAddresses, err = normalizeEmailConstraints(n.PermittedEmailAddresses); err != nil {
+		return err
+	}
+	if template.ExcludedEmailAddresses, err = normalizeEmailConstraints(n.ExcludedEmailAddresses); err != nil {
+		return err
+	}
+	template.PermittedIPRanges = n.PermittedIPRanges
+	template.ExcludedIPRanges = n.ExcludedIPRanges
+	template.PermittedDNSDomainsCritical = true
+	return nil
+}
+
+func normalizeDomainConstraints(kind string, domains []string) ([]string, error) {
+	var normalized []string
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if strings.HasPrefix(domain, "*.") {
+			domain = domain[1:]
+		}
+		if strings.Trim(domain, ".") == "" || strings.ContainsAny(domain, "*@/: ") || strings.Contains(domain, "..") {
+			return nil, fmt.Errorf("%w: %s domain '%s'", ErrInvalidNameConstraint, kind, domain)
+		}
+		normalized = append(normalized, domain)
+	}
+	return normalized, nil
+}
+
+func normalizeEmailConstraints(emails []string) ([]string, error) {
+	var normalized []string
+	for _, email := range emails {
+		email = strings.TrimSpace(email)
+		domain := email
+		if at := strings.LastIndex(email, "@"); at >= 0 {
+			if at == 0 {
+				return nil, fmt.Errorf("%w: email '%s'", ErrInvalidNameConstraint, email)
+			}
+			domain = email[at+1:]
+		}
+		if _, err := normalizeDomainConstraints("email", []string{domain}); err != nil || strings.HasPrefix(domain, "*") {
+			return nil, fmt.Errorf("%w: email '%s'", ErrInvalidNameConstraint, email)
+		}
+		normalized = append(normalized, email)
+	}
+	return normalized, nil
+}
+
+// applyMaxPathLen sets the number of CAs allowed below the CA, where -1 is unlimited.
+func applyMaxPathLen(template *x509.Certificate, maxPathLen int) {
+	template.BasicConstraintsValid = true
+	template.IsCA = true
+	if maxPathLen < 0 {
+		template.MaxPathLen = -1
+		return
+	}
+	template.MaxPathLen = maxPathLen
+	template.MaxPathLenZero = maxPathLen == 0
+}
+
+// subCaPathLen returns the path length for a CA issued by the issuer, defaulting to one less than the issuer's.
+func subCaPathLen(issuer *x509.Certificate, requested int, requestedSet bool) (int, error) {
+	if !issuer.BasicConstraintsValid || issuer.MaxPathLen < 0 {
+		if !requestedSet {
+			return -1, nil
+		}
+		return requested, nil
+	}
+	if issuer.MaxPathLen == 0 {
+		return 0, ErrPathLenExceeded
+	}
+	if !requestedSet {
+		return issuer.MaxPathLen - 1, nil
+	}
+	if requested < 0 || requested >= issuer.MaxPathLen {
+		return 0, fmt.Errorf("%w: the issuer allows a path length of at most %d below it", ErrPathLenExceeded, issuer.MaxPathLen-1)
+	}
+	return requested, nil
+}
+
+func hasNameConstraints(cert *x509.Certificate) bool {
+	return len(cert.PermittedDNSDomains) > 0 || len(cert.ExcludedDNSDomains) > 0 ||
+		len(cert.PermittedIPRanges) > 0 || len(cert.ExcludedIPRanges) > 0 ||
+		len(cert.PermittedEmailAddresses) > 0 || len(cert.ExcludedEmailAddresses) > 0 ||
+		len(cert.PermittedURIDomains) > 0 || len(cert.ExcludedURIDomains) > 0
+}
+
+// checkIssuerNameConstraints checks the names in a certificate against the issuer's name constraints, so a
+// constrained CA doesn't issue certificates that clients would reject.
+// Only name constraint failures are reported, since the signature has already been checked.
+func checkIssuerNameConstraints(cert, issuer *x509.Certificate) error {
+	if !hasNameConstraints(issuer) {
+		return nil
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(issuer)
+	_, err := cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: cert.NotBefore,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) && invalidErr.Reason == x509.CANotAuthorizedForThisName {
+		return fmt.Errorf("%w: %s", ErrNameNotPermitted, invalidErr.Detail)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package business
+
+import (
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSubCaPathLen(t *testing.T) {
+	tests := map[string]struct {
+		rootPathLen int
+		opts        []SignOpt
+		// wantPathLen is the sub-CA's path length, where -1 is unlimited.
+		wantPathLen int
+		// wantErr is checked with errors.Is, and nil expects the signing to succeed.
+		wantErr error
+	}{
+		"unlimited":       {rootPathLen: -1, wantPathLen: -1},
+		"unlimited set":   {rootPathLen: -1, opts: []SignOpt{SignMaxPathLen(2)}, wantPathLen: 2},
+		"one less":        {rootPathLen: 2, wantPathLen: 1},
+		"zero":            {rootPathLen: 1, wantPathLen: 0},
+		"lower":           {rootPathLen: 2, opts: []SignOpt{SignMaxPathLen(0)}, wantPathLen: 0},
+		"too long":        {rootPathLen: 2, opts: []SignOpt{SignMaxPathLen(2)}, wantErr: ErrPathLenExceeded},
+		"unlimited below": {rootPathLen: 2, opts: []SignOpt{SignMaxPathLen(-1)}, wantErr: ErrPathLenExceeded},
+		"no CAs allowed":  {rootPathLen: 0, wantErr: ErrPathLenExceeded},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			caCertFile, caKeyFile := newTestCa(t, CaMaxPathLen(tc.rootPathLen))
+			csrFile, _ := newTestCsr(t, "sub.example.com")
+			der, _, err := SignCsr(csrFile, caCertFile, caKeyFile, BuiltinProfiles()[ProfileCA], tc.opts...)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignCsr: %v", err)
+			}
+			cert, err := LoadCertFromFile(writeTestFile(t, "sub.cer", der))
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotPathLen := cert.MaxPathLen
+			if gotPathLen == 0 && !cert.MaxPathLenZero {
+				gotPathLen = -1
+			}
+			if !cert.IsCA || gotPathLen != tc.wantPathLen {
+				t.Fatalf("expected a CA with path length %d, got %d", tc.wantPathLen, gotPathLen)
+			}
+		})
+	}
+
+	caCertFile, caKeyFile := newTestCa(t)
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	if _, err := signTestCsr(t, csrFile, caCertFile, caKeyFile, SignMaxPathLen(0)); !errors.Is(err, ErrCaOnlyConstraint) {
+		t.Fatalf("expected ErrCaOnlyConstraint for a server certificate, got %v", err)
+	}
+}
+
+func TestNameConstraints(t *testing.T) {
+	_, permitted, err := net.ParseCIDR("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCertFile, caKeyFile := newTestCa(t, CaNameConstraints(NameConstraints{
+		PermittedDNSDomains: []string{"*.Example.com"},
+		ExcludedDNSDomains:  []string{"internal.example.com"},
+		PermittedIPRanges:   []*net.IPNet{permitted},
+	}))
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !caCert.PermittedDNSDomainsCritical || len(caCert.PermittedDNSDomains) != 1 || caCert.PermittedDNSDomains[0] != ".example.com" {
+		t.Fatalf("expected a critical '.example.com' DNS constraint, got %v", caCert.PermittedDNSDomains)
+	}
+
+	tests := map[string]struct {
+		dnsName string
+		opts    []CsrOpt
+		wantErr error
+	}{
+		"permitted":    {dnsName: "www.example.com"},
+		"permitted IP": {dnsName: "www.example.com", opts: []CsrOpt{CsrAddIP(net.ParseIP("10.1.2.3"))}},
+		"excluded":     {dnsName: "db.internal.example.com", wantErr: ErrNameNotPermitted},
+		"other domain": {dnsName: "www.example.org", wantErr: ErrNameNotPermitted},
+		"other IP":     {dnsName: "www.example.com", opts: []CsrOpt{CsrAddIP(net.ParseIP("192.168.1.1"))}, wantErr: ErrNameNotPermitted},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			csrFile, _ := newTestCsr(t, tc.dnsName, tc.opts...)
+			_, err := signTestCsr(t, csrFile, caCertFile, caKeyFile)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignCsr: %v", err)
+			}
+		})
+	}
+
+	for _, constraints := range []NameConstraints{
+		{PermittedDNSDomains: []string{"*"}},
+		{ExcludedURIDomains: []string{"https://example.com"}},
+		{PermittedEmailAddresses: []string{"@example.com"}},
+	} {
+		_, _, err := NewCaCert("Test CA", pkix.Name{}, CaKeyType(KeyTypeECDSAP256), CaNameConstraints(constraints))
+		if !errors.Is(err, ErrInvalidNameConstraint) {
+			t.Errorf("expected ErrInvalidNameConstraint for %+v, got %v", constraints, err)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package business
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrNoBaseCrl = errors.New("a full CRL must be generated before a delta CRL")
+)
+
+// RFC 5280 section 5.2.4
+var oidDeltaCrlIndicator = asn1.ObjectIdentifier{2, 5, 29, 27}
+
+type crlOpts struct {
+	nextUpdate              time.Duration
+	delta                   bool
+	caKeyPassphrase         format.PassphraseFunc
+	previousCaKeyPassphrase format.PassphraseFunc
+}
+
+type CrlOpt func(opts *crlOpts)
+
+// CrlNextUpdate sets how long until the next CRL is due. Default is 7 days.
+func CrlNextUpdate(nextUpdate time.Duration) CrlOpt {
+	return func(opts *crlOpts) {
+		opts.nextUpdate = nextUpdate
+	}
+}
+
+// CrlDelta creates a delta CRL with only the changes since the last full CRL.
+func CrlDelta() CrlOpt {
+	return func(opts *crlOpts) {
+		opts.delta = true
+	}
+}
+
+// CrlCaKeyPassphrase sets the source of the passphrase used if the CA key is encrypted.
+func CrlCaKeyPassphrase(passphrase format.PassphraseFunc) CrlOpt {
+	return func(opts *crlOpts) {
+		opts.caKeyPassphrase = passphrase
+	}
+}
+
+// CrlPreviousCaKeyPassphrase sets the source of the passphrase used if the keys of roots replaced with Rollover are encrypted.
+// By default, the CA key's passphrase is used.
+func CrlPreviousCaKeyPassphrase(passphrase format.PassphraseFunc) CrlOpt {
+	return func(opts *crlOpts) {
+		opts.previousCaKeyPassphrase = passphrase
+	}
+}
+
+// NewCrl signs a CRL of the revoked certificates in the CA index issued by the current root, and stores it in the CA directory.
+// Expired certificates are left out. A delta CRL lists revocations and releases from hold since the last full CRL.
+// A full CRL also updates the CRL of each root replaced with Rollover that hasn't expired, signed with its archived key
+// and stored in its rollover directory. Only the current root's CRL is returned.
+func (d *CaDir) NewCrl(opts ...CrlOpt) ([]byte, error) {
+	_crlOpts := &crlOpts{
+		nextUpdate:      7 * 24 * time.Hour,
+		caKeyPassphrase: format.PassphrasePrompt("CA key passphrase", false),
+	}
+	for _, opt := range opts {
+		opt(_crlOpts)
+	}
+	unlock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	if _crlOpts.delta && d.Config.BaseCrlTime == nil {
+		return nil, ErrNoBaseCrl
+	}
+
+	caPassphrase := cachedPassphrase(_crlOpts.caKeyPassphrase)
+	previousPassphrase := caPassphrase
+	if _crlOpts.previousCaKeyPassphrase != nil {
+		previousPassphrase = _crlOpts.previousCaKeyPassphrase
+	}
+	caCert, caKey, err := d.loadCa(caPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	roots, err := d.roots()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := d.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	template := &x509.RevocationList{
+		Number:                    big.NewInt(d.Config.CrlNumber + 1),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(_crlOpts.nextUpdate),
+		RevokedCertificateEntries: d.crlEntries(entries, roots, roots[0].serial, _crlOpts.delta, now),
+	}
+	if _crlOpts.delta {
+		baseNumber, err := asn1.Marshal(big.NewInt(d.Config.BaseCrlNumber))
+		if err != nil {
+			return nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:       oidDeltaCrlIndicator,
+			Critical: true,
+			Value:    baseNumber,
+		})
+	}
+
+	crl, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every CRL is signed before any file is written, so a failure can't leave a CRL on disk with a number that isn't saved.
+	out := d.CrlFile()
+	if _crlOpts.delta {
+		out = d.DeltaCrlFile()
+	}
+	crls := map[string][]byte{out: crl}
+	if !_crlOpts.delta {
+		// Previous roots only have full CRLs, since the base CRL number is only tracked for the current root.
+		for _, root := range roots[1:] {
+			if now.After(root.cert.NotAfter) {
+				continue
+			}
+			rootKey, err := LoadPrivateKeyFromFile(root.rollover.OldKeyFile(), previousPassphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load previous CA key for root %s: %w", root.serial, err)
+			}
+			rootTemplate := &x509.RevocationList{
+				Number:                    template.Number,
+				ThisUpdate:                now,
+				NextUpdate:                template.NextUpdate,
+				RevokedCertificateEntries: d.crlEntries(entries, roots, root.serial, false, now),
+			}
+			rootCrl, err := x509.CreateRevocationList(rand.Reader, rootTemplate, root.cert, rootKey)
+			if err != nil {
+				return nil, err
+			}
+			crls[root.rollover.CrlFile()] = rootCrl
+		}
+	}
+	for file, der := range crls {
+		if err := ioutil.WriteFile(file, der, 0644); err != nil {
+			return nil, err
+		}
+	}
+	d.Config.CrlNumber++
+	if !_crlOpts.delta {
+		d.Config.BaseCrlNumber = d.Config.CrlNumber
+		d.Config.BaseCrlTime = &now
+	}
+	if err := d.SaveConfig(); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// crlEntries lists the revoked certificates issued by the root with the issuer serial number. Expired certificates are left out.
+// A delta CRL only lists revocations and releases from hold since the last full CRL.
+func (d *CaDir) crlEntries(entries []*IndexEntry, roots []*caRoot, issuer string, delta bool, now time.Time) []x509.RevocationListEntry {
+	var revoked []x509.RevocationListEntry
+	for _, entry := range entries {
+		if now.After(entry.NotAfter) {
+			continue
+		}
+		if delta && (entry.StatusChangedAt == nil || !entry.StatusChangedAt.After(*d.Config.BaseCrlTime)) {
+			continue
+		}
+		if entry.Status != CertStatusRevoked && !(delta && entry.HoldReleasedAt != nil) {
+			continue
+		}
+		if d.entryIssuer(entry, roots) != issuer {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(entry.Serial, 16)
+		if !ok {
+			continue
+		}
+		switch {
+		case entry.Status == CertStatusRevoked:
+			revoked = append(revoked, x509.RevocationListEntry{
+				SerialNumber:   serial,
+				RevocationTime: *entry.RevokedAt,
+				ReasonCode:     int(entry.RevocationReason),
+			})
+		case delta && entry.HoldReleasedAt != nil:
+			revoked = append(revoked, x509.RevocationListEntry{
+				SerialNumber:   serial,
+				RevocationTime: *entry.HoldReleasedAt,
+				ReasonCode:     int(ReasonRemoveFromCRL),
+			})
+		}
+	}
+	return revoked
+}
@@ -0,0 +1,202 @@
+package business
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/drognisep/certserver/business/format"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testRevocation struct {
+	cert   int
+	reason RevocationReason
+}
+
+func TestNewCrl(t *testing.T) {
+	tests := map[string]struct {
+		// revoked are applied before the full CRL, and deltaRevoked after it, before the delta CRL.
+		revoked      []testRevocation
+		delta        bool
+		deltaRevoked []testRevocation
+		// want maps the index of each certificate expected on the CRL to its reason code.
+		want map[int]int
+	}{
+		"empty": {
+			want: map[int]int{},
+		},
+		"revoked certs": {
+			revoked: []testRevocation{{0, ReasonKeyCompromise}, {2, ReasonCertificateHold}},
+			want:    map[int]int{0: int(ReasonKeyCompromise), 2: int(ReasonCertificateHold)},
+		},
+		"released hold is left out": {
+			revoked: []testRevocation{{1, ReasonCertificateHold}, {1, ReasonRemoveFromCRL}},
+			want:    map[int]int{},
+		},
+		"hold made permanent": {
+			revoked: []testRevocation{{1, ReasonCertificateHold}, {1, ReasonSuperseded}},
+			want:    map[int]int{1: int(ReasonSuperseded)},
+		},
+		"delta lists changes since the full CRL": {
+			revoked:      []testRevocation{{0, ReasonKeyCompromise}},
+			delta:        true,
+			deltaRevoked: []testRevocation{{1, ReasonSuperseded}},
+			want:         map[int]int{1: int(ReasonSuperseded)},
+		},
+		"delta lists released holds": {
+			revoked:      []testRevocation{{2, ReasonCertificateHold}},
+			delta:        true,
+			deltaRevoked: []testRevocation{{2, ReasonRemoveFromCRL}},
+			want:         map[int]int{2: int(ReasonRemoveFromCRL)},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := newTestCaDir(t)
+			caCert, err := LoadCertFromFile(dir.CertFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var certs []*x509.Certificate
+			for _, dnsName := range []string{"a.example.com", "b.example.com", "c.example.com"} {
+				certs = append(certs, issueTestCert(t, dir, dnsName))
+			}
+			revoke := func(revocations []testRevocation) {
+				for _, r := range revocations {
+					if _, err := dir.Revoke(certs[r.cert].SerialNumber.Text(16), r.reason, time.Now()); err != nil {
+						t.Fatalf("Revoke: %v", err)
+					}
+				}
+			}
+
+			revoke(tc.revoked)
+			der, err := dir.NewCrl(CrlCaKeyPassphrase(testPassphrase))
+			if err != nil {
+				t.Fatalf("NewCrl: %v", err)
+			}
+			if tc.delta {
+				revoke(tc.deltaRevoked)
+				if der, err = dir.NewCrl(CrlCaKeyPassphrase(testPassphrase), CrlDelta()); err != nil {
+					t.Fatalf("NewCrl delta: %v", err)
+				}
+			}
+
+			crl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := crl.CheckSignatureFrom(caCert); err != nil {
+				t.Fatalf("CRL signature: %v", err)
+			}
+			hasDeltaIndicator := false
+			for _, ext := range crl.Extensions {
+				hasDeltaIndicator = hasDeltaIndicator || ext.Id.Equal(oidDeltaCrlIndicator)
+			}
+			if hasDeltaIndicator != tc.delta {
+				t.Errorf("expected delta CRL indicator to be %v", tc.delta)
+			}
+
+			got := map[int]int{}
+			for _, entry := range crl.RevokedCertificateEntries {
+				found := false
+				for i, cert := range certs {
+					if cert.SerialNumber.Cmp(entry.SerialNumber) == 0 {
+						got[i] = entry.ReasonCode
+						found = true
+					}
+				}
+				if !found {
+					t.Errorf("unexpected serial %s on the CRL", entry.SerialNumber.Text(16))
+				}
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected CRL entries %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestNewCrlNumbers(t *testing.T) {
+	dir := newTestCaDir(t)
+	if _, err := dir.NewCrl(CrlCaKeyPassphrase(testPassphrase), CrlDelta()); !errors.Is(err, ErrNoBaseCrl) {
+		t.Fatalf("expected ErrNoBaseCrl before the first full CRL, got %v", err)
+	}
+	for want := int64(1); want <= 3; want++ {
+		der, err := dir.NewCrl(CrlCaKeyPassphrase(testPassphrase))
+		if err != nil {
+			t.Fatalf("NewCrl: %v", err)
+		}
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if crl.Number.Int64() != want {
+			t.Fatalf("expected CRL number %d, got %s", want, crl.Number)
+		}
+	}
+}
+
+func TestNewCrlOmitsExpiredCerts(t *testing.T) {
+	dir := newTestCaDir(t)
+	expired := issueTestCert(t, dir, "old.example.com")
+	if _, err := dir.Revoke(expired.SerialNumber.Text(16), ReasonKeyCompromise, time.Now()); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	// Certificates can't be issued before the root's NotBefore, so the index is backdated instead.
+	entries, err := dir.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries[0].NotAfter = time.Now().Add(-time.Hour).UTC()
+	if err := dir.saveIndex(entries); err != nil {
+		t.Fatal(err)
+	}
+	der, err := dir.NewCrl(CrlCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("NewCrl: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.RevokedCertificateEntries) != 0 {
+		t.Fatalf("expected the expired certificate to be left out, got %d entries", len(crl.RevokedCertificateEntries))
+	}
+}
+
+func TestNewCrlFailureKeepsNumber(t *testing.T) {
+	dir := newTestCaDir(t, CaEncryptKey(testPassphrase, format.KDFScrypt))
+	if _, err := dir.Rollover(testPassphrase); err != nil {
+		t.Fatalf("Rollover: %v", err)
+	}
+	wrongPassphrase := func() ([]byte, error) {
+		return []byte("wrong passphrase"), nil
+	}
+	if _, err := dir.NewCrl(CrlCaKeyPassphrase(testPassphrase), CrlPreviousCaKeyPassphrase(wrongPassphrase)); err == nil {
+		t.Fatal("expected the wrong previous CA key passphrase to fail")
+	}
+	if _, err := os.Stat(dir.CrlFile()); !os.IsNotExist(err) {
+		t.Fatalf("expected no CRL to be written, got %v", err)
+	}
+	reopened, err := OpenCaDir(dir.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reopened.Config.CrlNumber != 0 || reopened.Config.BaseCrlTime != nil {
+		t.Fatal("expected the failed CRL not to use up a CRL number")
+	}
+
+	der, err := reopened.NewCrl(CrlCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("NewCrl: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.Number.Int64() != 1 {
+		t.Fatalf("expected CRL number 1, got %s", crl.Number)
+	}
+}
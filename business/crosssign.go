@@ -0,0 +1,144 @@
+package business
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"time"
+)
+
+var (
+	ErrNotACaCert = errors.New("only CA certificates can be cross-signed")
+	ErrSameCa     = errors.New("the certificate belongs to the issuing CA itself")
+)
+
+// crossSignedProfile is recorded in the CA directory's index in place of a profile name.
+const crossSignedProfile = "cross-signed"
+
+// Extensions that describe the original issuer, which don't apply to a cross-signed certificate.
+var (
+	oidBasicConstraints      = asn1.ObjectIdentifier{2, 5, 29, 19}
+	oidAuthorityInfoAccess   = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 1}
+	oidCrlDistributionPoints = asn1.ObjectIdentifier{2, 5, 29, 31}
+)
+
+type crossSignOpts struct {
+	validity        Validity
+	caKeyPassphrase format.PassphraseFunc
+	caDir           *CaDir
+}
+
+type CrossSignOpt func(opts *crossSignOpts)
+
+// CrossSignValidity sets the validity period. By default, the cross-signed certificate expires with the original,
+// clamped to the issuing CA's expiration.
+func CrossSignValidity(validity Validity) CrossSignOpt {
+	return func(opts *crossSignOpts) {
+		opts.validity = validity
+	}
+}
+
+// CrossSignCaKeyPassphrase sets the source of the passphrase used if the issuing CA key is encrypted.
+func CrossSignCaKeyPassphrase(passphrase format.PassphraseFunc) CrossSignOpt {
+	return func(opts *crossSignOpts) {
+		opts.caKeyPassphrase = passphrase
+	}
+}
+
+// CrossSignRecordIn records the cross-signed certificate in the issuing CA directory's index.
+func CrossSignRecordIn(caDir *CaDir) CrossSignOpt {
+	return func(opts *crossSignOpts) {
+		opts.caDir = caDir
+	}
+}
+
+// CrossSignCert issues a CA certificate under a different CA. The subject, public key, subject key ID, and extensions
+// are kept, so certificates issued by the CA chain to either issuer. The original's path length is kept if the issuing CA allows it,
+// and is otherwise shortened to the issuing CA's limit.
+func CrossSignCert(certFile, caCertFile, caKeyFile string, opts ...CrossSignOpt) ([]byte, error) {
+	_crossSignOpts := &crossSignOpts{
+		caKeyPassphrase: format.PassphrasePrompt("CA key passphrase", false),
+	}
+	for _, opt := range opts {
+		opt(_crossSignOpts)
+	}
+
+	oldCert, err := LoadCertFromFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate '%s': %w", certFile, err)
+	}
+	if !oldCert.IsCA {
+		return nil, ErrNotACaCert
+	}
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate '%s': %w", caCertFile, err)
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("file '%s' is not a CA cert", caCertFile)
+	}
+	if publicKeysEqual(oldCert.PublicKey, caCert.PublicKey) {
+		return nil, ErrSameCa
+	}
+	caKey, err := LoadPrivateKeyFromFile(caKeyFile, _crossSignOpts.caKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key '%s': %w", caKeyFile, err)
+	}
+	if !publicKeysEqual(caCert.PublicKey, caKey.Public()) {
+		return nil, ErrKeyCertMismatch
+	}
+
+	var urls *CaURLs
+	if _crossSignOpts.caDir != nil {
+		urls = _crossSignOpts.caDir.Config.URLs
+	}
+	cert, err := crossSign(oldCert, caCert, caKey, _crossSignOpts.validity, urls)
+	if err != nil {
+		return nil, err
+	}
+	if _crossSignOpts.caDir != nil {
+		if _, err := _crossSignOpts.caDir.Record(cert, crossSignedProfile); err != nil {
+			return nil, fmt.Errorf("failed to record certificate in CA index: %w", err)
+		}
+	}
+	return cert, nil
+}
+
+// crossSign issues a copy of the CA certificate signed by another CA, with the issuing CA's URLs if they're given.
+func crossSign(oldCert, caCert *x509.Certificate, caKey crypto.Signer, validity Validity, urls *CaURLs) ([]byte, error) {
+	serial, err := generateSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	template := renewalTemplate(oldCert)
+	template.SerialNumber = serial
+	// The raw subject is kept so the encoding matches the issuer name of certificates issued by the CA.
+	template.RawSubject = oldCert.RawSubject
+	template.SubjectKeyId = oldCert.SubjectKeyId
+	extensions := template.ExtraExtensions[:0]
+	for _, ext := range template.ExtraExtensions {
+		if !ext.Id.Equal(oidBasicConstraints) && !ext.Id.Equal(oidAuthorityInfoAccess) && !ext.Id.Equal(oidCrlDistributionPoints) {
+			extensions = append(extensions, ext)
+		}
+	}
+	template.ExtraExtensions = extensions
+	urls.apply(template)
+
+	// A path length longer than the issuing CA allows is shortened to the issuer's limit.
+	keepPathLen := oldCert.MaxPathLen >= 0 && (caCert.MaxPathLen < 0 || oldCert.MaxPathLen < caCert.MaxPathLen)
+	maxPathLen, err := subCaPathLen(caCert, oldCert.MaxPathLen, keepPathLen)
+	if err != nil {
+		return nil, err
+	}
+	applyMaxPathLen(template, maxPathLen)
+	template.NotBefore, template.NotAfter, err = validity.period(caCert, func(time.Time) time.Time {
+		return oldCert.NotAfter
+	})
+	if err != nil {
+		return nil, err
+	}
+	return createVerifiedCert(template, caCert, oldCert.PublicKey, caKey)
+}
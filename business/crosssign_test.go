@@ -0,0 +1,93 @@
+package business
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCrossSignCert(t *testing.T) {
+	oldRootFile, oldRootKeyFile := newTestCa(t)
+	oldRoot, err := LoadCertFromFile(oldRootFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := newTestCaDir(t)
+	newRoot, err := LoadCertFromFile(dir.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	leaf, err := signTestCsr(t, csrFile, oldRootFile, oldRootKeyFile)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+
+	der, err := CrossSignCert(oldRootFile, dir.CertFile(), dir.KeyFile(), CrossSignCaKeyPassphrase(testPassphrase), CrossSignRecordIn(dir))
+	if err != nil {
+		t.Fatalf("CrossSignCert: %v", err)
+	}
+	cross, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cross.RawSubject, oldRoot.RawSubject) || !bytes.Equal(cross.SubjectKeyId, oldRoot.SubjectKeyId) {
+		t.Fatal("expected the subject and subject key ID to be kept")
+	}
+	if !cross.IsCA || !bytes.Equal(cross.RawIssuer, newRoot.RawSubject) {
+		t.Fatalf("expected a CA certificate issued by %s, got issuer %s", newRoot.Subject, cross.Issuer)
+	}
+	if !cross.NotAfter.Equal(oldRoot.NotAfter) && !cross.NotAfter.Equal(newRoot.NotAfter) {
+		t.Fatalf("expected the original expiration, clamped to the issuer's, got %s", cross.NotAfter)
+	}
+
+	// Certificates issued by the old root must chain to the new root through the cross-signed certificate.
+	roots := x509.NewCertPool()
+	roots.AddCert(newRoot)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(cross)
+	if _, err := leaf.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Fatalf("expected the leaf to chain to the new root: %v", err)
+	}
+
+	entry, err := dir.Lookup(cross.SerialNumber.Text(16))
+	if err != nil {
+		t.Fatalf("expected the cross-signed certificate to be recorded: %v", err)
+	}
+	if entry.Status != CertStatusValid {
+		t.Fatalf("unexpected status %s", entry.Status)
+	}
+}
+
+func TestCrossSignCertErrors(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	otherCaFile, otherKeyFile := newTestCa(t)
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	leaf, err := signTestCsr(t, csrFile, caCertFile, caKeyFile)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	leafFile := writeTestFile(t, "leaf.cer", leaf.Raw)
+
+	tests := map[string]struct {
+		certFile string
+		keyFile  string
+		opts     []CrossSignOpt
+		wantErr  error
+	}{
+		"not a CA":       {certFile: leafFile, keyFile: caKeyFile, wantErr: ErrNotACaCert},
+		"same CA":        {certFile: caCertFile, keyFile: caKeyFile, wantErr: ErrSameCa},
+		"wrong CA key":   {certFile: otherCaFile, keyFile: otherKeyFile, wantErr: ErrKeyCertMismatch},
+		"start too late": {certFile: otherCaFile, keyFile: caKeyFile, opts: []CrossSignOpt{CrossSignValidity(Validity{Start: time.Now().AddDate(50, 0, 0)})}, wantErr: ErrInvalidValidity},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := CrossSignCert(tc.certFile, caCertFile, tc.keyFile, tc.opts...)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
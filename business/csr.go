@@ -4,7 +4,9 @@ import (
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"github.com/drognisep/certserver/business/format"
 	"net"
+	"net/url"
 	"time"
 )
 
@@ -13,7 +15,12 @@ type csrOpts struct {
 	expirationDate time.Time
 	ipAddresses    []net.IP
 	sans           []string
+	uris           []*url.URL
+	emails         []string
 	keyBits        int
+	keyType        KeyType
+	keyPassphrase  format.PassphraseFunc
+	keyKDF         format.KDF
 	isCA           bool
 }
 
@@ -31,28 +38,72 @@ func CsrAddSan(san string) CsrOpt {
 	}
 }
 
+// CsrAddURI adds a URI SAN, such as a SPIFFE ID. Use ParseURISAN to parse and validate it.
+func CsrAddURI(uri *url.URL) CsrOpt {
+	return func(opts *csrOpts) {
+		opts.uris = append(opts.uris, uri)
+	}
+}
+
+// CsrAddEmail adds an email SAN, as used by S/MIME certificates.
+func CsrAddEmail(email string) CsrOpt {
+	return func(opts *csrOpts) {
+		opts.emails = append(opts.emails, email)
+	}
+}
+
+func CsrKeyBits(bits int) CsrOpt {
+	return func(opts *csrOpts) {
+		opts.keyBits = bits
+	}
+}
+
+func CsrKeyType(keyType KeyType) CsrOpt {
+	return func(opts *csrOpts) {
+		opts.keyType = keyType
+	}
+}
+
+// CsrEncryptKey causes the private key to be output as an encrypted PKCS#8 key.
+func CsrEncryptKey(passphrase format.PassphraseFunc, kdf format.KDF) CsrOpt {
+	return func(opts *csrOpts) {
+		opts.keyPassphrase = passphrase
+		opts.keyKDF = kdf
+	}
+}
+
 func NewGeneratedCsr(commonName string, name pkix.Name, opts ...CsrOpt) (csr []byte, priv []byte, err error) {
 	_csrOpts := &csrOpts{
 		name:    name,
 		keyBits: 4096,
+		keyType: KeyTypeRSA,
+		keyKDF:  format.KDFPBKDF2,
 	}
 	_csrOpts.name.CommonName = commonName
 
 	for _, opt := range opts {
 		opt(_csrOpts)
 	}
+	if err := validateSANs(_csrOpts.uris, _csrOpts.emails); err != nil {
+		return nil, nil, err
+	}
 
-	rsaPriv, err := generateRsaKeypair(_csrOpts.keyBits)
+	signer, err := generateKeypair(_csrOpts.keyType, _csrOpts.keyBits)
+	if err != nil {
+		return nil, nil, err
+	}
+	priv, err = encodePrivateKey(signer, _csrOpts.keyPassphrase, _csrOpts.keyKDF)
 	if err != nil {
 		return nil, nil, err
 	}
-	priv = x509.MarshalPKCS1PrivateKey(rsaPriv)
 
 	csr, err = x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
-		Subject:     _csrOpts.name,
-		IPAddresses: _csrOpts.ipAddresses,
-		DNSNames:    _csrOpts.sans,
-	}, rsaPriv)
+		Subject:        _csrOpts.name,
+		IPAddresses:    _csrOpts.ipAddresses,
+		DNSNames:       _csrOpts.sans,
+		URIs:           _csrOpts.uris,
+		EmailAddresses: _csrOpts.emails,
+	}, signer)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -0,0 +1,277 @@
+package business
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrInvalidExtension = errors.New("invalid certificate extension")
+
+var (
+	oidKeyUsage            = asn1.ObjectIdentifier{2, 5, 29, 15}
+	oidSubjectAltName      = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidNameConstraints     = asn1.ObjectIdentifier{2, 5, 29, 30}
+	oidCertificatePolicies = asn1.ObjectIdentifier{2, 5, 29, 32}
+	oidTLSFeature          = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24}
+	oidQualifierCPS        = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 1}
+	oidQualifierUserNotice = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 2, 2}
+)
+
+// managedExtensions can't be set as custom extensions, since they would bypass the CSR checks, the profile's key usages,
+// the CA constraints, or the key IDs and URLs the signer derives. The x509 package would otherwise add a second copy.
+var managedExtensions = map[string]asn1.ObjectIdentifier{
+	"key usage":                    oidKeyUsage,
+	"extended key usage":           oidExtKeyUsage,
+	"subject alternative name":     oidSubjectAltName,
+	"basic constraints":            oidBasicConstraints,
+	"name constraints":             oidNameConstraints,
+	"subject key identifier":       oidSubjectKeyId,
+	"authority key identifier":     oidAuthorityKeyId,
+	"authority information access": oidAuthorityInfoAccess,
+	"CRL distribution points":      oidCrlDistributionPoints,
+}
+
+// mustStapleValue is the TLS feature extension value requesting status_request, as described in RFC 7633.
+var mustStapleValue = []byte{0x30, 0x03, 0x02, 0x01, 0x05}
+
+// maxUserNoticeLength is the longest explicit text RFC 5280 allows in a user notice.
+const maxUserNoticeLength = 200
+
+// CustomExtension is an extension given by OID, added to a certificate as-is.
+type CustomExtension struct {
+	OID      string `yaml:"oid"`
+	Critical bool   `yaml:"critical"`
+	// Value is the base64 encoded DER value of the extension. It may instead have a prefix: 'hex:' or 'base64:' for a DER value,
+	// or 'utf8:', 'ia5:', 'printable:', 'int:', 'bool:', 'oid:', or 'null:' for a value of that ASN.1 type, such as 'utf8:Example'.
+	Value string `yaml:"value"`
+}
+
+// ParseCustomExtension parses an extension given as 'OID=VALUE', where VALUE is in the form described by CustomExtension.
+func ParseCustomExtension(spec string, critical bool) (CustomExtension, error) {
+	oid, value, ok := strings.Cut(spec, "=")
+	if !ok {
+		return CustomExtension{}, fmt.Errorf("%w '%s', must be in the form 'OID=VALUE'", ErrInvalidExtension, spec)
+	}
+	ext := CustomExtension{OID: strings.TrimSpace(oid), Critical: critical, Value: value}
+	if _, err := ext.extension(); err != nil {
+		return CustomExtension{}, err
+	}
+	return ext, nil
+}
+
+func (e CustomExtension) extension() (pkix.Extension, error) {
+	oid, err := parseOid(e.OID)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("%w: %v", ErrInvalidExtension, err)
+	}
+	for name, managed := range managedExtensions {
+		if oid.Equal(managed) {
+			return pkix.Extension{}, fmt.Errorf("%w: the %s extension can't be set directly", ErrInvalidExtension, name)
+		}
+	}
+	value, err := parseExtensionValue(e.Value)
+	if err != nil {
+		return pkix.Extension{}, fmt.Errorf("%w '%s': %v", ErrInvalidExtension, e.OID, err)
+	}
+	return pkix.Extension{Id: oid, Critical: e.Critical, Value: value}, nil
+}
+
+// parseExtensionValue returns the DER encoding of a value in the form described by CustomExtension.
+func parseExtensionValue(value string) ([]byte, error) {
+	kind, text, ok := strings.Cut(value, ":")
+	if !ok {
+		kind, text = "base64", value
+	}
+	var (
+		der []byte
+		err error
+	)
+	switch strings.ToLower(kind) {
+	case "hex":
+		der, err = hex.DecodeString(strings.ReplaceAll(text, ":", ""))
+	case "base64":
+		der, err = base64.StdEncoding.DecodeString(text)
+	case "utf8":
+		der, err = asn1.MarshalWithParams(text, "utf8")
+	case "ia5":
+		der, err = asn1.MarshalWithParams(text, "ia5")
+	case "printable":
+		der, err = asn1.MarshalWithParams(text, "printable")
+	case "int":
+		n, ok := new(big.Int).SetString(text, 10)
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not an integer", text)
+		}
+		der, err = asn1.Marshal(n)
+	case "bool":
+		b, parseErr := strconv.ParseBool(text)
+		if parseErr != nil {
+			return nil, fmt.Errorf("'%s' is not a boolean", text)
+		}
+		der, err = asn1.Marshal(b)
+	case "oid":
+		oid, parseErr := parseOid(text)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		der, err = asn1.Marshal(oid)
+	case "null":
+		der = asn1.NullBytes
+	default:
+		return nil, fmt.Errorf("unknown value type '%s', must be one of hex, base64, utf8, ia5, printable, int, bool, oid, null", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(der) == 0 {
+		return nil, errors.New("the value is empty")
+	}
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(der, &raw); err != nil || len(rest) > 0 {
+		return nil, errors.New("the value is not a single DER encoded ASN.1 value")
+	}
+	return der, nil
+}
+
+// CertPolicy is a certificate policy, optionally qualified with CPS URIs and a user notice shown to relying parties.
+type CertPolicy struct {
+	OID        string   `yaml:"oid"`
+	CPS        []string `yaml:"cps"`
+	UserNotice string   `yaml:"userNotice"`
+}
+
+// ParseCertPolicy parses a policy given as 'OID[;cps=URI]...[;notice=TEXT]'.
+func ParseCertPolicy(spec string) (CertPolicy, error) {
+	parts := strings.Split(spec, ";")
+	policy := CertPolicy{OID: strings.TrimSpace(parts[0])}
+	for _, part := range parts[1:] {
+		key, value, _ := strings.Cut(part, "=")
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "cps":
+			policy.CPS = append(policy.CPS, strings.TrimSpace(value))
+		case "notice":
+			policy.UserNotice = value
+		default:
+			return CertPolicy{}, fmt.Errorf("%w: unknown policy qualifier '%s' in '%s', must be 'cps' or 'notice'", ErrInvalidExtension, key, spec)
+		}
+	}
+	if _, err := certPoliciesExtension([]CertPolicy{policy}); err != nil {
+		return CertPolicy{}, err
+	}
+	return policy, nil
+}
+
+type policyInformation struct {
+	PolicyIdentifier asn1.ObjectIdentifier
+	Qualifiers       []policyQualifierInfo `asn1:"optional,omitempty"`
+}
+
+type policyQualifierInfo struct {
+	PolicyQualifierId asn1.ObjectIdentifier
+	Qualifier         asn1.RawValue
+}
+
+type userNotice struct {
+	ExplicitText string `asn1:"utf8"`
+}
+
+// certPoliciesExtension builds the certificate policies extension, which the x509 package can't qualify.
+func certPoliciesExtension(policies []CertPolicy) (pkix.Extension, error) {
+	var infos []policyInformation
+	for _, policy := range policies {
+		oid, err := parseOid(policy.OID)
+		if err != nil {
+			return pkix.Extension{}, fmt.Errorf("%w: invalid policy: %v", ErrInvalidExtension, err)
+		}
+		for _, info := range infos {
+			if info.PolicyIdentifier.Equal(oid) {
+				return pkix.Extension{}, fmt.Errorf("%w: policy '%s' is given more than once", ErrInvalidExtension, policy.OID)
+			}
+		}
+		info := policyInformation{PolicyIdentifier: oid}
+		for _, cps := range policy.CPS {
+			if cps == "" {
+				return pkix.Extension{}, fmt.Errorf("%w: policy '%s' has an empty CPS URI", ErrInvalidExtension, policy.OID)
+			}
+			qualifier, err := asn1.MarshalWithParams(cps, "ia5")
+			if err != nil {
+				return pkix.Extension{}, fmt.Errorf("%w: invalid CPS URI '%s': %v", ErrInvalidExtension, cps, err)
+			}
+			info.Qualifiers = append(info.Qualifiers, policyQualifierInfo{PolicyQualifierId: oidQualifierCPS, Qualifier: asn1.RawValue{FullBytes: qualifier}})
+		}
+		if policy.UserNotice != "" {
+			if utf8.RuneCountInString(policy.UserNotice) > maxUserNoticeLength {
+				return pkix.Extension{}, fmt.Errorf("%w: the user notice of policy '%s' is longer than %d characters", ErrInvalidExtension, policy.OID, maxUserNoticeLength)
+			}
+			qualifier, err := asn1.Marshal(userNotice{ExplicitText: policy.UserNotice})
+			if err != nil {
+				return pkix.Extension{}, err
+			}
+			info.Qualifiers = append(info.Qualifiers, policyQualifierInfo{PolicyQualifierId: oidQualifierUserNotice, Qualifier: asn1.RawValue{FullBytes: qualifier}})
+		}
+		infos = append(infos, info)
+	}
+	value, err := asn1.Marshal(infos)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+	return pkix.Extension{Id: oidCertificatePolicies, Value: value}, nil
+}
+
+// applyExtensions adds the custom extensions, the certificate policies, and the TLS feature extension if must-staple is set.
+// Extensions already on the template are replaced if replace is set, and are otherwise an error.
+func applyExtensions(template *x509.Certificate, extensions []CustomExtension, policies []CertPolicy, mustStaple, replace bool) error {
+	var add []pkix.Extension
+	for _, custom := range extensions {
+		ext, err := custom.extension()
+		if err != nil {
+			return err
+		}
+		add = append(add, ext)
+	}
+	if len(policies) > 0 {
+		ext, err := certPoliciesExtension(policies)
+		if err != nil {
+			return err
+		}
+		add = append(add, ext)
+	}
+	if mustStaple {
+		add = append(add, pkix.Extension{Id: oidTLSFeature, Value: mustStapleValue})
+	}
+
+	for i, ext := range add {
+		for _, other := range add[:i] {
+			if other.Id.Equal(ext.Id) {
+				return fmt.Errorf("%w: extension '%s' is given more than once", ErrInvalidExtension, ext.Id)
+			}
+		}
+		if err := setExtension(template, ext, replace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setExtension(template *x509.Certificate, ext pkix.Extension, replace bool) error {
+	for i, existing := range template.ExtraExtensions {
+		if existing.Id.Equal(ext.Id) {
+			if !replace {
+				return fmt.Errorf("%w: extension '%s' is given more than once", ErrInvalidExtension, ext.Id)
+			}
+			template.ExtraExtensions[i] = ext
+			return nil
+		}
+	}
+	template.ExtraExtensions = append(template.ExtraExtensions, ext)
+	return nil
+}
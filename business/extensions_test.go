@@ -0,0 +1,112 @@
+package business
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func TestParseCustomExtension(t *testing.T) {
+	tests := map[string]struct {
+		spec string
+		// want is the DER value of the extension, or nil if the spec must be rejected.
+		want []byte
+	}{
+		"base64":           {spec: "1.2.3.4=BQA=", want: asn1.NullBytes},
+		"hex":              {spec: "1.2.3.4=hex:05:00", want: asn1.NullBytes},
+		"null":             {spec: "1.2.3.4=null:", want: asn1.NullBytes},
+		"utf8":             {spec: "1.2.3.4=utf8:Example", want: []byte{0x0c, 0x07, 'E', 'x', 'a', 'm', 'p', 'l', 'e'}},
+		"ia5":              {spec: "1.2.3.4=ia5:a", want: []byte{0x16, 0x01, 'a'}},
+		"int":              {spec: "1.2.3.4=int:5", want: []byte{0x02, 0x01, 0x05}},
+		"bool":             {spec: "1.2.3.4=bool:true", want: []byte{0x01, 0x01, 0xff}},
+		"oid":              {spec: "1.2.3.4=oid:1.2.3", want: []byte{0x06, 0x02, 0x2a, 0x03}},
+		"no value":         {spec: "1.2.3.4"},
+		"bad OID":          {spec: "example=null:"},
+		"unknown type":     {spec: "1.2.3.4=float:1.5"},
+		"not DER":          {spec: "1.2.3.4=hex:0500ff"},
+		"empty value":      {spec: "1.2.3.4=hex:"},
+		"SAN":              {spec: "2.5.29.17=null:"},
+		"name constraint":  {spec: "2.5.29.30=null:"},
+		"key usage":        {spec: "2.5.29.15=hex:03020780"},
+		"ext key usage":    {spec: "2.5.29.37=hex:300a06082b06010505070301"},
+		"subject key ID":   {spec: "2.5.29.14=hex:04020102"},
+		"authority key ID": {spec: "2.5.29.35=hex:300480020102"},
+		"AIA":              {spec: "1.3.6.1.5.5.7.1.1=null:"},
+		"CRL DP":           {spec: "2.5.29.31=null:"},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			custom, err := ParseCustomExtension(tc.spec, true)
+			if tc.want == nil {
+				if !errors.Is(err, ErrInvalidExtension) {
+					t.Fatalf("expected ErrInvalidExtension, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseCustomExtension: %v", err)
+			}
+			ext, err := custom.extension()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ext.Critical || !bytes.Equal(ext.Value, tc.want) {
+				t.Fatalf("expected a critical extension with value %x, got %x", tc.want, ext.Value)
+			}
+		})
+	}
+}
+
+func TestParseCertPolicy(t *testing.T) {
+	policy, err := ParseCertPolicy("2.23.140.1.2.2;cps=https://example.com/cps;notice=Test policy")
+	if err != nil {
+		t.Fatalf("ParseCertPolicy: %v", err)
+	}
+	if policy.OID != "2.23.140.1.2.2" || len(policy.CPS) != 1 || policy.CPS[0] != "https://example.com/cps" || policy.UserNotice != "Test policy" {
+		t.Fatalf("unexpected policy %+v", policy)
+	}
+	for _, spec := range []string{"policy", "1.2.3;url=https://example.com", "1.2.3;cps=", "1.2.3;notice=" + string(bytes.Repeat([]byte("a"), 201))} {
+		if _, err := ParseCertPolicy(spec); !errors.Is(err, ErrInvalidExtension) {
+			t.Errorf("expected ErrInvalidExtension for '%s', got %v", spec, err)
+		}
+	}
+}
+
+func TestSignExtensions(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	custom, err := ParseCustomExtension("1.3.6.1.4.1.55555.1=utf8:Example", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := ParseCertPolicy("2.23.140.1.2.1;cps=https://example.com/cps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := signTestCsr(t, csrFile, caCertFile, caKeyFile, SignExtension(custom), SignCertPolicy(policy), SignMustStaple())
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+
+	found := map[string]bool{}
+	for _, ext := range cert.Extensions {
+		found[ext.Id.String()] = true
+		if ext.Id.Equal(oidTLSFeature) && !bytes.Equal(ext.Value, mustStapleValue) {
+			t.Fatalf("unexpected TLS feature value %x", ext.Value)
+		}
+	}
+	for _, oid := range []string{"1.3.6.1.4.1.55555.1", oidCertificatePolicies.String(), oidTLSFeature.String()} {
+		if !found[oid] {
+			t.Errorf("expected extension %s in the certificate", oid)
+		}
+	}
+	if len(cert.PolicyIdentifiers) != 1 || cert.PolicyIdentifiers[0].String() != "2.23.140.1.2.1" {
+		t.Fatalf("unexpected policies %v", cert.PolicyIdentifiers)
+	}
+
+	_, err = signTestCsr(t, csrFile, caCertFile, caKeyFile, SignCertPolicy(policy), SignCertPolicy(policy))
+	if !errors.Is(err, ErrInvalidExtension) {
+		t.Fatalf("expected a repeated policy to fail with ErrInvalidExtension, got %v", err)
+	}
+}
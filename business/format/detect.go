@@ -0,0 +1,253 @@
+package format
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+)
+
+type ObjectType int
+
+const (
+	ObjectUnknown ObjectType = iota
+	ObjectCertificate
+	ObjectCSR
+	ObjectCRL
+	ObjectPKCS1PrivateKey
+	ObjectSEC1PrivateKey
+	ObjectPKCS8PrivateKey
+	ObjectEncryptedPKCS8PrivateKey
+	ObjectPKIXPublicKey
+	ObjectPKCS1PublicKey
+	ObjectPKCS7
+	ObjectOpenSSHPrivateKey
+	ObjectSSHPublicKey
+)
+
+var (
+	ErrUnknownObject = errors.New("the input is not a recognized certificate, CSR, CRL, PKCS#7 bundle, or key")
+	ErrNoObjects     = errors.New("the input does not contain any PEM blocks or DER objects")
+)
+
+var objectTypeInfo = map[ObjectType]struct {
+	name    string
+	pemType string
+}{
+	ObjectCertificate:              {"certificate", PemTypeCertificate},
+	ObjectCSR:                      {"CSR", "CERTIFICATE REQUEST"},
+	ObjectCRL:                      {"CRL", "X509 CRL"},
+	ObjectPKCS1PrivateKey:          {"PKCS#1 private key", "RSA PRIVATE KEY"},
+	ObjectSEC1PrivateKey:           {"SEC1 private key", "EC PRIVATE KEY"},
+	ObjectPKCS8PrivateKey:          {"PKCS#8 private key", "PRIVATE KEY"},
+	ObjectEncryptedPKCS8PrivateKey: {"encrypted PKCS#8 private key", "ENCRYPTED PRIVATE KEY"},
+	ObjectPKIXPublicKey:            {"PKIX public key", "PUBLIC KEY"},
+	ObjectPKCS1PublicKey:           {"PKCS#1 public key", "RSA PUBLIC KEY"},
+	ObjectPKCS7:                    {"PKCS#7 bundle", "PKCS7"},
+	ObjectOpenSSHPrivateKey:        {"OpenSSH private key", "OPENSSH PRIVATE KEY"},
+	ObjectSSHPublicKey:             {"SSH public key", ""},
+}
+
+func (t ObjectType) String() string {
+	if info, ok := objectTypeInfo[t]; ok {
+		return info.name
+	}
+	return "unknown"
+}
+
+// PemType returns the PEM block type conventionally used for the object type.
+func (t ObjectType) PemType() string {
+	return objectTypeInfo[t].pemType
+}
+
+// IsPrivateKey reports whether the object type is any private key encoding.
+func (t ObjectType) IsPrivateKey() bool {
+	switch t {
+	case ObjectPKCS1PrivateKey, ObjectSEC1PrivateKey, ObjectPKCS8PrivateKey, ObjectEncryptedPKCS8PrivateKey, ObjectOpenSSHPrivateKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// Object is a single DER encoded item read from a PEM or DER input.
+type Object struct {
+	Type ObjectType
+	Der  []byte
+	// PemType is the block type from the input, which is kept for unknown objects so they round-trip.
+	PemType string
+	Headers map[string]string
+	// Comment is the trailing comment of an SSH public key from an authorized_keys line.
+	Comment string
+}
+
+// DetectDer determines the type of DER encoded object by attempting to parse it.
+func DetectDer(der []byte) ObjectType {
+	switch {
+	case isParseable(x509.ParseCertificate, der):
+		return ObjectCertificate
+	case isParseable(x509.ParseCertificateRequest, der):
+		return ObjectCSR
+	case isParseable(x509.ParseRevocationList, der):
+		return ObjectCRL
+	case IsPKCS7(der):
+		return ObjectPKCS7
+	case isParseable(x509.ParsePKCS1PrivateKey, der):
+		return ObjectPKCS1PrivateKey
+	case isParseable(x509.ParseECPrivateKey, der):
+		return ObjectSEC1PrivateKey
+	case IsEncryptedPKCS8(der):
+		return ObjectEncryptedPKCS8PrivateKey
+	case isParseable(x509.ParsePKCS8PrivateKey, der):
+		return ObjectPKCS8PrivateKey
+	case isParseable(x509.ParsePKIXPublicKey, der):
+		return ObjectPKIXPublicKey
+	case isParseable(x509.ParsePKCS1PublicKey, der):
+		return ObjectPKCS1PublicKey
+	case IsOpenSSHPrivateKey(der):
+		return ObjectOpenSSHPrivateKey
+	default:
+		return ObjectUnknown
+	}
+}
+
+// Detect sniffs whether the input is PEM or DER and returns every object it contains.
+// PEM text around blocks is ignored, and concatenated DER objects are split apart.
+// SSH public keys in authorized_keys format are also accepted, and reported as PEM since they're text.
+func Detect(input []byte) (Encoding, []Object, error) {
+	block, rest := pem.Decode(input)
+	if block != nil {
+		var objects []Object
+		for block != nil {
+			objType := DetectDer(block.Bytes)
+			objects = append(objects, Object{
+				Type:    objType,
+				Der:     block.Bytes,
+				PemType: block.Type,
+				Headers: block.Headers,
+			})
+			block, rest = pem.Decode(rest)
+		}
+		return EncodingPem, objects, nil
+	}
+	if objects, err := parseAuthorizedKeys(input); err == nil {
+		return EncodingPem, objects, nil
+	}
+
+	ders, err := splitDer(input)
+	if err != nil {
+		return 0, nil, err
+	}
+	objects := make([]Object, 0, len(ders))
+	for i, der := range ders {
+		objType := DetectDer(der)
+		if objType == ObjectUnknown {
+			return 0, nil, fmt.Errorf("DER object %d: %w", i+1, ErrUnknownObject)
+		}
+		objects = append(objects, Object{Type: objType, Der: der, PemType: objType.PemType()})
+	}
+	return EncodingDer, objects, nil
+}
+
+// Encode writes the objects in the target encoding. Known objects get their conventional PEM type.
+func Encode(enc Encoding, objects []Object) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, obj := range objects {
+		switch {
+		case obj.Type == ObjectSSHPublicKey && enc == EncodingPem:
+			line, err := encodeAuthorizedKey(obj)
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(line)
+			continue
+		case (obj.Type == ObjectSSHPublicKey || obj.Type == ObjectOpenSSHPrivateKey) && enc == EncodingDer:
+			return nil, fmt.Errorf("the %s can only be written as text, not DER", obj.Type)
+		}
+		switch enc {
+		case EncodingPem:
+			pemType := obj.PemType
+			if obj.Type != ObjectUnknown {
+				pemType = obj.Type.PemType()
+			}
+			if err := pem.Encode(&buf, &pem.Block{Type: pemType, Headers: obj.Headers, Bytes: obj.Der}); err != nil {
+				return nil, err
+			}
+		case EncodingDer:
+			if len(obj.Headers) > 0 {
+				return nil, fmt.Errorf("the '%s' PEM block has headers, which can't be represented in DER", obj.PemType)
+			}
+			buf.Write(obj.Der)
+		default:
+			return nil, fmt.Errorf("unknown encoding %d", enc)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// ExpandPKCS7 replaces each PKCS#7 bundle with the certificates and CRLs it contains.
+func ExpandPKCS7(objects []Object) ([]Object, error) {
+	var expanded []Object
+	for _, obj := range objects {
+		if obj.Type != ObjectPKCS7 {
+			expanded = append(expanded, obj)
+			continue
+		}
+		certs, crls, err := DecodePKCS7(obj.Der)
+		if err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			expanded = append(expanded, Object{Type: ObjectCertificate, Der: cert})
+		}
+		for _, crl := range crls {
+			expanded = append(expanded, Object{Type: ObjectCRL, Der: crl})
+		}
+	}
+	return expanded, nil
+}
+
+// BundlePKCS7 combines all certificates and CRLs into a single PKCS#7 bundle. Any other object is an error.
+func BundlePKCS7(objects []Object) (Object, error) {
+	var certs, crls [][]byte
+	for _, obj := range objects {
+		switch obj.Type {
+		case ObjectCertificate:
+			certs = append(certs, obj.Der)
+		case ObjectCRL:
+			crls = append(crls, obj.Der)
+		default:
+			return Object{}, fmt.Errorf("a %s can't be included in a PKCS#7 bundle", obj.Type)
+		}
+	}
+	der, err := EncodePKCS7(certs, crls)
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Type: ObjectPKCS7, Der: der}, nil
+}
+
+func splitDer(input []byte) ([][]byte, error) {
+	var ders [][]byte
+	rest := input
+	for len(bytes.TrimSpace(rest)) > 0 {
+		var raw asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &raw)
+		if err != nil {
+			return nil, ErrUnknownObject
+		}
+		ders = append(ders, raw.FullBytes)
+	}
+	if len(ders) == 0 {
+		return nil, ErrNoObjects
+	}
+	return ders, nil
+}
+
+func isParseable[T any](parse func([]byte) (T, error), der []byte) bool {
+	_, err := parse(der)
+	return err == nil
+}
@@ -0,0 +1,138 @@
+package format
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testObjects returns one DER object of each type that can be generated without the business package.
+func testObjects(t *testing.T) map[ObjectType][]byte {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, template, template, ecKey.Public(), ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(certDer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrDer, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "Test"}}, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crlDer, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now(),
+		NextUpdate: time.Now().Add(time.Hour),
+	}, cert, ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec1Der, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedDer, err := EncryptPKCS8(pkcs8Der, []byte("passphrase"), KDFPBKDF2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkixDer, err := x509.MarshalPKIXPublicKey(ecKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[ObjectType][]byte{
+		ObjectCertificate:              certDer,
+		ObjectCSR:                      csrDer,
+		ObjectCRL:                      crlDer,
+		ObjectPKCS1PrivateKey:          x509.MarshalPKCS1PrivateKey(rsaKey),
+		ObjectSEC1PrivateKey:           sec1Der,
+		ObjectPKCS8PrivateKey:          pkcs8Der,
+		ObjectEncryptedPKCS8PrivateKey: encryptedDer,
+		ObjectPKIXPublicKey:            pkixDer,
+		ObjectPKCS1PublicKey:           x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for objType, der := range testObjects(t) {
+		t.Run(objType.String(), func(t *testing.T) {
+			if got := DetectDer(der); got != objType {
+				t.Fatalf("DetectDer: expected %s, got %s", objType, got)
+			}
+
+			pemInput := append([]byte("Leading text is ignored\n"), pem.EncodeToMemory(&pem.Block{Type: objType.PemType(), Bytes: der})...)
+			for input, wantEnc := range map[string]Encoding{string(pemInput): EncodingPem, string(der): EncodingDer} {
+				enc, objects, err := Detect([]byte(input))
+				if err != nil {
+					t.Fatalf("Detect %s: %v", wantEnc, err)
+				}
+				if enc != wantEnc {
+					t.Errorf("expected %s encoding, got %s", wantEnc, enc)
+				}
+				if len(objects) != 1 || objects[0].Type != objType || !bytes.Equal(objects[0].Der, der) {
+					t.Fatalf("expected a single %s from %s input", objType, wantEnc)
+				}
+			}
+		})
+	}
+}
+
+func TestDetectConcatenatedDer(t *testing.T) {
+	objects := testObjects(t)
+	input := append(append([]byte{}, objects[ObjectCertificate]...), objects[ObjectPKCS8PrivateKey]...)
+	enc, detected, err := Detect(input)
+	if err != nil {
+		t.Fatalf("Detect: %v", err)
+	}
+	if enc != EncodingDer || len(detected) != 2 || detected[0].Type != ObjectCertificate || detected[1].Type != ObjectPKCS8PrivateKey {
+		t.Fatalf("expected a DER certificate and key, got %d objects", len(detected))
+	}
+}
+
+func TestDetectUnknown(t *testing.T) {
+	unknownPem := pem.EncodeToMemory(&pem.Block{Type: "SOMETHING ELSE", Bytes: []byte("not DER")})
+	_, objects, err := Detect(unknownPem)
+	if err != nil {
+		t.Fatalf("expected unknown PEM blocks to be kept, got %v", err)
+	}
+	if len(objects) != 1 || objects[0].Type != ObjectUnknown || objects[0].PemType != "SOMETHING ELSE" {
+		t.Fatal("expected the unknown block to keep its PEM type")
+	}
+
+	// A SEQUENCE holding a single INTEGER is valid DER, but not any known object.
+	if _, _, err := Detect([]byte{0x30, 0x03, 0x02, 0x01, 0x01}); !errors.Is(err, ErrUnknownObject) {
+		t.Fatalf("expected ErrUnknownObject for unknown DER, got %v", err)
+	}
+}
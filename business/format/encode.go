@@ -0,0 +1,51 @@
+package format
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+const (
+	PemTypeCertificate = "CERTIFICATE"
+)
+
+// ParseEncoding accepts "der" or "pem", ignoring case.
+func ParseEncoding(name string) (Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "der":
+		return EncodingDer, nil
+	case "pem":
+		return EncodingPem, nil
+	default:
+		return 0, fmt.Errorf("unknown encoding '%s', must be one of der, pem", name)
+	}
+}
+
+// EncodeCerts encodes DER certificates as a PEM bundle, or concatenated DER.
+func EncodeCerts(enc Encoding, certs ...[]byte) []byte {
+	return encodeBlocks(enc, PemTypeCertificate, certs...)
+}
+
+// EncodePrivateKey encodes a DER private key as PEM with a block type matching its encoding, or returns it as-is for DER.
+func EncodePrivateKey(enc Encoding, der []byte) []byte {
+	return encodeBlocks(enc, privateKeyPemType(der), der)
+}
+
+func encodeBlocks(enc Encoding, blockType string, ders ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, der := range ders {
+		if enc == EncodingPem {
+			buf.Write(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}))
+			continue
+		}
+		buf.Write(der)
+	}
+	return buf.Bytes()
+}
+
+// EncodeCSR encodes a DER certificate request as PEM, or returns it as-is for DER.
+func EncodeCSR(enc Encoding, der []byte) []byte {
+	return encodeBlocks(enc, ObjectCSR.PemType(), der)
+}
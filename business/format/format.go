@@ -3,7 +3,6 @@ package format
 import (
 	"bufio"
 	"bytes"
-	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -23,51 +22,149 @@ var (
 	ErrCancelOverwrite = errors.New("user declined to overwrite")
 )
 
-func Cert(sourceFmt, targetFmt Encoding, inFile, outFile string) error {
-	exists, err := fileExists(outFile)
-	if err != nil {
-		return err
+func (e Encoding) String() string {
+	switch e {
+	case EncodingDer:
+		return "DER"
+	case EncodingPem:
+		return "PEM"
+	default:
+		return fmt.Sprintf("Encoding(%d)", int(e))
 	}
-	if exists {
-		if !confirmOverwrite(outFile) {
-			return ErrCancelOverwrite
-		}
+}
+
+type convertOpts struct {
+	sourceFmt  Encoding
+	objectType func(ObjectType) bool
+	passphrase PassphraseFunc
+	keyTarget  ObjectType
+	toPKCS7    bool
+}
+
+type ConvertOpt func(opts *convertOpts)
+
+// ConvertFrom requires the input to be in the given encoding, rather than accepting either.
+func ConvertFrom(sourceFmt Encoding) ConvertOpt {
+	return func(opts *convertOpts) {
+		opts.sourceFmt = sourceFmt
+	}
+}
+
+// ConvertOnly requires every object in the input to match the filter.
+func ConvertOnly(filter func(ObjectType) bool) ConvertOpt {
+	return func(opts *convertOpts) {
+		opts.objectType = filter
 	}
+}
+
+// DecryptKeys causes encrypted PKCS#8 keys to be decrypted. Without this, encrypted keys are output encrypted,
+// and converting one with ConvertKeysTo fails with ErrConvertEncryptedKey.
+func DecryptKeys(passphrase PassphraseFunc) ConvertOpt {
+	return func(opts *convertOpts) {
+		opts.passphrase = passphrase
+	}
+}
+
+// ConvertKeysTo re-encodes every key in the input as the target key object type. See ConvertKey.
+func ConvertKeysTo(target ObjectType) ConvertOpt {
+	return func(opts *convertOpts) {
+		opts.keyTarget = target
+	}
+}
+
+// ConvertToPKCS7 bundles every certificate and CRL into a single PKCS#7 (.p7b) object.
+// PKCS#7 inputs are otherwise expanded into their certificates and CRLs.
+func ConvertToPKCS7() ConvertOpt {
+	return func(opts *convertOpts) {
+		opts.toPKCS7 = true
+	}
+}
+
+// Convert detects the encoding and contents of inFile and writes every object to outFile in the target encoding.
+func Convert(targetFmt Encoding, inFile, outFile string, opts ...ConvertOpt) error {
+	_convertOpts := &convertOpts{}
+	for _, opt := range opts {
+		opt(_convertOpts)
+	}
+
 	inBytes, err := ioutil.ReadFile(inFile)
 	if err != nil {
 		return err
 	}
-	out, err := os.OpenFile(outFile, os.O_CREATE|os.O_WRONLY, 0600)
+	sourceFmt, objects, err := Detect(inBytes)
 	if err != nil {
 		return err
 	}
-
-	switch sourceFmt {
-	case EncodingPem:
-		block, _ := pem.Decode(inBytes)
-		inBytes = block.Bytes
-	case EncodingDer:
-		// No op, DER is the normalized form.
+	if _convertOpts.sourceFmt != 0 && _convertOpts.sourceFmt != sourceFmt {
+		return fmt.Errorf("'%s' is not %s encoded", inFile, _convertOpts.sourceFmt)
 	}
-	switch targetFmt {
-	case EncodingPem:
-		err := pem.Encode(out, &pem.Block{
-			Type:  "CERTIFICATE",
-			Bytes: inBytes,
-		})
-		if err != nil {
-			return err
+	objects, err = ExpandPKCS7(objects)
+	if err != nil {
+		return err
+	}
+	for i, obj := range objects {
+		if _convertOpts.objectType != nil && !_convertOpts.objectType(obj.Type) {
+			return fmt.Errorf("'%s' contains an unexpected %s", inFile, obj.Type)
 		}
-	case EncodingDer:
-		buf := bytes.NewBuffer(inBytes)
-		if _, err := io.Copy(out, buf); err != nil {
+		if IsEncryptedKey(obj.Der) && _convertOpts.passphrase != nil {
+			passphrase, err := _convertOpts.passphrase()
+			if err != nil {
+				return err
+			}
+			if obj.Type == ObjectOpenSSHPrivateKey {
+				plain, err := DecryptOpenSSH(obj.Der, passphrase)
+				if err != nil {
+					return err
+				}
+				obj = Object{Type: ObjectOpenSSHPrivateKey, Der: plain}
+			} else {
+				plain, err := DecryptPKCS8(obj.Der, passphrase)
+				if err != nil {
+					return err
+				}
+				obj = Object{Type: ObjectPKCS8PrivateKey, Der: plain}
+			}
+			objects[i] = obj
+		}
+		if _convertOpts.keyTarget != ObjectUnknown && isKey(obj.Type) {
+			if IsEncryptedKey(obj.Der) {
+				return fmt.Errorf("'%s' contains an %s: %w", inFile, obj.Type, ErrConvertEncryptedKey)
+			}
+			converted, err := ConvertKey(obj.Der, _convertOpts.keyTarget)
+			if err != nil {
+				return err
+			}
+			objects[i] = Object{Type: _convertOpts.keyTarget, Der: converted, Comment: obj.Comment}
+		}
+	}
+	if _convertOpts.toPKCS7 {
+		bundle, err := BundlePKCS7(objects)
+		if err != nil {
 			return err
 		}
+		objects = []Object{bundle}
+	}
+	outBytes, err := Encode(targetFmt, objects)
+	if err != nil {
+		return err
 	}
-	return nil
+
+	return writeConfirmed(outFile, outBytes)
+}
+
+func Cert(sourceFmt, targetFmt Encoding, inFile, outFile string) error {
+	return Convert(targetFmt, inFile, outFile, ConvertFrom(sourceFmt), ConvertOnly(func(t ObjectType) bool {
+		return t == ObjectCertificate
+	}))
 }
 
-func Key(sourceFmt, targetFmt Encoding, inFile, outFile string) error {
+func Key(sourceFmt, targetFmt Encoding, inFile, outFile string, opts ...ConvertOpt) error {
+	opts = append(opts, ConvertFrom(sourceFmt), ConvertOnly(ObjectType.IsPrivateKey))
+	return Convert(targetFmt, inFile, outFile, opts...)
+}
+
+// writeConfirmed writes the file, asking the user to confirm first if it already exists.
+func writeConfirmed(outFile string, outBytes []byte) error {
 	exists, err := fileExists(outFile)
 	if err != nil {
 		return err
@@ -77,38 +174,26 @@ func Key(sourceFmt, targetFmt Encoding, inFile, outFile string) error {
 			return ErrCancelOverwrite
 		}
 	}
-	inBytes, err := ioutil.ReadFile(inFile)
+	out, err := os.OpenFile(outFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
-	out, err := os.OpenFile(outFile, os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
+	defer out.Close()
+	if _, err := io.Copy(out, bytes.NewReader(outBytes)); err != nil {
 		return err
 	}
+	return out.Close()
+}
 
-	switch sourceFmt {
-	case EncodingPem:
-		block, _ := pem.Decode(inBytes)
-		inBytes = block.Bytes
-	case EncodingDer:
-		// No op, DER is the normalized form.
-	}
-	switch targetFmt {
-	case EncodingPem:
-		err := pem.Encode(out, &pem.Block{
-			Type:  "RSA PRIVATE KEY",
-			Bytes: inBytes,
-		})
-		if err != nil {
-			return err
-		}
-	case EncodingDer:
-		buf := bytes.NewBuffer(inBytes)
-		if _, err := io.Copy(out, buf); err != nil {
-			return err
-		}
+func isKey(objType ObjectType) bool {
+	return objType.IsPrivateKey() || objType == ObjectPKIXPublicKey || objType == ObjectPKCS1PublicKey || objType == ObjectSSHPublicKey
+}
+
+func privateKeyPemType(der []byte) string {
+	if objType := DetectDer(der); objType.IsPrivateKey() {
+		return objType.PemType()
 	}
-	return nil
+	return ObjectPKCS8PrivateKey.PemType()
 }
 
 func confirmOverwrite(filename string) bool {
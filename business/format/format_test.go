@@ -0,0 +1,60 @@
+package format
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertKeepsEncryptedKeys(t *testing.T) {
+	plain := testPKCS8Key(t)
+	encrypted, err := EncryptPKCS8(plain, []byte("passphrase"), KDFPBKDF2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := func() ([]byte, error) {
+		return []byte("passphrase"), nil
+	}
+
+	tests := map[string]struct {
+		key  []byte
+		opts []ConvertOpt
+		want ObjectType
+		// wantErr is checked with errors.Is, and nil expects the conversion to succeed.
+		wantErr error
+	}{
+		"plaintext key converted":          {key: plain, opts: []ConvertOpt{ConvertKeysTo(ObjectSEC1PrivateKey)}, want: ObjectSEC1PrivateKey},
+		"encrypted key kept":               {key: encrypted, want: ObjectEncryptedPKCS8PrivateKey},
+		"encrypted key decrypted":          {key: encrypted, opts: []ConvertOpt{DecryptKeys(passphrase)}, want: ObjectPKCS8PrivateKey},
+		"encrypted key converted":          {key: encrypted, opts: []ConvertOpt{DecryptKeys(passphrase), ConvertKeysTo(ObjectSEC1PrivateKey)}, want: ObjectSEC1PrivateKey},
+		"encrypted key can't be converted": {key: encrypted, opts: []ConvertOpt{ConvertKeysTo(ObjectSEC1PrivateKey)}, wantErr: ErrConvertEncryptedKey},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			inFile := filepath.Join(dir, "in.der")
+			if err := ioutil.WriteFile(inFile, tc.key, 0600); err != nil {
+				t.Fatal(err)
+			}
+			outFile := filepath.Join(dir, "out.der")
+			err := Convert(EncodingDer, inFile, outFile, tc.opts...)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert: %v", err)
+			}
+			out, err := ioutil.ReadFile(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := DetectDer(out); got != tc.want {
+				t.Fatalf("expected a %s, got %s", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package format
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+	"strings"
+)
+
+var (
+	ErrIncompatibleKeyEncoding = errors.New("the key type can't be represented in the requested encoding")
+	ErrConvertEncryptedKey     = errors.New("encrypted keys must be decrypted before conversion")
+)
+
+// ParseKeyEncoding maps a key encoding name to the object type it produces.
+// Accepted names are pkcs1, pkcs8, sec1, pkix, pkcs1-public, openssh, and ssh (an authorized_keys line).
+func ParseKeyEncoding(name string) (ObjectType, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "pkcs1":
+		return ObjectPKCS1PrivateKey, nil
+	case "pkcs8":
+		return ObjectPKCS8PrivateKey, nil
+	case "sec1", "ec":
+		return ObjectSEC1PrivateKey, nil
+	case "pkix", "spki":
+		return ObjectPKIXPublicKey, nil
+	case "pkcs1-public":
+		return ObjectPKCS1PublicKey, nil
+	case "openssh":
+		return ObjectOpenSSHPrivateKey, nil
+	case "ssh", "authorized_keys":
+		return ObjectSSHPublicKey, nil
+	default:
+		return ObjectUnknown, fmt.Errorf("unknown key encoding '%s', must be one of pkcs1, pkcs8, sec1, pkix, pkcs1-public, openssh, ssh", name)
+	}
+}
+
+// ConvertKey re-encodes a DER private or public key as the target object type.
+// Private keys may be converted to any private or public key encoding that supports their algorithm,
+// while public keys may only be converted to other public key encodings.
+func ConvertKey(der []byte, target ObjectType) ([]byte, error) {
+	source := DetectDer(der)
+	if source == ObjectUnknown && isParseable(ssh.ParsePublicKey, der) {
+		source = ObjectSSHPublicKey
+	}
+	if IsEncryptedKey(der) {
+		return nil, ErrConvertEncryptedKey
+	}
+
+	var (
+		priv crypto.Signer
+		pub  crypto.PublicKey
+	)
+	switch source {
+	case ObjectPKCS1PrivateKey, ObjectSEC1PrivateKey, ObjectPKCS8PrivateKey, ObjectOpenSSHPrivateKey:
+		var err error
+		priv, err = ParsePrivateKey(der)
+		if err != nil {
+			return nil, err
+		}
+		pub = priv.Public()
+	case ObjectPKIXPublicKey:
+		var err error
+		pub, err = x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+	case ObjectPKCS1PublicKey:
+		var err error
+		pub, err = x509.ParsePKCS1PublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+	case ObjectSSHPublicKey:
+		var err error
+		pub, err = ParseSSHPublicKey(der)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("a %s is not a key", source)
+	}
+
+	if target.IsPrivateKey() && priv == nil {
+		return nil, fmt.Errorf("a %s can't be converted to a private key", source)
+	}
+	switch target {
+	case ObjectPKCS1PrivateKey:
+		rsaKey, ok := priv.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: PKCS#1 only supports RSA keys", ErrIncompatibleKeyEncoding)
+		}
+		return x509.MarshalPKCS1PrivateKey(rsaKey), nil
+	case ObjectSEC1PrivateKey:
+		ecKey, ok := priv.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: SEC1 only supports ECDSA keys", ErrIncompatibleKeyEncoding)
+		}
+		return x509.MarshalECPrivateKey(ecKey)
+	case ObjectPKCS8PrivateKey:
+		return x509.MarshalPKCS8PrivateKey(priv)
+	case ObjectPKIXPublicKey:
+		return x509.MarshalPKIXPublicKey(pub)
+	case ObjectPKCS1PublicKey:
+		rsaKey, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%w: PKCS#1 only supports RSA keys", ErrIncompatibleKeyEncoding)
+		}
+		return x509.MarshalPKCS1PublicKey(rsaKey), nil
+	case ObjectOpenSSHPrivateKey:
+		return marshalOpenSSHPrivateKey(priv)
+	case ObjectSSHPublicKey:
+		return marshalSSHPublicKey(pub)
+	default:
+		return nil, fmt.Errorf("a key can't be converted to a %s", target)
+	}
+}
+
+// ParsePrivateKey accepts a DER encoded PKCS#1, SEC1, or unencrypted PKCS#8 private key, or an unencrypted OpenSSH private key.
+func ParsePrivateKey(der []byte) (crypto.Signer, error) {
+	if IsOpenSSHPrivateKey(der) {
+		return ParseOpenSSHPrivateKey(der, nil)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
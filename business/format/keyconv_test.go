@@ -0,0 +1,119 @@
+package format
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"testing"
+)
+
+func TestParseKeyEncoding(t *testing.T) {
+	for name, want := range map[string]ObjectType{
+		"pkcs1":        ObjectPKCS1PrivateKey,
+		"PKCS8":        ObjectPKCS8PrivateKey,
+		"sec1":         ObjectSEC1PrivateKey,
+		"ec":           ObjectSEC1PrivateKey,
+		"pkix":         ObjectPKIXPublicKey,
+		"spki":         ObjectPKIXPublicKey,
+		"pkcs1-public": ObjectPKCS1PublicKey,
+	} {
+		got, err := ParseKeyEncoding(name)
+		if err != nil || got != want {
+			t.Errorf("ParseKeyEncoding(%s): expected %s, got %s (%v)", name, want, got, err)
+		}
+	}
+	if _, err := ParseKeyEncoding("pem"); err == nil {
+		t.Error("expected an unknown encoding to fail")
+	}
+}
+
+func TestConvertKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8 := func(key crypto.Signer) []byte {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	sec1, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := EncryptPKCS8(pkcs8(ecKey), []byte("passphrase"), KDFPBKDF2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		der    []byte
+		target ObjectType
+		// want is the key the output must hold, or nil if the conversion must fail.
+		want crypto.PublicKey
+		// wantErr is checked with errors.Is when set.
+		wantErr error
+	}{
+		"pkcs1 to pkcs8":              {der: x509.MarshalPKCS1PrivateKey(rsaKey), target: ObjectPKCS8PrivateKey, want: rsaKey.Public()},
+		"pkcs8 to pkcs1":              {der: pkcs8(rsaKey), target: ObjectPKCS1PrivateKey, want: rsaKey.Public()},
+		"sec1 to pkcs8":               {der: sec1, target: ObjectPKCS8PrivateKey, want: ecKey.Public()},
+		"pkcs8 to sec1":               {der: pkcs8(ecKey), target: ObjectSEC1PrivateKey, want: ecKey.Public()},
+		"private to pkix":             {der: pkcs8(edKey), target: ObjectPKIXPublicKey, want: edKey.Public()},
+		"private to pkcs1 public":     {der: pkcs8(rsaKey), target: ObjectPKCS1PublicKey, want: rsaKey.Public()},
+		"ecdsa to pkcs1":              {der: sec1, target: ObjectPKCS1PrivateKey, wantErr: ErrIncompatibleKeyEncoding},
+		"ed25519 to sec1":             {der: pkcs8(edKey), target: ObjectSEC1PrivateKey, wantErr: ErrIncompatibleKeyEncoding},
+		"public to private":           {der: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey), target: ObjectPKCS8PrivateKey},
+		"encrypted must be decrypted": {der: encrypted, target: ObjectSEC1PrivateKey},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			converted, err := ConvertKey(tc.der, tc.target)
+			if tc.want == nil {
+				if err == nil || (tc.wantErr != nil && !errors.Is(err, tc.wantErr)) {
+					t.Fatalf("expected the conversion to fail with %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ConvertKey: %v", err)
+			}
+			if got := DetectDer(converted); got != tc.target {
+				t.Fatalf("expected a %s, got %s", tc.target, got)
+			}
+			var pub crypto.PublicKey
+			switch tc.target {
+			case ObjectPKIXPublicKey:
+				pub, err = x509.ParsePKIXPublicKey(converted)
+			case ObjectPKCS1PublicKey:
+				pub, err = x509.ParsePKCS1PublicKey(converted)
+			default:
+				var priv crypto.Signer
+				priv, err = ParsePrivateKey(converted)
+				if err == nil {
+					pub = priv.Public()
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.want.(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+				t.Fatal("the converted key doesn't match the original")
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package format
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ErrNotSSHPublicKey = errors.New("the input is not an SSH public key")
+)
+
+const openSSHMagic = "openssh-key-v1\x00"
+
+// IsOpenSSHPrivateKey reports whether the bytes are the body of an "OPENSSH PRIVATE KEY" PEM block.
+func IsOpenSSHPrivateKey(der []byte) bool {
+	return bytes.HasPrefix(der, []byte(openSSHMagic))
+}
+
+// IsEncryptedOpenSSH reports whether the bytes are an OpenSSH private key protected by a passphrase.
+func IsEncryptedOpenSSH(der []byte) bool {
+	if !IsOpenSSHPrivateKey(der) {
+		return false
+	}
+	rest := der[len(openSSHMagic):]
+	if len(rest) < 4 {
+		return false
+	}
+	nameLen := binary.BigEndian.Uint32(rest)
+	if uint32(len(rest)-4) < nameLen {
+		return false
+	}
+	return string(rest[4:4+nameLen]) != "none"
+}
+
+// IsEncryptedKey reports whether the bytes are an encrypted PKCS#8 or OpenSSH private key.
+func IsEncryptedKey(der []byte) bool {
+	return IsEncryptedPKCS8(der) || IsEncryptedOpenSSH(der)
+}
+
+// ParseOpenSSHPrivateKey parses an OpenSSH private key, decrypting it with the passphrase if it's encrypted.
+func ParseOpenSSHPrivateKey(der, passphrase []byte) (crypto.Signer, error) {
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: ObjectOpenSSHPrivateKey.PemType(), Bytes: der})
+	var (
+		key interface{}
+		err error
+	)
+	if IsEncryptedOpenSSH(der) {
+		if len(passphrase) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(pemBytes, passphrase)
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, ErrIncorrectPassphrase
+		}
+	} else {
+		key, err = ssh.ParseRawPrivateKey(pemBytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The ssh package returns Ed25519 keys by reference.
+	if edKey, ok := key.(*ed25519.PrivateKey); ok {
+		key = *edKey
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return signer, nil
+}
+
+// DecryptOpenSSH returns the unencrypted form of an encrypted OpenSSH private key. The key comment is dropped.
+func DecryptOpenSSH(der, passphrase []byte) ([]byte, error) {
+	if !IsEncryptedOpenSSH(der) {
+		return nil, ErrNotEncrypted
+	}
+	key, err := ParseOpenSSHPrivateKey(der, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return marshalOpenSSHPrivateKey(key)
+}
+
+// ParseSSHPublicKey parses an SSH public key in the wire format used in the middle field of authorized_keys lines.
+func ParseSSHPublicKey(der []byte) (crypto.PublicKey, error) {
+	sshKey, err := ssh.ParsePublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotSSHPublicKey, err)
+	}
+	cryptoKey, ok := sshKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported SSH public key type '%s'", sshKey.Type())
+	}
+	return cryptoKey.CryptoPublicKey(), nil
+}
+
+// parseAuthorizedKeys reads every key from text in authorized_keys format. Blank lines and comment lines are skipped,
+// but any other line that isn't a public key fails the whole input.
+func parseAuthorizedKeys(input []byte) ([]Object, error) {
+	var objects []Object
+	for len(bytes.TrimSpace(input)) > 0 {
+		line := input
+		if i := bytes.IndexByte(input, '\n'); i >= 0 {
+			line, input = input[:i], input[i+1:]
+		} else {
+			input = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		pubKey, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return nil, ErrNotSSHPublicKey
+		}
+		objects = append(objects, Object{Type: ObjectSSHPublicKey, Der: pubKey.Marshal(), Comment: comment})
+	}
+	if len(objects) == 0 {
+		return nil, ErrNotSSHPublicKey
+	}
+	return objects, nil
+}
+
+// encodeAuthorizedKey formats an SSH public key object as an authorized_keys line.
+func encodeAuthorizedKey(obj Object) ([]byte, error) {
+	pubKey, err := ssh.ParsePublicKey(obj.Der)
+	if err != nil {
+		return nil, err
+	}
+	line := bytes.TrimSpace(ssh.MarshalAuthorizedKey(pubKey))
+	if obj.Comment != "" {
+		line = append(line, ' ')
+		line = append(line, obj.Comment...)
+	}
+	return append(line, '\n'), nil
+}
+
+func marshalOpenSSHPrivateKey(key crypto.Signer) ([]byte, error) {
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		return nil, err
+	}
+	return block.Bytes, nil
+}
+
+func marshalSSHPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	sshKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIncompatibleKeyEncoding, err)
+	}
+	return sshKey.Marshal(), nil
+}
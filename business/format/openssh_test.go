@@ -0,0 +1,129 @@
+package format
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertOpenSSH(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authorizedKey := append(bytes.TrimSpace(ssh.MarshalAuthorizedKey(sshPub)), " user@example.com\n"...)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	openSSH := pem.EncodeToMemory(block)
+	block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := pem.EncodeToMemory(block)
+	pkcs8Der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8 := EncodePrivateKey(EncodingPem, pkcs8Der)
+	passphrase := func() ([]byte, error) {
+		return []byte("passphrase"), nil
+	}
+
+	tests := map[string]struct {
+		input []byte
+		enc   Encoding
+		opts  []ConvertOpt
+		// want is the object type the output must hold, or ObjectUnknown if the conversion must fail.
+		want ObjectType
+		// wantText is checked against the output when set.
+		wantText []byte
+	}{
+		"pkcs8 to openssh":        {input: pkcs8, enc: EncodingPem, opts: []ConvertOpt{ConvertKeysTo(ObjectOpenSSHPrivateKey)}, want: ObjectOpenSSHPrivateKey},
+		"openssh to pkcs8":        {input: openSSH, enc: EncodingDer, opts: []ConvertOpt{ConvertKeysTo(ObjectPKCS8PrivateKey)}, want: ObjectPKCS8PrivateKey},
+		"openssh to ssh":          {input: openSSH, enc: EncodingPem, opts: []ConvertOpt{ConvertKeysTo(ObjectSSHPublicKey)}, want: ObjectSSHPublicKey},
+		"authorized key kept":     {input: authorizedKey, enc: EncodingPem, want: ObjectSSHPublicKey, wantText: authorizedKey},
+		"authorized key to pkix":  {input: authorizedKey, enc: EncodingDer, opts: []ConvertOpt{ConvertKeysTo(ObjectPKIXPublicKey)}, want: ObjectPKIXPublicKey},
+		"encrypted decrypted":     {input: encrypted, enc: EncodingPem, opts: []ConvertOpt{DecryptKeys(passphrase)}, want: ObjectOpenSSHPrivateKey},
+		"openssh written as DER":  {input: openSSH, enc: EncodingDer},
+		"ssh key written as DER":  {input: authorizedKey, enc: EncodingDer},
+		"public key to openssh":   {input: authorizedKey, enc: EncodingPem, opts: []ConvertOpt{ConvertKeysTo(ObjectOpenSSHPrivateKey)}},
+		"encrypted not decrypted": {input: encrypted, enc: EncodingPem, opts: []ConvertOpt{ConvertKeysTo(ObjectPKCS8PrivateKey)}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			inFile := filepath.Join(dir, "in")
+			if err := ioutil.WriteFile(inFile, tc.input, 0600); err != nil {
+				t.Fatal(err)
+			}
+			outFile := filepath.Join(dir, "out")
+			err := Convert(tc.enc, inFile, outFile, tc.opts...)
+			if tc.want == ObjectUnknown {
+				if err == nil {
+					t.Fatal("expected the conversion to fail")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert: %v", err)
+			}
+			out, err := ioutil.ReadFile(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.wantText != nil && !bytes.Equal(out, tc.wantText) {
+				t.Fatalf("expected '%s', got '%s'", tc.wantText, out)
+			}
+			enc, objects, err := Detect(out)
+			if err != nil {
+				t.Fatalf("Detect: %v", err)
+			}
+			if enc != tc.enc || len(objects) != 1 || objects[0].Type != tc.want {
+				t.Fatalf("expected a %s %s", tc.enc, tc.want)
+			}
+			if IsEncryptedKey(objects[0].Der) {
+				t.Fatal("expected the key to be decrypted")
+			}
+		})
+	}
+}
+
+func TestParseOpenSSHPrivateKey(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncryptedOpenSSH(block.Bytes) || DetectDer(block.Bytes) != ObjectOpenSSHPrivateKey {
+		t.Fatal("expected an encrypted OpenSSH private key")
+	}
+	if _, err := ParseOpenSSHPrivateKey(block.Bytes, []byte("wrong")); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Fatalf("expected ErrIncorrectPassphrase, got %v", err)
+	}
+	if _, err := ParseOpenSSHPrivateKey(block.Bytes, nil); !errors.Is(err, ErrEmptyPassphrase) {
+		t.Fatalf("expected ErrEmptyPassphrase, got %v", err)
+	}
+	key, err := ParseOpenSSHPrivateKey(block.Bytes, []byte("passphrase"))
+	if err != nil {
+		t.Fatalf("ParseOpenSSHPrivateKey: %v", err)
+	}
+	if !priv.Equal(key) {
+		t.Fatal("the decrypted key doesn't match the original")
+	}
+}
@@ -0,0 +1,108 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/term"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var (
+	ErrEmptyPassphrase    = errors.New("passphrase must not be empty")
+	ErrPassphraseMismatch = errors.New("passphrases do not match")
+)
+
+// PassphraseFunc supplies the passphrase used to encrypt or decrypt a private key.
+type PassphraseFunc func() ([]byte, error)
+
+// PassphraseFromEnv reads the passphrase from the named environment variable.
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func() ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable '%s' is not set", name)
+		}
+		if value == "" {
+			return nil, ErrEmptyPassphrase
+		}
+		return []byte(value), nil
+	}
+}
+
+// PassphraseFromFile reads the passphrase from the first line of a file.
+func PassphraseFromFile(filename string) PassphraseFunc {
+	return func() ([]byte, error) {
+		fileBytes, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase file '%s': %w", filename, err)
+		}
+		passphrase := bytes.TrimRight(bytes.SplitN(fileBytes, []byte("\n"), 2)[0], "\r")
+		if len(passphrase) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		return passphrase, nil
+	}
+}
+
+// PassphrasePrompt asks for the passphrase on the terminal, without echoing it.
+// If confirm is true then the passphrase must be entered twice.
+func PassphrasePrompt(prompt string, confirm bool) PassphraseFunc {
+	return func() ([]byte, error) {
+		passphrase, err := readPassphrase(prompt)
+		if err != nil {
+			return nil, err
+		}
+		if len(passphrase) == 0 {
+			return nil, ErrEmptyPassphrase
+		}
+		if confirm {
+			again, err := readPassphrase("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+			if err != nil {
+				return nil, err
+			}
+			if !bytes.Equal(passphrase, again) {
+				return nil, ErrPassphraseMismatch
+			}
+		}
+		return passphrase, nil
+	}
+}
+
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Printf("%s: ", prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Println()
+		return passphrase, err
+	}
+	// Read one byte at a time so nothing past the passphrase line is consumed from piped input.
+	var (
+		line []byte
+		b    = make([]byte, 1)
+	)
+	for {
+		n, err := os.Stdin.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				break
+			}
+			line = append(line, b[0])
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			if len(line) == 0 {
+				return nil, errors.New("no passphrase entered")
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bytes.TrimRight(line, "\r"), nil
+}
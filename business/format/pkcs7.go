@@ -0,0 +1,89 @@
+package format
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNotPKCS7 = errors.New("the input is not a PKCS#7 SignedData bundle")
+)
+
+var (
+	oidPKCS7Data       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPKCS7SignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+)
+
+// RFC 2315 section 7
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+// RFC 2315 section 9.1, where the certificates and CRLs are kept as raw implicitly tagged sets.
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// IsPKCS7 reports whether the DER bytes hold a PKCS#7 SignedData content info, such as a .p7b certificate bundle.
+func IsPKCS7(der []byte) bool {
+	var info pkcs7ContentInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	return err == nil && len(rest) == 0 && info.ContentType.Equal(oidPKCS7SignedData)
+}
+
+// EncodePKCS7 creates a degenerate, certs-only PKCS#7 SignedData bundle from DER certificates and CRLs.
+func EncodePKCS7(certs, crls [][]byte) ([]byte, error) {
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPKCS7Data},
+		SignerInfos:      emptySet,
+	}
+	if len(certs) > 0 {
+		signedData.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(certs, nil)}
+	}
+	if len(crls) > 0 {
+		signedData.CRLs = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: bytes.Join(crls, nil)}
+	}
+	signedDataBytes, err := asn1.Marshal(signedData)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPKCS7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedDataBytes},
+	})
+}
+
+// DecodePKCS7 returns the DER certificates and CRLs from a PKCS#7 SignedData bundle. Any signatures are ignored.
+func DecodePKCS7(der []byte) (certs, crls [][]byte, err error) {
+	var info pkcs7ContentInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, nil, ErrNotPKCS7
+	}
+	if !info.ContentType.Equal(oidPKCS7SignedData) {
+		return nil, nil, ErrNotPKCS7
+	}
+	var signedData pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signedData); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrNotPKCS7, err)
+	}
+	certs, err = splitDer(signedData.Certificates.Bytes)
+	if err != nil && !errors.Is(err, ErrNoObjects) {
+		return nil, nil, err
+	}
+	crls, err = splitDer(signedData.CRLs.Bytes)
+	if err != nil && !errors.Is(err, ErrNoObjects) {
+		return nil, nil, err
+	}
+	return certs, crls, nil
+}
@@ -0,0 +1,81 @@
+package format
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPKCS7RoundTrip(t *testing.T) {
+	objects := testObjects(t)
+	cert, crl := objects[ObjectCertificate], objects[ObjectCRL]
+	tests := map[string]struct {
+		certs [][]byte
+		crls  [][]byte
+	}{
+		"empty":               {},
+		"certificates":        {certs: [][]byte{cert, cert}},
+		"certificate and CRL": {certs: [][]byte{cert}, crls: [][]byte{crl}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			der, err := EncodePKCS7(tc.certs, tc.crls)
+			if err != nil {
+				t.Fatalf("EncodePKCS7: %v", err)
+			}
+			if !IsPKCS7(der) || DetectDer(der) != ObjectPKCS7 {
+				t.Fatal("expected the bundle to be detected as PKCS#7")
+			}
+			certs, crls, err := DecodePKCS7(der)
+			if err != nil {
+				t.Fatalf("DecodePKCS7: %v", err)
+			}
+			if !equalDers(certs, tc.certs) || !equalDers(crls, tc.crls) {
+				t.Fatalf("expected %d certs and %d CRLs, got %d and %d", len(tc.certs), len(tc.crls), len(certs), len(crls))
+			}
+		})
+	}
+}
+
+func TestBundleAndExpandPKCS7(t *testing.T) {
+	objects := testObjects(t)
+	input := []Object{
+		{Type: ObjectCertificate, Der: objects[ObjectCertificate]},
+		{Type: ObjectCRL, Der: objects[ObjectCRL]},
+	}
+	bundle, err := BundlePKCS7(input)
+	if err != nil {
+		t.Fatalf("BundlePKCS7: %v", err)
+	}
+	expanded, err := ExpandPKCS7([]Object{bundle})
+	if err != nil {
+		t.Fatalf("ExpandPKCS7: %v", err)
+	}
+	if len(expanded) != len(input) {
+		t.Fatalf("expected %d objects, got %d", len(input), len(expanded))
+	}
+	for i, obj := range expanded {
+		if obj.Type != input[i].Type || !bytes.Equal(obj.Der, input[i].Der) {
+			t.Fatalf("expected object %d to be a %s", i, input[i].Type)
+		}
+	}
+
+	if _, err := BundlePKCS7([]Object{{Type: ObjectPKCS8PrivateKey, Der: objects[ObjectPKCS8PrivateKey]}}); err == nil {
+		t.Fatal("expected a key to be rejected from a PKCS#7 bundle")
+	}
+	if _, _, err := DecodePKCS7(objects[ObjectCertificate]); !errors.Is(err, ErrNotPKCS7) {
+		t.Fatalf("expected ErrNotPKCS7 for a certificate, got %v", err)
+	}
+}
+
+func equalDers(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
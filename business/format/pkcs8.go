@@ -0,0 +1,268 @@
+package format
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+	"hash"
+)
+
+type KDF int
+
+const (
+	KDFPBKDF2 KDF = iota + 1
+	KDFScrypt
+)
+
+const (
+	pbkdf2Iterations = 600000
+	scryptCost       = 1 << 14
+	scryptBlockSize  = 8
+	scryptParallel   = 1
+	saltLen          = 16
+	aes256KeyLen     = 32
+
+	// Limits on the KDF parameters read from a key, so a crafted key can't exhaust CPU or memory before the passphrase is checked.
+	maxPbkdf2Iterations = 10000000
+	maxScryptCost       = 1 << 20
+	maxScryptBlockSize  = 32
+	maxScryptParallel   = 16
+	maxScryptMemory     = 1 << 30
+)
+
+var (
+	ErrNotEncrypted          = errors.New("the key is not an encrypted PKCS#8 key")
+	ErrUnsupportedEncryption = errors.New("the key is encrypted with an unsupported algorithm")
+	ErrIncorrectPassphrase   = errors.New("incorrect passphrase or corrupted key")
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// RFC 5958 section 3
+type encryptedPrivateKeyInfo struct {
+	EncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedData       []byte
+}
+
+// RFC 8018 appendix A.4
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// RFC 8018 appendix A.2
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// RFC 7914 section 7.1
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// IsEncryptedPKCS8 reports whether the DER bytes hold a PBES2 encrypted PKCS#8 private key.
+func IsEncryptedPKCS8(der []byte) bool {
+	var info encryptedPrivateKeyInfo
+	rest, err := asn1.Unmarshal(der, &info)
+	if err != nil || len(rest) > 0 {
+		return false
+	}
+	return info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2)
+}
+
+// EncryptPKCS8 encrypts a DER encoded PKCS#8 private key with PBES2, using AES-256-CBC and the given key derivation function.
+func EncryptPKCS8(pkcs8Der, passphrase []byte, kdf KDF) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+
+	var (
+		key    []byte
+		kdfAlg pkix.AlgorithmIdentifier
+		err    error
+	)
+	switch kdf {
+	case KDFPBKDF2:
+		key = pbkdf2.Key(passphrase, salt, pbkdf2Iterations, aes256KeyLen, sha256.New)
+		kdfAlg, err = newAlgorithmIdentifier(oidPBKDF2, pbkdf2Params{
+			Salt:           salt,
+			IterationCount: pbkdf2Iterations,
+			KeyLength:      aes256KeyLen,
+			PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+		})
+	case KDFScrypt:
+		key, err = scrypt.Key(passphrase, salt, scryptCost, scryptBlockSize, scryptParallel, aes256KeyLen)
+		if err != nil {
+			return nil, err
+		}
+		kdfAlg, err = newAlgorithmIdentifier(oidScrypt, scryptParams{
+			Salt:                     salt,
+			CostParameter:            scryptCost,
+			BlockSize:                scryptBlockSize,
+			ParallelizationParameter: scryptParallel,
+			KeyLength:                aes256KeyLen,
+		})
+	default:
+		return nil, fmt.Errorf("unknown key derivation function %d", kdf)
+	}
+	if err != nil {
+		return nil, err
+	}
+	encAlg, err := newAlgorithmIdentifier(oidAES256CBC, iv)
+	if err != nil {
+		return nil, err
+	}
+	pbes2Alg, err := newAlgorithmIdentifier(oidPBES2, pbes2Params{
+		KeyDerivationFunc: kdfAlg,
+		EncryptionScheme:  encAlg,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padLen := aes.BlockSize - len(pkcs8Der)%aes.BlockSize
+	encrypted := append(append([]byte{}, pkcs8Der...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	return asn1.Marshal(encryptedPrivateKeyInfo{
+		EncryptionAlgorithm: pbes2Alg,
+		EncryptedData:       encrypted,
+	})
+}
+
+// DecryptPKCS8 decrypts a PBES2 encrypted PKCS#8 private key, returning the DER encoded PKCS#8 plaintext.
+func DecryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil || len(rest) > 0 {
+		return nil, ErrNotEncrypted
+	}
+	if !info.EncryptionAlgorithm.Algorithm.Equal(oidPBES2) {
+		return nil, ErrUnsupportedEncryption
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+
+	var keyLen int
+	switch {
+	case params.EncryptionScheme.Algorithm.Equal(oidAES128CBC):
+		keyLen = 16
+	case params.EncryptionScheme.Algorithm.Equal(oidAES192CBC):
+		keyLen = 24
+	case params.EncryptionScheme.Algorithm.Equal(oidAES256CBC):
+		keyLen = 32
+	default:
+		return nil, ErrUnsupportedEncryption
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid AES-CBC parameters")
+	}
+
+	key, err := deriveKey(params.KeyDerivationFunc, passphrase, keyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrIncorrectPassphrase
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	padLen := int(plain[len(plain)-1])
+	if padLen == 0 || padLen > aes.BlockSize || padLen > len(plain) {
+		return nil, ErrIncorrectPassphrase
+	}
+	for _, b := range plain[len(plain)-padLen:] {
+		if int(b) != padLen {
+			return nil, ErrIncorrectPassphrase
+		}
+	}
+	return plain[:len(plain)-padLen], nil
+}
+
+func deriveKey(kdfAlg pkix.AlgorithmIdentifier, passphrase []byte, keyLen int) ([]byte, error) {
+	switch {
+	case kdfAlg.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if _, err := asn1.Unmarshal(kdfAlg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("invalid PBKDF2 parameters: %w", err)
+		}
+		var prf func() hash.Hash
+		switch {
+		case len(params.PRF.Algorithm) == 0, params.PRF.Algorithm.Equal(oidHMACWithSHA1):
+			prf = sha1.New
+		case params.PRF.Algorithm.Equal(oidHMACWithSHA256):
+			prf = sha256.New
+		default:
+			return nil, ErrUnsupportedEncryption
+		}
+		if params.IterationCount < 1 || params.IterationCount > maxPbkdf2Iterations {
+			return nil, fmt.Errorf("%w: PBKDF2 iteration count %d is outside 1 to %d", ErrUnsupportedEncryption, params.IterationCount, maxPbkdf2Iterations)
+		}
+		return pbkdf2.Key(passphrase, params.Salt, params.IterationCount, keyLen, prf), nil
+	case kdfAlg.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if _, err := asn1.Unmarshal(kdfAlg.Parameters.FullBytes, &params); err != nil {
+			return nil, fmt.Errorf("invalid scrypt parameters: %w", err)
+		}
+		n, r, p := params.CostParameter, params.BlockSize, params.ParallelizationParameter
+		if n < 2 || n > maxScryptCost || r < 1 || r > maxScryptBlockSize || p < 1 || p > maxScryptParallel || 128*n*r > maxScryptMemory {
+			return nil, fmt.Errorf("%w: scrypt parameters N=%d r=%d p=%d exceed the supported limits", ErrUnsupportedEncryption, n, r, p)
+		}
+		return scrypt.Key(passphrase, params.Salt, n, r, p, keyLen)
+	default:
+		return nil, ErrUnsupportedEncryption
+	}
+}
+
+func newAlgorithmIdentifier(oid asn1.ObjectIdentifier, params interface{}) (pkix.AlgorithmIdentifier, error) {
+	paramBytes, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oid,
+		Parameters: asn1.RawValue{FullBytes: paramBytes},
+	}, nil
+}
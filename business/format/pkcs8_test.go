@@ -0,0 +1,124 @@
+package format
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"testing"
+)
+
+func testPKCS8Key(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestEncryptPKCS8RoundTrip(t *testing.T) {
+	plain := testPKCS8Key(t)
+	tests := map[string]struct {
+		kdf        KDF
+		passphrase []byte
+	}{
+		"pbkdf2":           {kdf: KDFPBKDF2, passphrase: []byte("correct horse")},
+		"scrypt":           {kdf: KDFScrypt, passphrase: []byte("battery staple")},
+		"empty passphrase": {kdf: KDFScrypt, passphrase: []byte{}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			encrypted, err := EncryptPKCS8(plain, tc.passphrase, tc.kdf)
+			if err != nil {
+				t.Fatalf("EncryptPKCS8: %v", err)
+			}
+			if !IsEncryptedPKCS8(encrypted) || !IsEncryptedKey(encrypted) {
+				t.Fatal("expected the output to be detected as an encrypted key")
+			}
+			if privateKeyPemType(encrypted) != "ENCRYPTED PRIVATE KEY" {
+				t.Fatal("expected an ENCRYPTED PRIVATE KEY PEM block")
+			}
+			decrypted, err := DecryptPKCS8(encrypted, tc.passphrase)
+			if err != nil {
+				t.Fatalf("DecryptPKCS8: %v", err)
+			}
+			if !bytes.Equal(decrypted, plain) {
+				t.Fatal("decrypted key doesn't match the original")
+			}
+			if _, err := DecryptPKCS8(encrypted, []byte("wrong")); !errors.Is(err, ErrIncorrectPassphrase) {
+				t.Fatalf("expected ErrIncorrectPassphrase with the wrong passphrase, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDecryptPKCS8NotEncrypted(t *testing.T) {
+	if _, err := DecryptPKCS8(testPKCS8Key(t), []byte("passphrase")); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+// withKDFParams re-encodes an encrypted key with different key derivation parameters.
+func withKDFParams(t *testing.T, encrypted []byte, kdfOid asn1.ObjectIdentifier, params interface{}) []byte {
+	t.Helper()
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(encrypted, &info); err != nil {
+		t.Fatal(err)
+	}
+	var pbes2 pbes2Params
+	if _, err := asn1.Unmarshal(info.EncryptionAlgorithm.Parameters.FullBytes, &pbes2); err != nil {
+		t.Fatal(err)
+	}
+	kdfAlg, err := newAlgorithmIdentifier(kdfOid, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbes2.KeyDerivationFunc = kdfAlg
+	info.EncryptionAlgorithm, err = newAlgorithmIdentifier(oidPBES2, pbes2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestDecryptPKCS8KDFBounds(t *testing.T) {
+	encrypted, err := EncryptPKCS8(testPKCS8Key(t), []byte("passphrase"), KDFScrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	salt := make([]byte, saltLen)
+	sha256Prf := pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}
+	tests := map[string]struct {
+		oid    asn1.ObjectIdentifier
+		params interface{}
+	}{
+		"pbkdf2 zero iterations":     {oid: oidPBKDF2, params: pbkdf2Params{Salt: salt, IterationCount: 0, PRF: sha256Prf}},
+		"pbkdf2 too many iterations": {oid: oidPBKDF2, params: pbkdf2Params{Salt: salt, IterationCount: maxPbkdf2Iterations + 1, PRF: sha256Prf}},
+		"scrypt cost too high":       {oid: oidScrypt, params: scryptParams{Salt: salt, CostParameter: maxScryptCost * 2, BlockSize: 8, ParallelizationParameter: 1}},
+		"scrypt cost too low":        {oid: oidScrypt, params: scryptParams{Salt: salt, CostParameter: 1, BlockSize: 8, ParallelizationParameter: 1}},
+		"scrypt block size too high": {oid: oidScrypt, params: scryptParams{Salt: salt, CostParameter: 1 << 14, BlockSize: maxScryptBlockSize + 1, ParallelizationParameter: 1}},
+		"scrypt parallel too high":   {oid: oidScrypt, params: scryptParams{Salt: salt, CostParameter: 1 << 14, BlockSize: 8, ParallelizationParameter: maxScryptParallel + 1}},
+		"scrypt memory too high":     {oid: oidScrypt, params: scryptParams{Salt: salt, CostParameter: maxScryptCost, BlockSize: maxScryptBlockSize, ParallelizationParameter: 1}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			crafted := withKDFParams(t, encrypted, tc.oid, tc.params)
+			if _, err := DecryptPKCS8(crafted, []byte("passphrase")); !errors.Is(err, ErrUnsupportedEncryption) {
+				t.Fatalf("expected ErrUnsupportedEncryption, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package business
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"math/big"
+)
+
+var (
+	ErrNoKeyMaterial    = errors.New("the file does not contain a certificate, public key, or private key")
+	ErrPrivateKeyNeeded = errors.New("a private JWK was requested, but the file does not contain a private key")
+)
+
+// Jwk is a JSON Web Key as described in RFC 7517, with the certificate members from RFC 7517 section 4.
+type Jwk struct {
+	Kty     string   `json:"kty"`
+	Use     string   `json:"use,omitempty"`
+	Kid     string   `json:"kid,omitempty"`
+	Alg     string   `json:"alg,omitempty"`
+	Crv     string   `json:"crv,omitempty"`
+	N       string   `json:"n,omitempty"`
+	E       string   `json:"e,omitempty"`
+	X       string   `json:"x,omitempty"`
+	Y       string   `json:"y,omitempty"`
+	D       string   `json:"d,omitempty"`
+	P       string   `json:"p,omitempty"`
+	Q       string   `json:"q,omitempty"`
+	Dp      string   `json:"dp,omitempty"`
+	Dq      string   `json:"dq,omitempty"`
+	Qi      string   `json:"qi,omitempty"`
+	X5c     []string `json:"x5c,omitempty"`
+	X5tS256 string   `json:"x5t#S256,omitempty"`
+}
+
+// Jwks is a JSON Web Key Set.
+type Jwks struct {
+	Keys []*Jwk `json:"keys"`
+}
+
+type jwkOpts struct {
+	use            string
+	kid            string
+	includePrivate bool
+	passphrase     format.PassphraseFunc
+}
+
+type JwkOpt func(opts *jwkOpts)
+
+// JwkUse sets the "use" member, which is "sig" by default. An empty use omits the member.
+func JwkUse(use string) JwkOpt {
+	return func(opts *jwkOpts) {
+		opts.use = use
+	}
+}
+
+// JwkKid overrides the "kid" member, which is the RFC 7638 SHA-256 thumbprint by default.
+func JwkKid(kid string) JwkOpt {
+	return func(opts *jwkOpts) {
+		opts.kid = kid
+	}
+}
+
+// JwkIncludePrivate outputs the private key members. Without this, only the public key is exported.
+func JwkIncludePrivate() JwkOpt {
+	return func(opts *jwkOpts) {
+		opts.includePrivate = true
+	}
+}
+
+// JwkKeyPassphrase sets the source of the passphrase used if the private key is encrypted.
+func JwkKeyPassphrase(passphrase format.PassphraseFunc) JwkOpt {
+	return func(opts *jwkOpts) {
+		opts.passphrase = passphrase
+	}
+}
+
+// NewJwkFromFile creates a JWK from a file holding certificates, a public key, or a private key, in any format the format command reads.
+// If the file has certificates, the first is used as the key's certificate and all of them are included in "x5c".
+func NewJwkFromFile(filepath string, opts ...JwkOpt) (*Jwk, error) {
+	_jwkOpts := &jwkOpts{
+		use: "sig",
+	}
+	for _, opt := range opts {
+		opt(_jwkOpts)
+	}
+
+	fileBytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	_, objects, err := format.Detect(fileBytes)
+	if err != nil {
+		return nil, err
+	}
+	objects, err = format.ExpandPKCS7(objects)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		certs   []*x509.Certificate
+		privDer []byte
+		priv    crypto.Signer
+		pub     crypto.PublicKey
+	)
+	for _, obj := range objects {
+		switch {
+		case obj.Type == format.ObjectCertificate:
+			cert, err := x509.ParseCertificate(obj.Der)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, cert)
+		case obj.Type.IsPrivateKey() && privDer == nil:
+			privDer = obj.Der
+		case obj.Type == format.ObjectPKIXPublicKey && pub == nil:
+			pub, err = x509.ParsePKIXPublicKey(obj.Der)
+			if err != nil {
+				return nil, err
+			}
+		case obj.Type == format.ObjectPKCS1PublicKey && pub == nil:
+			pub, err = x509.ParsePKCS1PublicKey(obj.Der)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The private key is only decrypted if it's needed.
+	if privDer != nil && (_jwkOpts.includePrivate || (len(certs) == 0 && pub == nil)) {
+		priv, err = decodePrivateKey(privDer, _jwkOpts.passphrase)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case len(certs) > 0:
+		pub = certs[0].PublicKey
+		if priv != nil && !publicKeysEqual(pub, priv.Public()) {
+			return nil, ErrKeyCertMismatch
+		}
+	case priv != nil:
+		pub = priv.Public()
+	case pub == nil:
+		return nil, ErrNoKeyMaterial
+	}
+	if _jwkOpts.includePrivate && priv == nil {
+		return nil, ErrPrivateKeyNeeded
+	}
+	if !_jwkOpts.includePrivate {
+		priv = nil
+	}
+
+	jwk, err := newJwk(pub, priv)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = _jwkOpts.use
+	jwk.Kid = _jwkOpts.kid
+	if jwk.Kid == "" {
+		jwk.Kid = jwk.Thumbprint()
+	}
+	if len(certs) > 0 {
+		for _, cert := range certs {
+			jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(cert.Raw))
+		}
+		leafHash := sha256.Sum256(certs[0].Raw)
+		jwk.X5tS256 = b64url(leafHash[:])
+	}
+	return jwk, nil
+}
+
+// NewJwksFromFiles creates a JWK set with one key for each file. The same options apply to every key.
+func NewJwksFromFiles(filepaths []string, opts ...JwkOpt) (*Jwks, error) {
+	jwks := &Jwks{Keys: []*Jwk{}}
+	for _, filepath := range filepaths {
+		jwk, err := NewJwkFromFile(filepath, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create JWK from '%s': %w", filepath, err)
+		}
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	return jwks, nil
+}
+
+// Thumbprint returns the RFC 7638 SHA-256 thumbprint of the public key members, base64url encoded.
+func (j *Jwk) Thumbprint() string {
+	var required string
+	switch j.Kty {
+	case "RSA":
+		required = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`, j.E, j.N)
+	case "EC":
+		required = fmt.Sprintf(`{"crv":"%s","kty":"EC","x":"%s","y":"%s"}`, j.Crv, j.X, j.Y)
+	case "OKP":
+		required = fmt.Sprintf(`{"crv":"%s","kty":"OKP","x":"%s"}`, j.Crv, j.X)
+	}
+	sum := sha256.Sum256([]byte(required))
+	return b64url(sum[:])
+}
+
+func newJwk(pub crypto.PublicKey, priv crypto.Signer) (*Jwk, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		jwk := &Jwk{
+			Kty: "RSA",
+			Alg: "RS256",
+			N:   b64url(key.N.Bytes()),
+			E:   b64url(big.NewInt(int64(key.E)).Bytes()),
+		}
+		if rsaPriv, ok := priv.(*rsa.PrivateKey); ok {
+			if len(rsaPriv.Primes) != 2 {
+				return nil, errors.New("multi-prime RSA keys are not supported")
+			}
+			rsaPriv.Precompute()
+			jwk.D = b64url(rsaPriv.D.Bytes())
+			jwk.P = b64url(rsaPriv.Primes[0].Bytes())
+			jwk.Q = b64url(rsaPriv.Primes[1].Bytes())
+			jwk.Dp = b64url(rsaPriv.Precomputed.Dp.Bytes())
+			jwk.Dq = b64url(rsaPriv.Precomputed.Dq.Bytes())
+			jwk.Qi = b64url(rsaPriv.Precomputed.Qinv.Bytes())
+		}
+		return jwk, nil
+	case *ecdsa.PublicKey:
+		params := key.Curve.Params()
+		size := (params.BitSize + 7) / 8
+		jwk := &Jwk{
+			Kty: "EC",
+			Crv: params.Name,
+			X:   b64url(key.X.FillBytes(make([]byte, size))),
+			Y:   b64url(key.Y.FillBytes(make([]byte, size))),
+		}
+		switch params.Name {
+		case "P-256":
+			jwk.Alg = "ES256"
+		case "P-384":
+			jwk.Alg = "ES384"
+		case "P-521":
+			jwk.Alg = "ES512"
+		default:
+			return nil, fmt.Errorf("unsupported curve '%s'", params.Name)
+		}
+		if ecPriv, ok := priv.(*ecdsa.PrivateKey); ok {
+			jwk.D = b64url(ecPriv.D.FillBytes(make([]byte, size)))
+		}
+		return jwk, nil
+	case ed25519.PublicKey:
+		jwk := &Jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			Alg: "EdDSA",
+			X:   b64url(key),
+		}
+		if edPriv, ok := priv.(ed25519.PrivateKey); ok {
+			jwk.D = b64url(edPriv.Seed())
+		}
+		return jwk, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func b64url(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
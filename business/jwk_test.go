@@ -0,0 +1,128 @@
+package business
+
+import (
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNewJwkFromFile(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubFile := writeTestFile(t, "ca.pub", marshalTestPublicKey(t, caCert.PublicKey))
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	_, otherKeyFile := newTestCa(t)
+
+	keyJwk, err := NewJwkFromFile(caKeyFile)
+	if err != nil {
+		t.Fatalf("NewJwkFromFile: %v", err)
+	}
+	if keyJwk.Kty != "EC" || keyJwk.Crv != "P-256" || keyJwk.Alg != "ES256" || keyJwk.Use != "sig" {
+		t.Fatalf("unexpected key members: %+v", keyJwk)
+	}
+	if keyJwk.D != "" {
+		t.Fatal("expected the private key members to be omitted by default")
+	}
+	if keyJwk.Kid != keyJwk.Thumbprint() {
+		t.Fatalf("expected the kid to be the thumbprint, got %s", keyJwk.Kid)
+	}
+
+	tests := map[string]struct {
+		file string
+		opts []JwkOpt
+		// wantErr is checked with errors.Is, and nil expects the JWK to have the same thumbprint as the key.
+		wantErr error
+		// check is run on the JWK when set.
+		check func(jwk *Jwk) bool
+	}{
+		"certificate": {file: caCertFile, check: func(jwk *Jwk) bool {
+			return len(jwk.X5c) == 1 && jwk.X5tS256 != ""
+		}},
+		"public key": {file: pubFile},
+		"private members": {file: caKeyFile, opts: []JwkOpt{JwkIncludePrivate()}, check: func(jwk *Jwk) bool {
+			return jwk.D != ""
+		}},
+		"kid and use": {file: caKeyFile, opts: []JwkOpt{JwkKid("ca"), JwkUse("")}, check: func(jwk *Jwk) bool {
+			return jwk.Kid == "ca" && jwk.Use == ""
+		}},
+		"private key needed": {file: caCertFile, opts: []JwkOpt{JwkIncludePrivate()}, wantErr: ErrPrivateKeyNeeded},
+		"no key material":    {file: csrFile, wantErr: ErrNoKeyMaterial},
+		"mismatched key": {
+			file:    writeTestFile(t, "mismatched.der", append(readTestFile(t, caCertFile), readTestFile(t, otherKeyFile)...)),
+			opts:    []JwkOpt{JwkIncludePrivate()},
+			wantErr: ErrKeyCertMismatch,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			jwk, err := NewJwkFromFile(tc.file, tc.opts...)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewJwkFromFile: %v", err)
+			}
+			if jwk.Thumbprint() != keyJwk.Thumbprint() {
+				t.Fatal("expected the same thumbprint as the key")
+			}
+			if tc.check != nil && !tc.check(jwk) {
+				t.Fatalf("unexpected JWK: %+v", jwk)
+			}
+		})
+	}
+}
+
+func TestJwkThumbprint(t *testing.T) {
+	// The example from RFC 7638 section 3.1.
+	jwk := &Jwk{
+		Kty: "RSA",
+		E:   "AQAB",
+		N: "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknj" +
+			"hMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvR" +
+			"L5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDK" +
+			"gw",
+	}
+	if got := jwk.Thumbprint(); got != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Fatalf("unexpected thumbprint %s", got)
+	}
+}
+
+func TestNewJwksFromFiles(t *testing.T) {
+	caCertFile, _ := newTestCa(t)
+	otherCertFile, _ := newTestCa(t)
+	jwks, err := NewJwksFromFiles([]string{caCertFile, otherCertFile})
+	if err != nil {
+		t.Fatalf("NewJwksFromFiles: %v", err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kid == jwks.Keys[1].Kid {
+		t.Fatalf("expected two different keys, got %d", len(jwks.Keys))
+	}
+	if _, err := NewJwksFromFiles([]string{caCertFile, "missing.pem"}); err == nil {
+		t.Fatal("expected a missing file to fail")
+	}
+}
+
+func readTestFile(t *testing.T, file string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func marshalTestPublicKey(t *testing.T, pub interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
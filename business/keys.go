@@ -0,0 +1,271 @@
+package business
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"strings"
+)
+
+type KeyType int
+
+const (
+	KeyTypeRSA KeyType = iota
+	KeyTypeECDSAP256
+	KeyTypeECDSAP384
+	KeyTypeECDSAP521
+	KeyTypeEd25519
+)
+
+var (
+	ErrUnknownKeyType       = errors.New("unknown key type")
+	ErrUnsupportedKeyFormat = errors.New("the key is not in a supported format")
+	ErrKeyEncrypted         = errors.New("the key is encrypted and no passphrase was provided")
+	ErrKeySize              = errors.New("the RSA key size is too small to generate")
+)
+
+// minGeneratedRSABits is the smallest RSA key generated, whatever key strength policy the key is later checked against.
+const minGeneratedRSABits = 2048
+
+var keyTypeNames = map[KeyType]string{
+	KeyTypeRSA:       "rsa",
+	KeyTypeECDSAP256: "ecdsa-p256",
+	KeyTypeECDSAP384: "ecdsa-p384",
+	KeyTypeECDSAP521: "ecdsa-p521",
+	KeyTypeEd25519:   "ed25519",
+}
+
+// KeyTypeNames lists the accepted names for ParseKeyType in a stable order.
+func KeyTypeNames() []string {
+	return []string{"rsa", "ecdsa-p256", "ecdsa-p384", "ecdsa-p521", "ed25519"}
+}
+
+func (k KeyType) String() string {
+	if name, ok := keyTypeNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("KeyType(%d)", int(k))
+}
+
+func ParseKeyType(name string) (KeyType, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for keyType, keyName := range keyTypeNames {
+		if keyName == name {
+			return keyType, nil
+		}
+	}
+	return 0, fmt.Errorf("%w '%s', must be one of %s", ErrUnknownKeyType, name, strings.Join(KeyTypeNames(), ", "))
+}
+
+func generateKeypair(keyType KeyType, keyBits int) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		if keyBits < minGeneratedRSABits {
+			return nil, fmt.Errorf("%w: %d bits, must be at least %d bits", ErrKeySize, keyBits, minGeneratedRSABits)
+		}
+		return generateRsaKeypair(keyBits)
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeECDSAP521:
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return priv, nil
+	default:
+		return nil, ErrUnknownKeyType
+	}
+}
+
+func generateRsaKeypair(keyBits int) (*rsa.PrivateKey, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// keyTypeOf returns the key type and RSA key size that would generate a key like the public key.
+func keyTypeOf(pub crypto.PublicKey) (KeyType, int, error) {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA, key.N.BitLen(), nil
+	case *ecdsa.PublicKey:
+		switch key.Curve {
+		case elliptic.P256():
+			return KeyTypeECDSAP256, 0, nil
+		case elliptic.P384():
+			return KeyTypeECDSAP384, 0, nil
+		case elliptic.P521():
+			return KeyTypeECDSAP521, 0, nil
+		}
+		return 0, 0, fmt.Errorf("%w: curve %s", ErrUnknownKeyType, key.Curve.Params().Name)
+	case ed25519.PublicKey:
+		return KeyTypeEd25519, 0, nil
+	default:
+		return 0, 0, fmt.Errorf("%w: %T", ErrUnknownKeyType, pub)
+	}
+}
+
+// subjectKeyId derives a key identifier from the SHA-1 hash of the public key, as described in RFC 5280 section 4.2.1.2.
+func subjectKeyId(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, err
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, err
+	}
+	keyId := sha1.Sum(spki.PublicKey.Bytes)
+	return keyId[:], nil
+}
+
+// marshalPrivateKey encodes RSA keys as PKCS#1 and EC keys as SEC1 to stay compatible with existing tooling.
+// Ed25519 keys have no legacy encoding, so PKCS#8 is used.
+func marshalPrivateKey(priv crypto.Signer) ([]byte, error) {
+	switch key := priv.(type) {
+	case *rsa.PrivateKey:
+		return x509.MarshalPKCS1PrivateKey(key), nil
+	case *ecdsa.PrivateKey:
+		return x509.MarshalECPrivateKey(key)
+	case ed25519.PrivateKey:
+		return x509.MarshalPKCS8PrivateKey(key)
+	default:
+		return nil, ErrUnknownKeyType
+	}
+}
+
+// encodePrivateKey marshals the key with marshalPrivateKey, or as an encrypted PKCS#8 key if a passphrase is given.
+func encodePrivateKey(priv crypto.Signer, passphrase format.PassphraseFunc, kdf format.KDF) ([]byte, error) {
+	if passphrase == nil {
+		return marshalPrivateKey(priv)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return format.EncryptPKCS8(pkcs8, pass, kdf)
+}
+
+// LoadPrivateKeyFromFile reads a PEM or DER encoded private key, decrypting it with the passphrase if needed.
+func LoadPrivateKeyFromFile(filepath string, passphrase format.PassphraseFunc) (crypto.Signer, error) {
+	fileBytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, err
+	}
+	for _, block := range decodePem(fileBytes) {
+		if !format.IsEncryptedKey(block) {
+			if _, err := parsePrivateKey(block); err != nil {
+				continue
+			}
+		}
+		return decodePrivateKey(block, passphrase)
+	}
+	return nil, ErrUnsupportedKeyFormat
+}
+
+// decodePrivateKey parses a private key with parsePrivateKey, decrypting it first if it's an encrypted PKCS#8 or OpenSSH key.
+func decodePrivateKey(der []byte, passphrase format.PassphraseFunc) (crypto.Signer, error) {
+	if !format.IsEncryptedKey(der) {
+		return parsePrivateKey(der)
+	}
+	if passphrase == nil {
+		return nil, ErrKeyEncrypted
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if format.IsEncryptedOpenSSH(der) {
+		return format.ParseOpenSSHPrivateKey(der, pass)
+	}
+	plain, err := format.DecryptPKCS8(der, pass)
+	if err != nil {
+		return nil, err
+	}
+	return parsePrivateKey(plain)
+}
+
+// parsePrivateKey accepts a DER encoded PKCS#1, PKCS#8, or SEC1 private key, or an OpenSSH private key.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	key, err := format.ParsePrivateKey(der)
+	if err != nil {
+		return nil, ErrUnsupportedKeyFormat
+	}
+	return key, nil
+}
+
+var (
+	ErrWeakKey = errors.New("key does not meet the minimum strength policy")
+)
+
+// WeakKeyError describes a public key that was rejected by a KeyStrengthPolicy.
+// It matches ErrWeakKey with errors.Is.
+type WeakKeyError struct {
+	Algorithm string
+	Bits      int
+	MinBits   int
+}
+
+func (e *WeakKeyError) Error() string {
+	if e.MinBits == 0 {
+		return fmt.Sprintf("%s keys are not allowed", e.Algorithm)
+	}
+	return fmt.Sprintf("%s key size of %d bits is below the minimum of %d bits", e.Algorithm, e.Bits, e.MinBits)
+}
+
+func (e *WeakKeyError) Is(target error) bool {
+	return target == ErrWeakKey
+}
+
+// KeyStrengthPolicy sets the minimum acceptable size of public keys. Ed25519 keys have a fixed size and are always accepted.
+type KeyStrengthPolicy struct {
+	MinRSABits   int
+	MinECDSABits int
+}
+
+var DefaultKeyStrengthPolicy = KeyStrengthPolicy{
+	MinRSABits:   2048,
+	MinECDSABits: 256,
+}
+
+// Check returns a *WeakKeyError if the public key is weaker than the policy allows, or of an unsupported algorithm.
+func (p KeyStrengthPolicy) Check(pub crypto.PublicKey) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if bits := key.N.BitLen(); bits < p.MinRSABits {
+			return &WeakKeyError{Algorithm: "RSA", Bits: bits, MinBits: p.MinRSABits}
+		}
+	case *ecdsa.PublicKey:
+		if bits := key.Curve.Params().BitSize; bits < p.MinECDSABits {
+			return &WeakKeyError{Algorithm: "ECDSA", Bits: bits, MinBits: p.MinECDSABits}
+		}
+	case ed25519.PublicKey:
+	default:
+		return &WeakKeyError{Algorithm: fmt.Sprintf("%T", pub)}
+	}
+	return nil
+}
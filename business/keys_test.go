@@ -0,0 +1,199 @@
+package business
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestFile writes the data to a file in a temporary directory, and returns its path.
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func testPassphrase() ([]byte, error) {
+	return []byte("test passphrase"), nil
+}
+
+// newTestCa creates a self-signed CA, with an ECDSA key unless the options choose another, and writes its DER
+// certificate and key to files.
+func newTestCa(t *testing.T, opts ...CaCertOpt) (certFile, keyFile string) {
+	t.Helper()
+	opts = append([]CaCertOpt{CaKeyType(KeyTypeECDSAP256)}, opts...)
+	cert, key, err := NewCaCert("Test CA", pkix.Name{Organization: []string{"Test"}}, opts...)
+	if err != nil {
+		t.Fatalf("NewCaCert: %v", err)
+	}
+	return writeTestFile(t, "ca.cer", cert), writeTestFile(t, "ca.key", key)
+}
+
+// newTestCsr generates a CSR for the DNS name, with an ECDSA key unless the options choose another, and writes the
+// DER CSR and key to files.
+func newTestCsr(t *testing.T, dnsName string, opts ...CsrOpt) (csrFile, keyFile string) {
+	t.Helper()
+	opts = append([]CsrOpt{CsrKeyType(KeyTypeECDSAP256), CsrAddSan(dnsName)}, opts...)
+	csr, key, err := NewGeneratedCsr(dnsName, pkix.Name{}, opts...)
+	if err != nil {
+		t.Fatalf("NewGeneratedCsr: %v", err)
+	}
+	return writeTestFile(t, dnsName+".csr", csr), writeTestFile(t, dnsName+".key", key)
+}
+
+// signTestCsr signs the CSR as a server certificate.
+func signTestCsr(t *testing.T, csrFile, caCertFile, caKeyFile string, opts ...SignOpt) (*x509.Certificate, error) {
+	t.Helper()
+	der, _, err := SignCsr(csrFile, caCertFile, caKeyFile, nil, opts...)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, nil
+}
+
+func TestParseKeyType(t *testing.T) {
+	for _, name := range KeyTypeNames() {
+		keyType, err := ParseKeyType(" " + name + " ")
+		if err != nil {
+			t.Fatalf("ParseKeyType(%s): %v", name, err)
+		}
+		if keyType.String() != name {
+			t.Errorf("expected %s, got %s", name, keyType)
+		}
+	}
+	if _, err := ParseKeyType("dsa"); !errors.Is(err, ErrUnknownKeyType) {
+		t.Fatalf("expected ErrUnknownKeyType, got %v", err)
+	}
+}
+
+func TestSignKeyTypes(t *testing.T) {
+	tests := map[string]struct {
+		keyType KeyType
+		keyBits int
+		// wantKey checks the type and size of the issued certificate's public key.
+		wantKey func(pub interface{}) bool
+	}{
+		"rsa": {keyType: KeyTypeRSA, keyBits: 2048, wantKey: func(pub interface{}) bool {
+			key, ok := pub.(*rsa.PublicKey)
+			return ok && key.N.BitLen() == 2048
+		}},
+		"ecdsa p256": {keyType: KeyTypeECDSAP256, wantKey: func(pub interface{}) bool {
+			key, ok := pub.(*ecdsa.PublicKey)
+			return ok && key.Curve.Params().BitSize == 256
+		}},
+		"ecdsa p384": {keyType: KeyTypeECDSAP384, wantKey: func(pub interface{}) bool {
+			key, ok := pub.(*ecdsa.PublicKey)
+			return ok && key.Curve.Params().BitSize == 384
+		}},
+		"ecdsa p521": {keyType: KeyTypeECDSAP521, wantKey: func(pub interface{}) bool {
+			key, ok := pub.(*ecdsa.PublicKey)
+			return ok && key.Curve.Params().BitSize == 521
+		}},
+		"ed25519": {keyType: KeyTypeEd25519, wantKey: func(pub interface{}) bool {
+			_, ok := pub.(ed25519.PublicKey)
+			return ok
+		}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			// The CA uses the same key type, so each type is also tested as an issuer.
+			caCertFile, caKeyFile := newTestCa(t, CaKeyType(tc.keyType), CaKeyBits(tc.keyBits))
+			csrFile, keyFile := newTestCsr(t, "www.example.com", CsrKeyType(tc.keyType), CsrKeyBits(tc.keyBits))
+			cert, err := signTestCsr(t, csrFile, caCertFile, caKeyFile)
+			if err != nil {
+				t.Fatalf("SignCsr: %v", err)
+			}
+			if !tc.wantKey(cert.PublicKey) {
+				t.Fatalf("unexpected public key %T", cert.PublicKey)
+			}
+			caCert, err := LoadCertFromFile(caCertFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cert.CheckSignatureFrom(caCert); err != nil {
+				t.Fatalf("certificate signature: %v", err)
+			}
+			keyDer, err := ioutil.ReadFile(keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := parsePrivateKey(keyDer)
+			if err != nil {
+				t.Fatalf("parsePrivateKey: %v", err)
+			}
+			if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+				t.Fatal("the key doesn't match the certificate")
+			}
+		})
+	}
+}
+
+func TestKeyStrengthPolicyCheck(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		policy KeyStrengthPolicy
+		pub    crypto.PublicKey
+		weak   bool
+	}{
+		"rsa below default":       {policy: DefaultKeyStrengthPolicy, pub: &rsaKey.PublicKey, weak: true},
+		"rsa with lower minimum":  {policy: KeyStrengthPolicy{MinRSABits: 1024}, pub: &rsaKey.PublicKey},
+		"ecdsa meets default":     {policy: DefaultKeyStrengthPolicy, pub: &p256Key.PublicKey},
+		"ecdsa below minimum":     {policy: KeyStrengthPolicy{MinECDSABits: 384}, pub: &p256Key.PublicKey, weak: true},
+		"ed25519 always accepted": {policy: KeyStrengthPolicy{MinRSABits: 4096, MinECDSABits: 521}, pub: ed25519Key},
+		"unsupported algorithm":   {policy: DefaultKeyStrengthPolicy, pub: "not a key", weak: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.policy.Check(tc.pub)
+			if tc.weak != errors.Is(err, ErrWeakKey) {
+				t.Fatalf("expected weak to be %v, got %v", tc.weak, err)
+			}
+			if tc.weak {
+				var weakErr *WeakKeyError
+				if !errors.As(err, &weakErr) {
+					t.Fatalf("expected a *WeakKeyError, got %T", err)
+				}
+			}
+		})
+	}
+}
+
+func TestSignKeyPolicy(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	if _, err := signTestCsr(t, csrFile, caCertFile, caKeyFile); err != nil {
+		t.Fatalf("expected the default policy to accept a P-256 key: %v", err)
+	}
+	_, err := signTestCsr(t, csrFile, caCertFile, caKeyFile, SignKeyPolicy(KeyStrengthPolicy{MinECDSABits: 384}))
+	if !errors.Is(err, ErrWeakKey) {
+		t.Fatalf("expected ErrWeakKey, got %v", err)
+	}
+}
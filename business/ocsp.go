@@ -0,0 +1,283 @@
+package business
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ocspRequestContentType  = "application/ocsp-request"
+	ocspResponseContentType = "application/ocsp-response"
+	maxOcspRequestSize      = 10 * 1024
+)
+
+var (
+	ErrNotOcspSigner = errors.New("the delegated signer certificate must be issued by the CA and have the OCSPSigning extended key usage")
+)
+
+type ocspOpts struct {
+	signerCertFile          string
+	signerKeyFile           string
+	keyPassphrase           format.PassphraseFunc
+	previousCaKeyPassphrase format.PassphraseFunc
+	validity                time.Duration
+}
+
+type OcspOpt func(opts *ocspOpts)
+
+// OcspDelegatedSigner signs responses with an OCSP signing certificate issued by the CA, instead of the CA key.
+func OcspDelegatedSigner(certFile, keyFile string) OcspOpt {
+	return func(opts *ocspOpts) {
+		opts.signerCertFile = certFile
+		opts.signerKeyFile = keyFile
+	}
+}
+
+// OcspKeyPassphrase sets the source of the passphrase used if the signing key is encrypted.
+func OcspKeyPassphrase(passphrase format.PassphraseFunc) OcspOpt {
+	return func(opts *ocspOpts) {
+		opts.keyPassphrase = passphrase
+	}
+}
+
+// OcspPreviousCaKeyPassphrase sets the source of the passphrase used if the keys of roots replaced with Rollover are encrypted.
+// By default, the signing key's passphrase is used.
+func OcspPreviousCaKeyPassphrase(passphrase format.PassphraseFunc) OcspOpt {
+	return func(opts *ocspOpts) {
+		opts.previousCaKeyPassphrase = passphrase
+	}
+}
+
+// OcspResponseValidity sets how long responses may be cached, which sets their nextUpdate field. Default is 1 hour.
+func OcspResponseValidity(validity time.Duration) OcspOpt {
+	return func(opts *ocspOpts) {
+		opts.validity = validity
+	}
+}
+
+// OcspResponder is an RFC 6960 OCSP responder for the certificates issued by a CA directory.
+// Revocation state is read from the CA index for each request, so revocations take effect immediately.
+type OcspResponder struct {
+	dir      *CaDir
+	roots    []*caRoot
+	issuers  []*ocspIssuer
+	validity time.Duration
+}
+
+// ocspIssuer is a root that the responder answers for, and the certificate and key that sign its responses.
+type ocspIssuer struct {
+	root       *caRoot
+	signerCert *x509.Certificate
+	signer     crypto.Signer
+}
+
+// NewOcspResponder loads the CA and signing key. The signing key is loaded once, so a passphrase is only needed at startup.
+// Certificates issued by roots replaced with Rollover are answered for until the old root expires, signed with its archived key.
+func NewOcspResponder(dir *CaDir, opts ...OcspOpt) (*OcspResponder, error) {
+	_ocspOpts := &ocspOpts{
+		keyPassphrase: format.PassphrasePrompt("OCSP signing key passphrase", false),
+		validity:      time.Hour,
+	}
+	for _, opt := range opts {
+		opt(_ocspOpts)
+	}
+	keyPassphrase := cachedPassphrase(_ocspOpts.keyPassphrase)
+	previousPassphrase := keyPassphrase
+	if _ocspOpts.previousCaKeyPassphrase != nil {
+		previousPassphrase = _ocspOpts.previousCaKeyPassphrase
+	}
+
+	roots, err := dir.roots()
+	if err != nil {
+		return nil, err
+	}
+	current, err := loadOcspSigner(dir, roots[0], _ocspOpts.signerCertFile, _ocspOpts.signerKeyFile, keyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	responder := &OcspResponder{
+		dir:      dir,
+		roots:    roots,
+		issuers:  []*ocspIssuer{current},
+		validity: _ocspOpts.validity,
+	}
+	now := time.Now()
+	for _, root := range roots[1:] {
+		if now.After(root.cert.NotAfter) {
+			continue
+		}
+		rootKey, err := LoadPrivateKeyFromFile(root.rollover.OldKeyFile(), previousPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load previous CA key for root %s: %w", root.serial, err)
+		}
+		responder.issuers = append(responder.issuers, &ocspIssuer{root: root, signerCert: root.cert, signer: rootKey})
+	}
+	return responder, nil
+}
+
+// loadOcspSigner loads the current root's signer, which is the CA key unless a delegated signer certificate is given.
+func loadOcspSigner(dir *CaDir, root *caRoot, signerCertFile, signerKeyFile string, passphrase format.PassphraseFunc) (*ocspIssuer, error) {
+	if signerCertFile == "" {
+		caKey, err := LoadPrivateKeyFromFile(dir.KeyFile(), passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA key: %w", err)
+		}
+		return &ocspIssuer{root: root, signerCert: root.cert, signer: caKey}, nil
+	}
+
+	// The CA key isn't needed with a delegated signer.
+	caCert := root.cert
+	signerCert, err := LoadCertFromFile(signerCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCSP signer certificate '%s': %w", signerCertFile, err)
+	}
+	if err := signerCert.CheckSignatureFrom(caCert); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotOcspSigner, err)
+	}
+	if !hasExtKeyUsage(signerCert, x509.ExtKeyUsageOCSPSigning) {
+		return nil, ErrNotOcspSigner
+	}
+	signerKey, err := LoadPrivateKeyFromFile(signerKeyFile, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OCSP signer key '%s': %w", signerKeyFile, err)
+	}
+	if !publicKeysEqual(signerCert.PublicKey, signerKey.Public()) {
+		return nil, ErrKeyCertMismatch
+	}
+	return &ocspIssuer{root: root, signerCert: signerCert, signer: signerKey}, nil
+}
+
+// ServeHTTP answers OCSP requests sent with POST, or with GET and the base64 request in the URL path as in RFC 6960 appendix A.
+func (r *OcspResponder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var reqDer []byte
+	switch req.Method {
+	case http.MethodGet:
+		encoded, err := url.PathUnescape(strings.TrimPrefix(req.URL.EscapedPath(), "/"))
+		if err == nil {
+			reqDer, err = base64.StdEncoding.DecodeString(encoded)
+		}
+		if err != nil {
+			r.writeResponse(w, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+	case http.MethodPost:
+		if contentType := req.Header.Get("Content-Type"); contentType != "" && contentType != ocspRequestContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxOcspRequestSize+1))
+		if err != nil || len(body) > maxOcspRequestSize {
+			r.writeResponse(w, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+		reqDer = body
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	resp, err := r.Respond(reqDer)
+	if err != nil {
+		resp = ocsp.InternalErrorErrorResponse
+	}
+	if req.Method == http.MethodGet && !bytes.Equal(resp, ocsp.MalformedRequestErrorResponse) {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d, public", int(r.validity.Seconds())))
+	}
+	r.writeResponse(w, resp)
+}
+
+// Respond creates the DER OCSP response for a DER OCSP request. Malformed requests and requests for another CA
+// get the matching OCSP error response rather than an error. Certificates are only reported as good or revoked
+// to requests naming the root that issued them.
+func (r *OcspResponder) Respond(reqDer []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(reqDer)
+	if err != nil {
+		return ocsp.MalformedRequestErrorResponse, nil
+	}
+	issuer := r.issuerOf(req)
+	if issuer == nil {
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	template := ocsp.Response{
+		Status:       ocsp.Unknown,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(r.validity),
+		IssuerHash:   req.HashAlgorithm,
+	}
+	if issuer.signerCert != issuer.root.cert {
+		template.Certificate = issuer.signerCert
+	}
+	entry, err := r.dir.Lookup(req.SerialNumber.Text(16))
+	switch {
+	case errors.Is(err, ErrSerialNotFound):
+	case err != nil:
+		return nil, err
+	case r.dir.entryIssuer(entry, r.roots) != issuer.root.serial:
+		// Another root issued the certificate, so it's unknown to this one.
+	case entry.Status == CertStatusRevoked:
+		template.Status = ocsp.Revoked
+		template.RevokedAt = *entry.RevokedAt
+		template.RevocationReason = int(entry.RevocationReason)
+	default:
+		template.Status = ocsp.Good
+	}
+	return ocsp.CreateResponse(issuer.root.cert, issuer.signerCert, template, issuer.signer)
+}
+
+// issuerOf returns the root named by the request's issuer hashes, or nil if the responder doesn't answer for it.
+func (r *OcspResponder) issuerOf(req *ocsp.Request) *ocspIssuer {
+	for _, issuer := range r.issuers {
+		if isOcspIssuer(req, issuer.root.cert) {
+			return issuer
+		}
+	}
+	return nil
+}
+
+func isOcspIssuer(req *ocsp.Request, caCert *x509.Certificate) bool {
+	if !req.HashAlgorithm.Available() {
+		return false
+	}
+	var spki struct {
+		Algorithm asn1.RawValue
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(caCert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return false
+	}
+	nameHash := req.HashAlgorithm.New()
+	nameHash.Write(caCert.RawSubject)
+	keyHash := req.HashAlgorithm.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+	return bytes.Equal(nameHash.Sum(nil), req.IssuerNameHash) && bytes.Equal(keyHash.Sum(nil), req.IssuerKeyHash)
+}
+
+func (r *OcspResponder) writeResponse(w http.ResponseWriter, resp []byte) {
+	w.Header().Set("Content-Type", ocspResponseContentType)
+	w.Write(resp)
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, certUsage := range cert.ExtKeyUsage {
+		if certUsage == usage {
+			return true
+		}
+	}
+	return false
+}
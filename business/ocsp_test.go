@@ -0,0 +1,157 @@
+package business
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOcspRespond(t *testing.T) {
+	dir := newTestCaDir(t)
+	caCert, err := LoadCertFromFile(dir.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	good := issueTestCert(t, dir, "good.example.com")
+	revoked := issueTestCert(t, dir, "revoked.example.com")
+	revokedAt := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	if _, err := dir.Revoke(revoked.SerialNumber.Text(16), ReasonKeyCompromise, revokedAt); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	unknown := &x509.Certificate{SerialNumber: big.NewInt(42)}
+
+	responder, err := NewOcspResponder(dir, OcspKeyPassphrase(testPassphrase), OcspResponseValidity(2*time.Hour))
+	if err != nil {
+		t.Fatalf("NewOcspResponder: %v", err)
+	}
+	tests := map[string]struct {
+		cert       *x509.Certificate
+		wantStatus int
+	}{
+		"good":    {cert: good, wantStatus: ocsp.Good},
+		"revoked": {cert: revoked, wantStatus: ocsp.Revoked},
+		"unknown": {cert: unknown, wantStatus: ocsp.Unknown},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			reqDer, err := ocsp.CreateRequest(tc.cert, caCert, &ocsp.RequestOptions{Hash: crypto.SHA256})
+			if err != nil {
+				t.Fatal(err)
+			}
+			respDer, err := responder.Respond(reqDer)
+			if err != nil {
+				t.Fatalf("Respond: %v", err)
+			}
+			resp, err := ocsp.ParseResponseForCert(respDer, tc.cert, caCert)
+			if err != nil {
+				t.Fatalf("failed to parse the response: %v", err)
+			}
+			if resp.Status != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d", tc.wantStatus, resp.Status)
+			}
+			if resp.NextUpdate.Sub(resp.ThisUpdate) != 2*time.Hour {
+				t.Fatalf("expected responses to be valid for 2 hours, got %s", resp.NextUpdate.Sub(resp.ThisUpdate))
+			}
+			if tc.wantStatus == ocsp.Revoked {
+				if !resp.RevokedAt.Equal(revokedAt) || resp.RevocationReason != ocsp.KeyCompromise {
+					t.Fatalf("unexpected revocation at %s for reason %d", resp.RevokedAt, resp.RevocationReason)
+				}
+			}
+		})
+	}
+
+	t.Run("other issuer", func(t *testing.T) {
+		otherCertFile, _ := newTestCa(t)
+		otherCa, err := LoadCertFromFile(otherCertFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqDer, err := ocsp.CreateRequest(good, otherCa, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		respDer, err := responder.Respond(reqDer)
+		if err != nil || !bytes.Equal(respDer, ocsp.UnauthorizedErrorResponse) {
+			t.Fatalf("expected an unauthorized response, got %v", err)
+		}
+	})
+	t.Run("malformed", func(t *testing.T) {
+		respDer, err := responder.Respond([]byte("not a request"))
+		if err != nil || !bytes.Equal(respDer, ocsp.MalformedRequestErrorResponse) {
+			t.Fatalf("expected a malformed request response, got %v", err)
+		}
+	})
+}
+
+func TestOcspServeHTTP(t *testing.T) {
+	dir := newTestCaDir(t)
+	caCert, err := LoadCertFromFile(dir.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := issueTestCert(t, dir, "www.example.com")
+	responder, err := NewOcspResponder(dir, OcspKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("NewOcspResponder: %v", err)
+	}
+	server := httptest.NewServer(responder)
+	defer server.Close()
+	reqDer, err := ocsp.CreateRequest(cert, caCert, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() (*http.Response, error) {
+		return http.Get(server.URL + "/" + base64.StdEncoding.EncodeToString(reqDer))
+	}
+	post := func() (*http.Response, error) {
+		return http.Post(server.URL, "application/ocsp-request", bytes.NewReader(reqDer))
+	}
+	for name, send := range map[string]func() (*http.Response, error){"GET": get, "POST": post} {
+		t.Run(name, func(t *testing.T) {
+			httpResp, err := send()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer httpResp.Body.Close()
+			if httpResp.Header.Get("Content-Type") != "application/ocsp-response" {
+				t.Fatalf("unexpected content type '%s'", httpResp.Header.Get("Content-Type"))
+			}
+			body, err := ioutil.ReadAll(httpResp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := ocsp.ParseResponseForCert(body, cert, caCert)
+			if err != nil {
+				t.Fatalf("failed to parse the response: %v", err)
+			}
+			if resp.Status != ocsp.Good {
+				t.Fatalf("expected a good status, got %d", resp.Status)
+			}
+		})
+	}
+}
+
+func TestOcspDelegatedSigner(t *testing.T) {
+	dir := newTestCaDir(t)
+	csrFile, keyFile := newTestCsr(t, "www.example.com")
+	der, _, err := SignCsr(csrFile, dir.CertFile(), dir.KeyFile(), nil, SignCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	certFile := writeTestFile(t, "server.cer", der)
+
+	_, err = NewOcspResponder(dir, OcspDelegatedSigner(certFile, keyFile), OcspKeyPassphrase(testPassphrase))
+	if !errors.Is(err, ErrNotOcspSigner) {
+		t.Fatalf("expected ErrNotOcspSigner for a server certificate, got %v", err)
+	}
+}
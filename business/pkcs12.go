@@ -0,0 +1,120 @@
+package business
+
+import (
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+var (
+	ErrKeyCertMismatch = errors.New("the private key does not match the certificate")
+)
+
+type pkcs12Opts struct {
+	chainFiles    []string
+	keyPassphrase format.PassphraseFunc
+	legacy        bool
+}
+
+type Pkcs12Opt func(opts *pkcs12Opts)
+
+// Pkcs12AddChain includes every certificate in the file as part of the bundle's CA chain.
+func Pkcs12AddChain(chainFile string) Pkcs12Opt {
+	return func(opts *pkcs12Opts) {
+		opts.chainFiles = append(opts.chainFiles, chainFile)
+	}
+}
+
+// Pkcs12KeyPassphrase sets the source of the passphrase used if the private key is encrypted.
+func Pkcs12KeyPassphrase(passphrase format.PassphraseFunc) Pkcs12Opt {
+	return func(opts *pkcs12Opts) {
+		opts.keyPassphrase = passphrase
+	}
+}
+
+// Pkcs12Legacy encrypts the bundle with RC2 and 3DES for clients that don't support AES, such as older Java runtimes.
+func Pkcs12Legacy() Pkcs12Opt {
+	return func(opts *pkcs12Opts) {
+		opts.legacy = true
+	}
+}
+
+// NewPkcs12Bundle packs a certificate, its private key, and an optional chain into a password protected PKCS#12 file.
+func NewPkcs12Bundle(certFile, keyFile, password string, opts ...Pkcs12Opt) ([]byte, error) {
+	_pkcs12Opts := &pkcs12Opts{}
+	for _, opt := range opts {
+		opt(_pkcs12Opts)
+	}
+
+	cert, err := LoadCertFromFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate '%s': %w", certFile, err)
+	}
+	key, err := LoadPrivateKeyFromFile(keyFile, _pkcs12Opts.keyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load private key '%s': %w", keyFile, err)
+	}
+	if !publicKeysEqual(cert.PublicKey, key.Public()) {
+		return nil, ErrKeyCertMismatch
+	}
+
+	var chain []*x509.Certificate
+	for _, chainFile := range _pkcs12Opts.chainFiles {
+		certs, err := LoadCertsFromFile(chainFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chain '%s': %w", chainFile, err)
+		}
+		chain = append(chain, certs...)
+	}
+
+	encoder := pkcs12.Modern
+	if _pkcs12Opts.legacy {
+		encoder = pkcs12.LegacyRC2
+	}
+	return encoder.Encode(key, cert, chain, password)
+}
+
+// Pkcs12Contents holds the DER encoded contents of a PKCS#12 bundle.
+type Pkcs12Contents struct {
+	Cert  []byte
+	Key   []byte
+	Chain [][]byte
+}
+
+// ReadPkcs12Bundle unpacks a PKCS#12 file. The key is encoded with the same rules used for generated keys, encrypted if a passphrase is given.
+func ReadPkcs12Bundle(p12File, password string, keyPassphrase format.PassphraseFunc, kdf format.KDF) (*Pkcs12Contents, error) {
+	p12Bytes, err := ioutil.ReadFile(p12File)
+	if err != nil {
+		return nil, err
+	}
+	priv, cert, chain, err := pkcs12.DecodeChain(p12Bytes, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode PKCS#12 file '%s': %w", p12File, err)
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyFormat
+	}
+	key, err := encodePrivateKey(signer, keyPassphrase, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &Pkcs12Contents{
+		Cert: cert.Raw,
+		Key:  key,
+	}
+	for _, chainCert := range chain {
+		contents.Chain = append(contents.Chain, chainCert.Raw)
+	}
+	return contents, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
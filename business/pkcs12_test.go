@@ -0,0 +1,84 @@
+package business
+
+import (
+	"bytes"
+	"errors"
+	"github.com/drognisep/certserver/business/format"
+	"testing"
+)
+
+func TestPkcs12RoundTrip(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrFile, keyFile := newTestCsr(t, "www.example.com")
+	cert, err := signTestCsr(t, csrFile, caCertFile, caKeyFile)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	certFile := writeTestFile(t, "www.example.com.cer", cert.Raw)
+	key, err := LoadPrivateKeyFromFile(keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		opts []Pkcs12Opt
+		// keyPassphrase encrypts the imported key, if set.
+		keyPassphrase format.PassphraseFunc
+		wantChain     int
+	}{
+		"modern":            {},
+		"legacy":            {opts: []Pkcs12Opt{Pkcs12Legacy()}},
+		"with chain":        {opts: []Pkcs12Opt{Pkcs12AddChain(caCertFile)}, wantChain: 1},
+		"encrypted on read": {keyPassphrase: testPassphrase},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			p12, err := NewPkcs12Bundle(certFile, keyFile, "bundle password", tc.opts...)
+			if err != nil {
+				t.Fatalf("NewPkcs12Bundle: %v", err)
+			}
+			p12File := writeTestFile(t, "bundle.p12", p12)
+			if _, err := ReadPkcs12Bundle(p12File, "wrong password", nil, format.KDFPBKDF2); err == nil {
+				t.Fatal("expected the wrong password to fail")
+			}
+			contents, err := ReadPkcs12Bundle(p12File, "bundle password", tc.keyPassphrase, format.KDFPBKDF2)
+			if err != nil {
+				t.Fatalf("ReadPkcs12Bundle: %v", err)
+			}
+
+			if !bytes.Equal(contents.Cert, cert.Raw) {
+				t.Error("expected the certificate to round trip")
+			}
+			if len(contents.Chain) != tc.wantChain || (tc.wantChain > 0 && !bytes.Equal(contents.Chain[0], caCert.Raw)) {
+				t.Errorf("expected a chain of %d certificates, got %d", tc.wantChain, len(contents.Chain))
+			}
+			if format.IsEncryptedPKCS8(contents.Key) != (tc.keyPassphrase != nil) {
+				t.Errorf("expected the key to be encrypted: %v", tc.keyPassphrase != nil)
+			}
+			readKey, err := LoadPrivateKeyFromFile(writeTestFile(t, "read.key", contents.Key), tc.keyPassphrase)
+			if err != nil {
+				t.Fatalf("failed to load the key from the bundle: %v", err)
+			}
+			if !publicKeysEqual(readKey.Public(), key.Public()) {
+				t.Error("expected the key to round trip")
+			}
+		})
+	}
+}
+
+func TestPkcs12KeyMismatch(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	csrFile, _ := newTestCsr(t, "www.example.com")
+	cert, err := signTestCsr(t, csrFile, caCertFile, caKeyFile)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	_, err = NewPkcs12Bundle(writeTestFile(t, "www.example.com.cer", cert.Raw), caKeyFile, "bundle password")
+	if !errors.Is(err, ErrKeyCertMismatch) {
+		t.Fatalf("expected ErrKeyCertMismatch, got %v", err)
+	}
+}
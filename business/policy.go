@@ -0,0 +1,276 @@
+package business
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	WildcardsAllow = "allow"
+	WildcardsDeny  = "deny"
+)
+
+var (
+	ErrPolicyViolation = errors.New("the CSR violates the issuance policy")
+	ErrInvalidPolicy   = errors.New("invalid issuance policy")
+)
+
+// subjectFields maps the names used in RequiredSubjectFields to the CSR subject's values.
+var subjectFields = map[string]func(name pkix.Name) []string{
+	"CN":         func(name pkix.Name) []string { return nonEmpty(name.CommonName) },
+	"C":          func(name pkix.Name) []string { return name.Country },
+	"O":          func(name pkix.Name) []string { return name.Organization },
+	"OU":         func(name pkix.Name) []string { return name.OrganizationalUnit },
+	"L":          func(name pkix.Name) []string { return name.Locality },
+	"ST":         func(name pkix.Name) []string { return name.Province },
+	"STREET":     func(name pkix.Name) []string { return name.StreetAddress },
+	"POSTALCODE": func(name pkix.Name) []string { return name.PostalCode },
+}
+
+// IssuancePolicy limits the CSRs that a CA will sign. The zero value allows any CSR.
+// It may be stored in a CA directory's config, or loaded from a JSON file of the same shape.
+type IssuancePolicy struct {
+	// AllowedDNSSuffixes lists the domains that DNS names, URI hosts, and email domains must be in. Any domain is allowed if it's empty.
+	AllowedDNSSuffixes []string `json:"allowedDnsSuffixes,omitempty"`
+	// DeniedDNSSuffixes lists domains that DNS names, URI hosts, and email domains must not be in. It takes precedence over AllowedDNSSuffixes.
+	DeniedDNSSuffixes []string `json:"deniedDnsSuffixes,omitempty"`
+	// Wildcards is 'allow' or 'deny'. Allowed wildcards must be the whole leftmost label, as in '*.example.com'.
+	Wildcards string `json:"wildcards,omitempty"`
+	// PermittedCIDRs lists the IP ranges that IP SANs and IP URI hosts must be in. Any IP is allowed if it's empty.
+	PermittedCIDRs []string `json:"permittedCidrs,omitempty"`
+	// CommonNamePattern is a regular expression that must match the whole common name.
+	CommonNamePattern string `json:"commonNamePattern,omitempty"`
+	// RequiredSubjectFields lists subject fields that must be set, from CN, C, O, OU, L, ST, STREET, and POSTALCODE.
+	RequiredSubjectFields []string `json:"requiredSubjectFields,omitempty"`
+	// ForcedOrganization and ForcedOrganizationalUnit replace the CSR's O and OU values.
+	ForcedOrganization       []string `json:"forcedOrganization,omitempty"`
+	ForcedOrganizationalUnit []string `json:"forcedOrganizationalUnit,omitempty"`
+}
+
+// PolicyViolation is one way that a CSR breaks an issuance policy.
+type PolicyViolation struct {
+	// Field is the part of the CSR, such as 'dns', 'ip', 'uri', 'email', or 'subject.CN'.
+	Field string `json:"field"`
+	Value string `json:"value"`
+	// Rule is the JSON name of the policy setting that was violated.
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (v PolicyViolation) String() string {
+	if v.Value == "" {
+		return fmt.Sprintf("%s: %s", v.Field, v.Message)
+	}
+	return fmt.Sprintf("%s '%s': %s", v.Field, v.Value, v.Message)
+}
+
+// PolicyError lists every violation of an issuance policy found in a CSR. It matches ErrPolicyViolation with errors.Is.
+type PolicyError struct {
+	Violations []PolicyViolation
+}
+
+func (e *PolicyError) Error() string {
+	var violations []string
+	for _, violation := range e.Violations {
+		violations = append(violations, violation.String())
+	}
+	return fmt.Sprintf("%v: %s", ErrPolicyViolation, strings.Join(violations, "; "))
+}
+
+func (e *PolicyError) Is(target error) bool {
+	return target == ErrPolicyViolation
+}
+
+// LoadIssuancePolicy reads an issuance policy from a JSON file and validates it.
+func LoadIssuancePolicy(path string) (*IssuancePolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	policy := &IssuancePolicy{}
+	if err := json.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPolicy, err)
+	}
+	if _, _, err := policy.compile(); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// compile validates the policy, and parses its IP ranges and common name pattern.
+func (p *IssuancePolicy) compile() ([]*net.IPNet, *regexp.Regexp, error) {
+	if p.Wildcards != "" && p.Wildcards != WildcardsAllow && p.Wildcards != WildcardsDeny {
+		return nil, nil, fmt.Errorf("%w: wildcards must be '%s' or '%s'", ErrInvalidPolicy, WildcardsAllow, WildcardsDeny)
+	}
+	var ranges []*net.IPNet
+	for _, cidr := range p.PermittedCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid CIDR '%s'", ErrInvalidPolicy, cidr)
+		}
+		ranges = append(ranges, ipNet)
+	}
+	var cnPattern *regexp.Regexp
+	if p.CommonNamePattern != "" {
+		var err error
+		if cnPattern, err = regexp.Compile("^(?:" + p.CommonNamePattern + ")$"); err != nil {
+			return nil, nil, fmt.Errorf("%w: invalid common name pattern: %v", ErrInvalidPolicy, err)
+		}
+	}
+	for _, field := range p.RequiredSubjectFields {
+		if _, ok := subjectFields[strings.ToUpper(field)]; !ok {
+			return nil, nil, fmt.Errorf("%w: unknown subject field '%s'", ErrInvalidPolicy, field)
+		}
+	}
+	return ranges, cnPattern, nil
+}
+
+// Check returns a *PolicyError listing every violation of the policy in the CSR, or nil if there are none.
+// A common name that looks like a DNS name is checked against the DNS rules too.
+func (p *IssuancePolicy) Check(csr *x509.CertificateRequest) error {
+	ranges, cnPattern, err := p.compile()
+	if err != nil {
+		return err
+	}
+
+	var violations []PolicyViolation
+	dnsNames := csr.DNSNames
+	if cn := csr.Subject.CommonName; strings.Contains(cn, ".") && !strings.ContainsAny(cn, " @/:") && net.ParseIP(cn) == nil && !containsString(dnsNames, cn) {
+		dnsNames = append(append([]string{}, dnsNames...), cn)
+	}
+	for _, name := range dnsNames {
+		violations = append(violations, p.checkDNSName(name)...)
+	}
+
+	for _, ip := range csr.IPAddresses {
+		if len(ranges) > 0 && !ipInRanges(ip, ranges) {
+			violations = append(violations, PolicyViolation{Field: "ip", Value: ip.String(), Rule: "permittedCidrs", Message: "not in a permitted IP range"})
+		}
+	}
+
+	for _, uri := range csr.URIs {
+		host := uri.Hostname()
+		if ip := net.ParseIP(host); ip != nil {
+			if len(ranges) > 0 && !ipInRanges(ip, ranges) {
+				violations = append(violations, PolicyViolation{Field: "uri", Value: uri.String(), Rule: "permittedCidrs", Message: "the host is not in a permitted IP range"})
+			}
+			continue
+		}
+		violations = append(violations, p.checkDomain("uri", uri.String(), host)...)
+	}
+	for _, email := range csr.EmailAddresses {
+		domain := email[strings.LastIndex(email, "@")+1:]
+		violations = append(violations, p.checkDomain("email", email, domain)...)
+	}
+
+	if cnPattern != nil && !cnPattern.MatchString(csr.Subject.CommonName) {
+		violations = append(violations, PolicyViolation{Field: "subject.CN", Value: csr.Subject.CommonName, Rule: "commonNamePattern",
+			Message: fmt.Sprintf("does not match the pattern '%s'", p.CommonNamePattern)})
+	}
+	for _, field := range p.RequiredSubjectFields {
+		field = strings.ToUpper(field)
+		if len(subjectFields[field](csr.Subject)) == 0 {
+			violations = append(violations, PolicyViolation{Field: "subject." + field, Rule: "requiredSubjectFields", Message: "is required"})
+		}
+	}
+
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func (p *IssuancePolicy) checkDNSName(name string) []PolicyViolation {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	var violations []PolicyViolation
+	base := name
+	if strings.Contains(name, "*") {
+		switch {
+		case p.Wildcards == WildcardsDeny:
+			violations = append(violations, PolicyViolation{Field: "dns", Value: name, Rule: "wildcards", Message: "wildcard names are not allowed"})
+		case !strings.HasPrefix(name, "*.") || strings.Contains(name[2:], "*"):
+			violations = append(violations, PolicyViolation{Field: "dns", Value: name, Rule: "wildcards", Message: "a wildcard must be the whole leftmost label"})
+		}
+		base = strings.TrimPrefix(name, "*.")
+	}
+	return append(violations, p.checkDomain("dns", name, base)...)
+}
+
+// checkDomain checks the domain of a DNS name, URI host, or email address against the allowed and denied suffixes.
+// A URI without a host, such as a URN, is never in an allowed domain.
+func (p *IssuancePolicy) checkDomain(field, value, domain string) []PolicyViolation {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if domain != "" {
+		for _, suffix := range p.DeniedDNSSuffixes {
+			if hasDNSSuffix(domain, suffix) {
+				return []PolicyViolation{{Field: field, Value: value, Rule: "deniedDnsSuffixes", Message: fmt.Sprintf("the domain '%s' is denied", suffix)}}
+			}
+		}
+	}
+	if len(p.AllowedDNSSuffixes) > 0 {
+		for _, suffix := range p.AllowedDNSSuffixes {
+			if domain != "" && hasDNSSuffix(domain, suffix) {
+				return nil
+			}
+		}
+		return []PolicyViolation{{Field: field, Value: value, Rule: "allowedDnsSuffixes", Message: "not in an allowed domain"}}
+	}
+	return nil
+}
+
+// checkPolicies checks the CSR against every policy, and returns a single *PolicyError with all of their violations.
+func checkPolicies(csr *x509.CertificateRequest, policies []*IssuancePolicy) error {
+	var violations []PolicyViolation
+	for _, policy := range policies {
+		err := policy.Check(csr)
+		var policyErr *PolicyError
+		if errors.As(err, &policyErr) {
+			violations = append(violations, policyErr.Violations...)
+		} else if err != nil {
+			return err
+		}
+	}
+	if len(violations) > 0 {
+		return &PolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// apply replaces the subject's O and OU values if the policy forces them.
+func (p *IssuancePolicy) apply(template *x509.Certificate) {
+	if len(p.ForcedOrganization) > 0 {
+		template.Subject.Organization = p.ForcedOrganization
+	}
+	if len(p.ForcedOrganizationalUnit) > 0 {
+		template.Subject.OrganizationalUnit = p.ForcedOrganizationalUnit
+	}
+}
+
+// hasDNSSuffix reports whether the name is the domain or one of its subdomains.
+func hasDNSSuffix(name, suffix string) bool {
+	suffix = strings.ToLower(strings.Trim(strings.TrimPrefix(strings.TrimSpace(suffix), "*"), "."))
+	return name == suffix || strings.HasSuffix(name, "."+suffix)
+}
+
+func ipInRanges(ip net.IP, ranges []*net.IPNet) bool {
+	for _, ipRange := range ranges {
+		if ipRange.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func nonEmpty(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return []string{value}
+}
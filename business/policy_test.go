@@ -0,0 +1,149 @@
+package business
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func mustParseURL(t *testing.T, rawURL string) *url.URL {
+	t.Helper()
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestIssuancePolicyCheck(t *testing.T) {
+	policy := &IssuancePolicy{
+		AllowedDNSSuffixes:    []string{"example.com"},
+		DeniedDNSSuffixes:     []string{"secret.example.com"},
+		Wildcards:             WildcardsDeny,
+		PermittedCIDRs:        []string{"10.0.0.0/8"},
+		CommonNamePattern:     `[a-z.]+`,
+		RequiredSubjectFields: []string{"O"},
+	}
+	subject := pkix.Name{CommonName: "www.example.com", Organization: []string{"Acme"}}
+
+	tests := map[string]struct {
+		csr x509.CertificateRequest
+		// rules lists the rule of each expected violation, in order.
+		rules []string
+	}{
+		"allowed": {
+			csr: x509.CertificateRequest{
+				Subject:        subject,
+				DNSNames:       []string{"www.example.com", "example.com"},
+				IPAddresses:    []net.IP{net.ParseIP("10.1.2.3")},
+				URIs:           []*url.URL{mustParseURL(t, "spiffe://example.com/workload"), mustParseURL(t, "https://10.0.0.1/")},
+				EmailAddresses: []string{"admin@mail.example.com"},
+			},
+		},
+		"dns outside allowed domains": {
+			csr:   x509.CertificateRequest{Subject: subject, DNSNames: []string{"www.example.org"}},
+			rules: []string{"allowedDnsSuffixes"},
+		},
+		"suffix must match whole labels": {
+			csr:   x509.CertificateRequest{Subject: subject, DNSNames: []string{"badexample.com"}},
+			rules: []string{"allowedDnsSuffixes"},
+		},
+		"denied dns": {
+			csr:   x509.CertificateRequest{Subject: subject, DNSNames: []string{"db.secret.example.com"}},
+			rules: []string{"deniedDnsSuffixes"},
+		},
+		"wildcard denied": {
+			csr:   x509.CertificateRequest{Subject: subject, DNSNames: []string{"*.example.com"}},
+			rules: []string{"wildcards"},
+		},
+		"common name treated as dns": {
+			csr:   x509.CertificateRequest{Subject: pkix.Name{CommonName: "www.example.net", Organization: []string{"Acme"}}},
+			rules: []string{"allowedDnsSuffixes"},
+		},
+		"ip outside ranges": {
+			csr:   x509.CertificateRequest{Subject: subject, IPAddresses: []net.IP{net.ParseIP("192.168.1.1")}},
+			rules: []string{"permittedCidrs"},
+		},
+		"uri host outside allowed domains": {
+			csr:   x509.CertificateRequest{Subject: subject, URIs: []*url.URL{mustParseURL(t, "spiffe://evil.org/workload")}},
+			rules: []string{"allowedDnsSuffixes"},
+		},
+		"uri host denied": {
+			csr:   x509.CertificateRequest{Subject: subject, URIs: []*url.URL{mustParseURL(t, "https://secret.example.com/")}},
+			rules: []string{"deniedDnsSuffixes"},
+		},
+		"uri without host": {
+			csr:   x509.CertificateRequest{Subject: subject, URIs: []*url.URL{mustParseURL(t, "urn:uuid:6e8bc430-9c3a-11d9-9669-0800200c9a66")}},
+			rules: []string{"allowedDnsSuffixes"},
+		},
+		"uri ip host outside ranges": {
+			csr:   x509.CertificateRequest{Subject: subject, URIs: []*url.URL{mustParseURL(t, "https://192.168.1.1/")}},
+			rules: []string{"permittedCidrs"},
+		},
+		"email outside allowed domains": {
+			csr:   x509.CertificateRequest{Subject: subject, EmailAddresses: []string{"admin@evil.org"}},
+			rules: []string{"allowedDnsSuffixes"},
+		},
+		"email denied": {
+			csr:   x509.CertificateRequest{Subject: subject, EmailAddresses: []string{"root@secret.example.com"}},
+			rules: []string{"deniedDnsSuffixes"},
+		},
+		"common name pattern and required field": {
+			csr:   x509.CertificateRequest{Subject: pkix.Name{CommonName: "Web Server"}},
+			rules: []string{"commonNamePattern", "requiredSubjectFields"},
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := policy.Check(&tc.csr)
+			var rules []string
+			var policyErr *PolicyError
+			if errors.As(err, &policyErr) {
+				if !errors.Is(err, ErrPolicyViolation) {
+					t.Error("expected the error to match ErrPolicyViolation")
+				}
+				for _, violation := range policyErr.Violations {
+					rules = append(rules, violation.Rule)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(rules, tc.rules) {
+				t.Fatalf("expected violations of %v, got %v (%v)", tc.rules, rules, err)
+			}
+		})
+	}
+}
+
+func TestIssuancePolicyZeroValueAllowsAll(t *testing.T) {
+	csr := &x509.CertificateRequest{
+		Subject:        pkix.Name{CommonName: "*.anything.test"},
+		DNSNames:       []string{"*.anything.test"},
+		IPAddresses:    []net.IP{net.ParseIP("203.0.113.1")},
+		URIs:           []*url.URL{mustParseURL(t, "urn:example:thing")},
+		EmailAddresses: []string{"someone@anywhere.test"},
+	}
+	if err := (&IssuancePolicy{}).Check(csr); err != nil {
+		t.Fatalf("expected the zero policy to allow the CSR, got %v", err)
+	}
+}
+
+func TestCheckPoliciesCombinesViolations(t *testing.T) {
+	csr := &x509.CertificateRequest{Subject: pkix.Name{CommonName: "host"}, DNSNames: []string{"host.example.org"}}
+	policies := []*IssuancePolicy{
+		{AllowedDNSSuffixes: []string{"example.com"}},
+		{RequiredSubjectFields: []string{"OU"}},
+	}
+	err := checkPolicies(csr, policies)
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("expected a *PolicyError, got %v", err)
+	}
+	if len(policyErr.Violations) != 2 {
+		t.Fatalf("expected a violation from each policy, got %v", policyErr.Violations)
+	}
+}
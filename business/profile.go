@@ -0,0 +1,267 @@
+package business
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ProfileServer = "server"
+	ProfileClient = "client"
+	ProfileCA     = "ca"
+)
+
+var (
+	ErrUnknownProfile = errors.New("unknown certificate profile")
+	ErrInvalidProfile = errors.New("invalid certificate profile")
+	ErrSanNotAllowed  = errors.New("the certificate profile doesn't allow a SAN type in the CSR")
+)
+
+//go:embed profiles.yaml
+var builtinProfilesYAML []byte
+
+var oidExtKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"nonrepudiation":    x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"keycertsign":       x509.KeyUsageCertSign,
+	"certsign":          x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+var extKeyUsageOids = map[string]asn1.ObjectIdentifier{
+	"any":             {2, 5, 29, 37, 0},
+	"serverauth":      {1, 3, 6, 1, 5, 5, 7, 3, 1},
+	"clientauth":      {1, 3, 6, 1, 5, 5, 7, 3, 2},
+	"codesigning":     {1, 3, 6, 1, 5, 5, 7, 3, 3},
+	"emailprotection": {1, 3, 6, 1, 5, 5, 7, 3, 4},
+	"timestamping":    {1, 3, 6, 1, 5, 5, 7, 3, 8},
+	"ocspsigning":     {1, 3, 6, 1, 5, 5, 7, 3, 9},
+}
+
+var sanTypes = []string{"dns", "ip", "email", "uri"}
+
+// Profile describes a kind of certificate, such as a TLS server certificate or a sub-CA.
+// Key usages and extended key usages are given by their RFC 5280 names, and extended key usages may also be dotted OIDs.
+type Profile struct {
+	Name                string   `yaml:"-"`
+	Description         string   `yaml:"description"`
+	KeyUsage            []string `yaml:"keyUsage"`
+	ExtKeyUsage         []string `yaml:"extKeyUsage"`
+	ExtKeyUsageCritical bool     `yaml:"extKeyUsageCritical"`
+	// Validity is the default validity period, as a Go duration or a number of days such as '90d'.
+	Validity string `yaml:"validity"`
+	IsCA     bool   `yaml:"isCA"`
+	// MaxPathLen is the default path length of a CA profile. If it's not set, SignMaxPathLen's default is used.
+	MaxPathLen *int `yaml:"maxPathLen"`
+	// AllowedSANs lists the SAN types a CSR may have, from dns, ip, email, and uri. All are allowed if it's not set.
+	AllowedSANs []string          `yaml:"allowedSANs"`
+	Extensions  []CustomExtension `yaml:"extensions"`
+	// Policies are added to the certificate policies extension.
+	Policies []CertPolicy `yaml:"policies"`
+	// MustStaple adds the TLS feature extension, which requires servers to staple an OCSP response.
+	MustStaple bool `yaml:"mustStaple"`
+}
+
+// Profiles is a set of certificate profiles by name.
+type Profiles map[string]*Profile
+
+type profileFile struct {
+	Profiles map[string]*Profile `yaml:"profiles"`
+}
+
+// BuiltinProfiles returns the profiles that ship with certcli: server, client, ca, code-signing, smime, ocsp-signing, and timestamping.
+func BuiltinProfiles() Profiles {
+	profiles, err := parseProfiles(builtinProfilesYAML)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in profiles: %v", err))
+	}
+	return profiles
+}
+
+// LoadProfiles returns the built-in profiles, along with the profiles in a YAML or JSON profile file.
+// Profiles in the file replace built-in profiles with the same name.
+func LoadProfiles(path string) (Profiles, error) {
+	profiles := BuiltinProfiles()
+	if path == "" {
+		return profiles, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	fileProfiles, err := parseProfiles(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profiles from '%s': %w", path, err)
+	}
+	for name, profile := range fileProfiles {
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+func parseProfiles(data []byte) (Profiles, error) {
+	var file profileFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidProfile, err)
+	}
+	profiles := Profiles{}
+	for name, profile := range file.Profiles {
+		if profile == nil {
+			return nil, fmt.Errorf("%w '%s': the profile is empty", ErrInvalidProfile, name)
+		}
+		profile.Name = name
+		if err := profile.validate(); err != nil {
+			return nil, err
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+// Get returns the named profile.
+func (p Profiles) Get(name string) (*Profile, error) {
+	profile, ok := p[name]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s', must be one of %s", ErrUnknownProfile, name, strings.Join(p.Names(), ", "))
+	}
+	return profile, nil
+}
+
+// Names lists the profile names in sorted order.
+func (p Profiles) Names() []string {
+	var names []string
+	for name := range p {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) validate() error {
+	if _, err := p.validity(); err != nil {
+		return err
+	}
+	for _, sanType := range p.AllowedSANs {
+		if !containsString(sanTypes, strings.ToLower(sanType)) {
+			return p.invalid("unknown SAN type '%s', must be one of %s", sanType, strings.Join(sanTypes, ", "))
+		}
+	}
+	if p.MaxPathLen != nil && !p.IsCA {
+		return p.invalid("maxPathLen may only be set with isCA")
+	}
+	return p.apply(&x509.Certificate{})
+}
+
+func (p *Profile) invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w '%s': %s", ErrInvalidProfile, p.Name, fmt.Sprintf(format, args...))
+}
+
+// validity returns the profile's default validity period.
+func (p *Profile) validity() (time.Duration, error) {
+	validity, err := ParseDuration(p.Validity)
+	if err != nil || validity <= 0 {
+		return 0, p.invalid("validity must be a positive duration such as '24h' or '90d'")
+	}
+	return validity, nil
+}
+
+// apply sets the key usages, extensions, and policies of the profile on the template. Basic constraints are set by SignCsr.
+func (p *Profile) apply(template *x509.Certificate) error {
+	for _, name := range p.KeyUsage {
+		usage, ok := keyUsageNames[strings.ToLower(name)]
+		if !ok {
+			return p.invalid("unknown key usage '%s'", name)
+		}
+		template.KeyUsage |= usage
+	}
+
+	if len(p.ExtKeyUsage) > 0 {
+		// The extension is built here, since the x509 package can't mark it critical.
+		var oids []asn1.ObjectIdentifier
+		for _, name := range p.ExtKeyUsage {
+			oid, ok := extKeyUsageOids[strings.ToLower(name)]
+			if !ok {
+				var err error
+				if oid, err = parseOid(name); err != nil {
+					return p.invalid("unknown extended key usage '%s'", name)
+				}
+			}
+			oids = append(oids, oid)
+		}
+		value, err := asn1.Marshal(oids)
+		if err != nil {
+			return err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{Id: oidExtKeyUsage, Critical: p.ExtKeyUsageCritical, Value: value})
+	}
+
+	if err := applyExtensions(template, p.Extensions, p.Policies, p.MustStaple, false); err != nil {
+		return p.invalid("%v", err)
+	}
+	return nil
+}
+
+// checkSANs returns an error if the CSR has a SAN type that the profile doesn't allow.
+func (p *Profile) checkSANs(csr *x509.CertificateRequest) error {
+	if p.AllowedSANs == nil {
+		return nil
+	}
+	present := map[string]bool{
+		"dns":   len(csr.DNSNames) > 0,
+		"ip":    len(csr.IPAddresses) > 0,
+		"email": len(csr.EmailAddresses) > 0,
+		"uri":   len(csr.URIs) > 0,
+	}
+	for _, sanType := range sanTypes {
+		if present[sanType] && !containsString(p.AllowedSANs, sanType) {
+			return fmt.Errorf("%w: %s SANs are not allowed by the '%s' profile", ErrSanNotAllowed, sanType, p.Name)
+		}
+	}
+	return nil
+}
+
+func parseOid(dotted string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(strings.TrimSpace(dotted), ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID '%s'", dotted)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		arc, err := strconv.Atoi(part)
+		if err != nil || arc < 0 {
+			return nil, fmt.Errorf("invalid OID '%s'", dotted)
+		}
+		oid[i] = arc
+	}
+	return oid, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
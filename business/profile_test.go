@@ -0,0 +1,176 @@
+package business
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+const testProfilesYAML = `
+profiles:
+  server:
+    description: Short-lived TLS server certificate
+    keyUsage: [digitalSignature, keyEncipherment]
+    extKeyUsage: [serverAuth]
+    extKeyUsageCritical: true
+    validity: 7d
+    allowedSANs: [dns]
+  device:
+    description: Device certificate with a private extended key usage
+    keyUsage: [digitalSignature]
+    extKeyUsage: [clientAuth, 1.3.6.1.4.1.55555.1]
+    validity: 24h
+  issuing:
+    description: Issuing CA that can't have CAs below it
+    keyUsage: [keyCertSign, cRLSign]
+    validity: 30d
+    isCA: true
+    maxPathLen: 0
+`
+
+func TestLoadProfiles(t *testing.T) {
+	profiles, err := LoadProfiles(writeTestFile(t, "profiles.yaml", []byte(testProfilesYAML)))
+	if err != nil {
+		t.Fatalf("LoadProfiles: %v", err)
+	}
+	for _, name := range []string{ProfileServer, ProfileClient, ProfileCA, "device", "issuing"} {
+		if _, err := profiles.Get(name); err != nil {
+			t.Errorf("Get(%s): %v", name, err)
+		}
+	}
+	if profile, _ := profiles.Get(ProfileServer); profile.Validity != "7d" {
+		t.Fatal("expected the file's server profile to replace the built-in one")
+	}
+	if _, err := profiles.Get("missing"); !errors.Is(err, ErrUnknownProfile) {
+		t.Fatalf("expected ErrUnknownProfile, got %v", err)
+	}
+
+	builtin, err := LoadProfiles("")
+	if err != nil {
+		t.Fatalf("LoadProfiles: %v", err)
+	}
+	if len(builtin) != len(BuiltinProfiles()) {
+		t.Fatalf("expected only the built-in profiles without a file, got %v", builtin.Names())
+	}
+}
+
+func TestInvalidProfiles(t *testing.T) {
+	tests := map[string]string{
+		"unknown key usage":       "profiles: {bad: {keyUsage: [signEverything], validity: 1d}}",
+		"unknown ext key usage":   "profiles: {bad: {extKeyUsage: [serverAuthentication], validity: 1d}}",
+		"missing validity":        "profiles: {bad: {keyUsage: [digitalSignature]}}",
+		"negative validity":       "profiles: {bad: {validity: -1h}}",
+		"unknown SAN type":        "profiles: {bad: {validity: 1d, allowedSANs: [dns, upn]}}",
+		"path length without CA":  "profiles: {bad: {validity: 1d, maxPathLen: 1}}",
+		"unknown field":           "profiles: {bad: {validity: 1d, keyUsages: [digitalSignature]}}",
+		"empty profile":           "profiles: {bad: }",
+		"not a profile file":      "- server",
+		"ext key usage wrong OID": "profiles: {bad: {extKeyUsage: [1.3.six], validity: 1d}}",
+	}
+	for name, yaml := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadProfiles(writeTestFile(t, "profiles.yaml", []byte(yaml)))
+			if !errors.Is(err, ErrInvalidProfile) {
+				t.Fatalf("expected ErrInvalidProfile, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSignProfiles(t *testing.T) {
+	profiles, err := LoadProfiles(writeTestFile(t, "profiles.yaml", []byte(testProfilesYAML)))
+	if err != nil {
+		t.Fatalf("LoadProfiles: %v", err)
+	}
+	caCertFile, caKeyFile := newTestCa(t)
+
+	tests := map[string]struct {
+		profile     string
+		csrOpts     []CsrOpt
+		wantUsage   x509.KeyUsage
+		wantExt     []x509.ExtKeyUsage
+		wantExtCrit bool
+		wantUnknown int
+		wantCA      bool
+		wantFor     time.Duration
+		// wantErr is checked with errors.Is, and nil expects the signing to succeed.
+		wantErr error
+	}{
+		"server": {
+			profile:     ProfileServer,
+			wantUsage:   x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			wantExt:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			wantExtCrit: true,
+			wantFor:     7 * 24 * time.Hour,
+		},
+		"private ext key usage": {
+			profile:     "device",
+			wantUsage:   x509.KeyUsageDigitalSignature,
+			wantExt:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			wantUnknown: 1,
+			wantFor:     24 * time.Hour,
+		},
+		"CA": {
+			profile:   "issuing",
+			wantUsage: x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+			wantCA:    true,
+			wantFor:   30 * 24 * time.Hour,
+		},
+		"no SANs allowed": {
+			profile: "ocsp-signing",
+			wantErr: ErrSanNotAllowed,
+		},
+		"SAN not allowed": {
+			profile: ProfileServer,
+			csrOpts: []CsrOpt{CsrAddIP(net.ParseIP("10.0.0.1"))},
+			wantErr: ErrSanNotAllowed,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			profile, err := profiles.Get(tc.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			csrFile, _ := newTestCsr(t, "www.example.com", tc.csrOpts...)
+			der, _, err := SignCsr(csrFile, caCertFile, caKeyFile, profile)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignCsr: %v", err)
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cert.KeyUsage != tc.wantUsage {
+				t.Fatalf("expected key usage %b, got %b", tc.wantUsage, cert.KeyUsage)
+			}
+			if len(cert.ExtKeyUsage) != len(tc.wantExt) || len(cert.UnknownExtKeyUsage) != tc.wantUnknown {
+				t.Fatalf("expected extended key usages %v, got %v and %v", tc.wantExt, cert.ExtKeyUsage, cert.UnknownExtKeyUsage)
+			}
+			for i, usage := range tc.wantExt {
+				if cert.ExtKeyUsage[i] != usage {
+					t.Fatalf("expected extended key usages %v, got %v", tc.wantExt, cert.ExtKeyUsage)
+				}
+			}
+			for _, ext := range cert.Extensions {
+				if ext.Id.Equal(oidExtKeyUsage) && ext.Critical != tc.wantExtCrit {
+					t.Fatalf("expected the extended key usage to be critical: %t", tc.wantExtCrit)
+				}
+			}
+			if cert.IsCA != tc.wantCA || (tc.wantCA && !cert.MaxPathLenZero) {
+				t.Fatalf("expected IsCA %t with a zero path length, got %t and %d", tc.wantCA, cert.IsCA, cert.MaxPathLen)
+			}
+			if got := cert.NotAfter.Sub(cert.NotBefore); got != tc.wantFor {
+				t.Fatalf("expected a validity of %s, got %s", tc.wantFor, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+package business
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"time"
+)
+
+// Extensions that identify the keys of a certificate, which are regenerated on renewal. Every other extension is copied as-is.
+var (
+	oidSubjectKeyId   = asn1.ObjectIdentifier{2, 5, 29, 14}
+	oidAuthorityKeyId = asn1.ObjectIdentifier{2, 5, 29, 35}
+)
+
+// renewedProfile is recorded in the CA directory's index in place of a profile name, when the old certificate wasn't indexed.
+const renewedProfile = "renewed"
+
+type renewOpts struct {
+	rekey           bool
+	keyType         KeyType
+	keyBits         int
+	keyTypeSet      bool
+	keyPassphrase   format.PassphraseFunc
+	keyKDF          format.KDF
+	caKeyPassphrase format.PassphraseFunc
+	caDir           *CaDir
+	validity        Validity
+	keyPolicy       KeyStrengthPolicy
+	policies        []*IssuancePolicy
+}
+
+type RenewOpt func(opts *renewOpts)
+
+// RenewRekey generates a new key for the renewed certificate, of the same type and size as the old key.
+func RenewRekey() RenewOpt {
+	return func(opts *renewOpts) {
+		opts.rekey = true
+	}
+}
+
+// RenewKeyType generates a new key of the given type for the renewed certificate. The bits are only used for RSA keys.
+func RenewKeyType(keyType KeyType, bits int) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.rekey = true
+		opts.keyType = keyType
+		opts.keyBits = bits
+		opts.keyTypeSet = true
+	}
+}
+
+// RenewEncryptKey causes a new key to be output as an encrypted PKCS#8 key.
+func RenewEncryptKey(passphrase format.PassphraseFunc, kdf format.KDF) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.keyPassphrase = passphrase
+		opts.keyKDF = kdf
+	}
+}
+
+// RenewCaKeyPassphrase sets the source of the passphrase used if the CA key is encrypted.
+func RenewCaKeyPassphrase(passphrase format.PassphraseFunc) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.caKeyPassphrase = passphrase
+	}
+}
+
+// RenewRecordIn records the renewed certificate in the CA directory's index, with the same profile as the original.
+func RenewRecordIn(caDir *CaDir) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.caDir = caDir
+	}
+}
+
+// RenewKeyPolicy replaces DefaultKeyStrengthPolicy when checking the renewed certificate's public key.
+func RenewKeyPolicy(policy KeyStrengthPolicy) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.keyPolicy = policy
+	}
+}
+
+// RenewPolicy enforces an issuance policy on the renewed certificate, in addition to the CA directory's policy.
+// May be given more than once, and every policy must be satisfied.
+func RenewPolicy(policy *IssuancePolicy) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.policies = append(opts.policies, policy)
+	}
+}
+
+// RenewValidity sets the validity period. By default, it's as long as the original certificate's,
+// clamped to the issuing CA's expiration.
+func RenewValidity(validity Validity) RenewOpt {
+	return func(opts *renewOpts) {
+		opts.validity = validity
+	}
+}
+
+// RenewCert reissues a certificate with the same subject, SANs, and usages, a new serial number,
+// and by default a validity period of the same length starting now. The existing public key is reused unless a rekey option is given,
+// in which case the new DER private key is also returned.
+// The renewal must pass the same checks as SignCsr: the key strength and issuance policies, the original profile's SAN types
+// with a CA directory, the issuer's path length, and the issuer's name constraints.
+func RenewCert(certFile, caCertFile, caKeyFile string, opts ...RenewOpt) (cert []byte, key []byte, err error) {
+	_renewOpts := &renewOpts{
+		keyKDF:          format.KDFPBKDF2,
+		caKeyPassphrase: format.PassphrasePrompt("CA key passphrase", false),
+		keyPolicy:       DefaultKeyStrengthPolicy,
+	}
+	for _, opt := range opts {
+		opt(_renewOpts)
+	}
+
+	oldCert, err := LoadCertFromFile(certFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load certificate '%s': %w", certFile, err)
+	}
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA certificate '%s': %w", caCertFile, err)
+	}
+	if _renewOpts.caDir != nil {
+		// Certificates issued before a rollover are renewed under the new root.
+		if err := _renewOpts.caDir.checkIssued(oldCert, caCert); err != nil {
+			return nil, nil, err
+		}
+	} else if err := oldCert.CheckSignatureFrom(caCert); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrNotIssuedByCa, err)
+	}
+	caKey, err := LoadPrivateKeyFromFile(caKeyFile, _renewOpts.caKeyPassphrase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load CA key '%s': %w", caKeyFile, err)
+	}
+
+	pub := oldCert.PublicKey
+	if _renewOpts.rekey {
+		if !_renewOpts.keyTypeSet {
+			_renewOpts.keyType, _renewOpts.keyBits, err = keyTypeOf(oldCert.PublicKey)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		priv, err := generateKeypair(_renewOpts.keyType, _renewOpts.keyBits)
+		if err != nil {
+			return nil, nil, err
+		}
+		key, err = encodePrivateKey(priv, _renewOpts.keyPassphrase, _renewOpts.keyKDF)
+		if err != nil {
+			return nil, nil, err
+		}
+		pub = priv.Public()
+	}
+
+	request := &x509.CertificateRequest{
+		Subject:        oldCert.Subject,
+		DNSNames:       oldCert.DNSNames,
+		IPAddresses:    oldCert.IPAddresses,
+		EmailAddresses: oldCert.EmailAddresses,
+		URIs:           oldCert.URIs,
+		PublicKey:      pub,
+	}
+	profile := renewedProfile
+	if _renewOpts.caDir != nil {
+		if profile, err = _renewOpts.caDir.checkRenewalProfile(oldCert, request); err != nil {
+			return nil, nil, err
+		}
+	}
+	policies := issuancePolicies(_renewOpts.policies, _renewOpts.caDir)
+	if err := checkRequest(request, _renewOpts.keyPolicy, policies); err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := generateSerialNumber()
+	if err != nil {
+		return nil, nil, err
+	}
+	template := renewalTemplate(oldCert)
+	template.SerialNumber = serial
+	template.Subject.SerialNumber = serial.String()
+	for _, policy := range policies {
+		policy.apply(template)
+	}
+	if oldCert.IsCA {
+		maxPathLen, err := subCaPathLen(caCert, oldCert.MaxPathLen, oldCert.MaxPathLen >= 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		extensions := template.ExtraExtensions[:0]
+		for _, ext := range template.ExtraExtensions {
+			if !ext.Id.Equal(oidBasicConstraints) {
+				extensions = append(extensions, ext)
+			}
+		}
+		template.ExtraExtensions = extensions
+		applyMaxPathLen(template, maxPathLen)
+	}
+	template.NotBefore, template.NotAfter, err = _renewOpts.validity.period(caCert, func(start time.Time) time.Time {
+		return start.Add(oldCert.NotAfter.Sub(oldCert.NotBefore))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _renewOpts.caDir != nil {
+		_renewOpts.caDir.Config.URLs.apply(template)
+	}
+
+	cert, _, err = issueCert(template, caCert, pub, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _renewOpts.caDir != nil {
+		if _, err := _renewOpts.caDir.Record(cert, profile); err != nil {
+			return nil, nil, fmt.Errorf("failed to record certificate in CA index: %w", err)
+		}
+	}
+	return cert, key, nil
+}
+
+// checkRenewalProfile returns the profile the certificate was recorded with, or 'renewed' if it isn't in the index,
+// and checks that the profile still exists and allows the certificate's SAN types.
+func (d *CaDir) checkRenewalProfile(oldCert *x509.Certificate, request *x509.CertificateRequest) (string, error) {
+	oldEntry, err := d.Lookup(oldCert.SerialNumber.Text(16))
+	if errors.Is(err, ErrSerialNotFound) {
+		return renewedProfile, nil
+	} else if err != nil {
+		return "", err
+	}
+	switch oldEntry.Profile {
+	case renewedProfile, crossSignedProfile, rolloverLinkProfile:
+		// These certificates weren't issued with a profile, so there's nothing to check.
+		return oldEntry.Profile, nil
+	}
+	profiles, err := d.Profiles()
+	if err != nil {
+		return "", err
+	}
+	profile, err := profiles.Get(oldEntry.Profile)
+	if err != nil {
+		return "", fmt.Errorf("the certificate was issued with a profile that no longer exists: %w", err)
+	}
+	if err := profile.checkSANs(request); err != nil {
+		return "", err
+	}
+	return oldEntry.Profile, nil
+}
+
+// renewalTemplate copies the subject and extensions of a certificate. Copying the raw extensions keeps anything
+// the x509 package can't represent in template fields, such as policy qualifiers.
+func renewalTemplate(old *x509.Certificate) *x509.Certificate {
+	template := &x509.Certificate{
+		Subject: old.Subject,
+		// These are also in the copied extensions, but the x509 package checks them when signing.
+		BasicConstraintsValid: old.BasicConstraintsValid,
+		IsCA:                  old.IsCA,
+		MaxPathLen:            old.MaxPathLen,
+		MaxPathLenZero:        old.MaxPathLenZero,
+		KeyUsage:              old.KeyUsage,
+	}
+	for _, ext := range old.Extensions {
+		if !ext.Id.Equal(oidSubjectKeyId) && !ext.Id.Equal(oidAuthorityKeyId) {
+			template.ExtraExtensions = append(template.ExtraExtensions, ext)
+		}
+	}
+	return template
+}
+
+// createVerifiedCert signs the certificate and checks the signature against the CA certificate.
+// The subject and authority key IDs are always set, deriving them from the keys if needed.
+func createVerifiedCert(template, caCert *x509.Certificate, pub crypto.PublicKey, caKey crypto.Signer) ([]byte, error) {
+	var err error
+	if len(template.SubjectKeyId) == 0 {
+		if template.SubjectKeyId, err = subjectKeyId(pub); err != nil {
+			return nil, err
+		}
+	}
+	// The x509 package uses the CA's subject key ID when it's present.
+	if len(caCert.SubjectKeyId) == 0 {
+		if template.AuthorityKeyId, err = subjectKeyId(caCert.PublicKey); err != nil {
+			return nil, err
+		}
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, caCert, pub, caKey)
+	if err != nil {
+		return nil, err
+	}
+	newCert, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+	if err := newCert.CheckSignatureFrom(caCert); err != nil {
+		return nil, fmt.Errorf("unable to verify CA signature: %w", err)
+	}
+	return cert, nil
+}
@@ -0,0 +1,118 @@
+package business
+
+import (
+	"errors"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenewCertChecks(t *testing.T) {
+	tests := map[string]struct {
+		opts []RenewOpt
+		// wantErr is checked with errors.Is, and nil expects the renewal to succeed.
+		wantErr error
+	}{
+		"same key": {},
+		"rekey":    {opts: []RenewOpt{RenewRekey()}},
+		"rekey too small": {
+			opts:    []RenewOpt{RenewKeyType(KeyTypeRSA, 1024), RenewKeyPolicy(KeyStrengthPolicy{MinRSABits: 1024})},
+			wantErr: ErrKeySize,
+		},
+		"stricter key policy": {
+			opts:    []RenewOpt{RenewKeyPolicy(KeyStrengthPolicy{MinRSABits: 2048, MinECDSABits: 384})},
+			wantErr: ErrWeakKey,
+		},
+		"policy violation": {
+			opts:    []RenewOpt{RenewPolicy(&IssuancePolicy{AllowedDNSSuffixes: []string{"example.org"}})},
+			wantErr: ErrPolicyViolation,
+		},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir := newTestCaDir(t)
+			cert := issueTestCert(t, dir, "www.example.com")
+			certFile := filepath.Join(t.TempDir(), "www.example.com.cer")
+			if err := ioutil.WriteFile(certFile, format.EncodeCerts(format.EncodingPem, cert.Raw), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			opts := append([]RenewOpt{RenewRecordIn(dir), RenewCaKeyPassphrase(testPassphrase)}, tc.opts...)
+			renewed, _, err := RenewCert(certFile, dir.CertFile(), dir.KeyFile(), opts...)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RenewCert: %v", err)
+			}
+			entries, err := dir.Index()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 2 || entries[1].Profile != ProfileServer {
+				t.Fatalf("expected the renewal to be recorded with the original profile")
+			}
+			if commonName, err := CertCommonName(renewed); err != nil || commonName != "www.example.com" {
+				t.Fatalf("expected the renewal to keep the subject, got '%s' (%v)", commonName, err)
+			}
+		})
+	}
+}
+
+func TestRenewCertProfile(t *testing.T) {
+	dir := newTestCaDir(t)
+	profilesFile := filepath.Join(dir.Path, caDirProfiles)
+	if err := ioutil.WriteFile(profilesFile, []byte(testProfilesYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := dir.Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	device, err := profiles.Get("device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrFile, _ := newTestCsr(t, "device.example.com")
+	der, _, err := SignCsr(csrFile, dir.CertFile(), dir.KeyFile(), device, SignRecordIn(dir), SignCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	certFile := writeTestFile(t, "device.cer", der)
+	renewed, _, err := RenewCert(certFile, dir.CertFile(), dir.KeyFile(), RenewRecordIn(dir), RenewCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("RenewCert: %v", err)
+	}
+
+	// The renewal is recorded with the original profile, so it fails the same way once the profile is removed.
+	if err := os.Remove(profilesFile); err != nil {
+		t.Fatal(err)
+	}
+	for name, der := range map[string][]byte{"original": der, "renewed": renewed} {
+		t.Run(name, func(t *testing.T) {
+			certFile := writeTestFile(t, name+".cer", der)
+			_, _, err := RenewCert(certFile, dir.CertFile(), dir.KeyFile(), RenewRecordIn(dir), RenewCaKeyPassphrase(testPassphrase))
+			if !errors.Is(err, ErrUnknownProfile) {
+				t.Fatalf("expected ErrUnknownProfile, got %v", err)
+			}
+		})
+	}
+
+	// Certificates that weren't indexed are recorded as renewed, which isn't a profile to look up.
+	unindexed, _, err := RenewCert(certFile, dir.CertFile(), dir.KeyFile(), RenewCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("RenewCert: %v", err)
+	}
+	certFile = writeTestFile(t, "unindexed.cer", unindexed)
+	for i := 0; i < 2; i++ {
+		if renewed, _, err = RenewCert(certFile, dir.CertFile(), dir.KeyFile(), RenewRecordIn(dir), RenewCaKeyPassphrase(testPassphrase)); err != nil {
+			t.Fatalf("RenewCert: %v", err)
+		}
+		certFile = writeTestFile(t, "renewed.cer", renewed)
+	}
+}
@@ -0,0 +1,146 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RevocationReason is a CRL reason code from RFC 5280 section 5.3.1.
+type RevocationReason int
+
+const (
+	ReasonUnspecified          RevocationReason = 0
+	ReasonKeyCompromise        RevocationReason = 1
+	ReasonCACompromise         RevocationReason = 2
+	ReasonAffiliationChanged   RevocationReason = 3
+	ReasonSuperseded           RevocationReason = 4
+	ReasonCessationOfOperation RevocationReason = 5
+	ReasonCertificateHold      RevocationReason = 6
+	ReasonRemoveFromCRL        RevocationReason = 8
+	ReasonPrivilegeWithdrawn   RevocationReason = 9
+	ReasonAACompromise         RevocationReason = 10
+)
+
+var (
+	ErrAlreadyRevoked = errors.New("the certificate is already revoked")
+	ErrNotOnHold      = errors.New("only a certificate on hold can be removed from the CRL")
+	ErrNotIssuedByCa  = errors.New("the certificate was not issued by this CA")
+)
+
+var revocationReasonNames = map[RevocationReason]string{
+	ReasonUnspecified:          "unspecified",
+	ReasonKeyCompromise:        "keyCompromise",
+	ReasonCACompromise:         "cACompromise",
+	ReasonAffiliationChanged:   "affiliationChanged",
+	ReasonSuperseded:           "superseded",
+	ReasonCessationOfOperation: "cessationOfOperation",
+	ReasonCertificateHold:      "certificateHold",
+	ReasonRemoveFromCRL:        "removeFromCRL",
+	ReasonPrivilegeWithdrawn:   "privilegeWithdrawn",
+	ReasonAACompromise:         "aACompromise",
+}
+
+// RevocationReasonNames returns the RFC 5280 name of every reason code, in code order.
+func RevocationReasonNames() []string {
+	reasons := make([]int, 0, len(revocationReasonNames))
+	for reason := range revocationReasonNames {
+		reasons = append(reasons, int(reason))
+	}
+	sort.Ints(reasons)
+	names := make([]string, len(reasons))
+	for i, reason := range reasons {
+		names[i] = revocationReasonNames[RevocationReason(reason)]
+	}
+	return names
+}
+
+func (r RevocationReason) String() string {
+	if name, ok := revocationReasonNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("RevocationReason(%d)", int(r))
+}
+
+// ParseRevocationReason accepts an RFC 5280 reason name, ignoring case, or its numeric code.
+func ParseRevocationReason(name string) (RevocationReason, error) {
+	name = strings.TrimSpace(name)
+	for reason, reasonName := range revocationReasonNames {
+		if strings.EqualFold(name, reasonName) || name == fmt.Sprint(int(reason)) {
+			return reason, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown revocation reason '%s', must be one of %s", name, strings.Join(RevocationReasonNames(), ", "))
+}
+
+// Revoke marks the certificate with the hex serial number as revoked in the CA index.
+// A certificate on hold may be revoked again with a permanent reason, or released with ReasonRemoveFromCRL.
+func (d *CaDir) Revoke(serial string, reason RevocationReason, at time.Time) (*IndexEntry, error) {
+	unlock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	entries, err := d.Index()
+	if err != nil {
+		return nil, err
+	}
+	serial = normalizeSerial(serial)
+	var entry *IndexEntry
+	for _, e := range entries {
+		if e.Serial == serial {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSerialNotFound, serial)
+	}
+
+	onHold := entry.Status == CertStatusRevoked && entry.RevocationReason == ReasonCertificateHold
+	at = at.UTC()
+	switch {
+	case reason == ReasonRemoveFromCRL:
+		if !onHold {
+			return nil, ErrNotOnHold
+		}
+		entry.Status = CertStatusValid
+		entry.RevokedAt = nil
+		entry.RevocationReason = ReasonUnspecified
+		entry.HoldReleasedAt = &at
+	case entry.Status == CertStatusRevoked && !onHold:
+		return nil, fmt.Errorf("%w: %s", ErrAlreadyRevoked, serial)
+	case onHold:
+		// The original revocation time is kept when a hold becomes permanent.
+		entry.RevocationReason = reason
+	default:
+		entry.Status = CertStatusRevoked
+		entry.RevokedAt = &at
+		entry.RevocationReason = reason
+		entry.HoldReleasedAt = nil
+	}
+	entry.StatusChangedAt = &at
+
+	if err := d.saveIndex(entries); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// RevokeCertFile revokes the certificate in the file, after checking that it was issued by the CA or one of its previous roots.
+func (d *CaDir) RevokeCertFile(certFile string, reason RevocationReason, at time.Time) (*IndexEntry, error) {
+	cert, err := LoadCertFromFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	caCert, err := LoadCertFromFile(d.CertFile())
+	if err != nil {
+		return nil, err
+	}
+	if err := d.checkIssued(cert, caCert); err != nil {
+		return nil, err
+	}
+	return d.Revoke(cert.SerialNumber.Text(16), reason, at)
+}
@@ -0,0 +1,328 @@
+package business
+
+import (
+	"crypto/x509"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caDirRolloversDir   = "rollovers"
+	rolloverOldCertFile = "old-ca.cer"
+	rolloverOldKeyFile  = "old-ca.key"
+	rolloverOldCrlFile  = "old-ca.crl"
+	rolloverNewWithOld  = "new-with-old.cer"
+	rolloverOldWithNew  = "old-with-new.cer"
+	rolloverTrustBundle = "trust-bundle.pem"
+	rolloverLinkProfile = "rollover-link"
+)
+
+// CaRollover is the record of a CA directory's root being replaced by a successor.
+type CaRollover struct {
+	At          time.Time `json:"at"`
+	OldSerial   string    `json:"oldSerial"`
+	OldNotAfter time.Time `json:"oldNotAfter"`
+	NewSerial   string    `json:"newSerial"`
+	NewNotAfter time.Time `json:"newNotAfter"`
+	// Path is the directory holding the old root, the link certificates, and the trust bundle.
+	// It's under the CA directory's rollovers/ directory, named by the new root's serial number.
+	Path string `json:"-"`
+}
+
+// NewWithOldFile returns the path of the new root's certificate signed by the old root.
+// Clients that only trust the old root can use it as an intermediate to validate certificates issued by the new root.
+func (r *CaRollover) NewWithOldFile() string {
+	return filepath.Join(r.Path, rolloverNewWithOld)
+}
+
+// OldWithNewFile returns the path of the old root's certificate signed by the new root.
+// Clients that only trust the new root can use it as an intermediate to validate certificates issued by the old root.
+func (r *CaRollover) OldWithNewFile() string {
+	return filepath.Join(r.Path, rolloverOldWithNew)
+}
+
+// CrlFile returns the path of the latest CRL signed by the old root, listing the revoked certificates it issued.
+func (r *CaRollover) CrlFile() string {
+	return filepath.Join(r.Path, rolloverOldCrlFile)
+}
+
+// OldKeyFile returns the path of the old root's key.
+func (r *CaRollover) OldKeyFile() string {
+	return filepath.Join(r.Path, rolloverOldKeyFile)
+}
+
+// TrustBundleFile returns the path of the PEM bundle holding both roots, to distribute while clients move to the new root.
+func (r *CaRollover) TrustBundleFile() string {
+	return filepath.Join(r.Path, rolloverTrustBundle)
+}
+
+// Rollover replaces the directory's root with a newly generated successor. The successor keeps the old root's subject,
+// key type, SANs, path length, name constraints, and lifetime unless the options override them.
+// The old root and key are kept under rollovers/, along with link certificates that cross-sign each root with the other,
+// and a trust bundle holding both roots. Both link certificates are recorded in the index.
+// The passphrase is used to decrypt the current CA key. If the current key is encrypted, the new key is encrypted
+// with the same passphrase, unless CaEncryptKey gives a different one or CaPlaintextKey is given.
+func (d *CaDir) Rollover(passphrase format.PassphraseFunc, opts ...CaCertOpt) (*CaRollover, error) {
+	// The passphrase is kept after decrypting the current key, so it isn't prompted for again to encrypt the new key.
+	passphrase = cachedPassphrase(passphrase)
+	unlock, err := d.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	oldCert, oldKey, err := d.loadCa(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !publicKeysEqual(oldCert.PublicKey, oldKey.Public()) {
+		return nil, ErrKeyCertMismatch
+	}
+	oldKeyPem, err := ioutil.ReadFile(d.KeyFile())
+	if err != nil {
+		return nil, err
+	}
+
+	keyType, keyBits, err := keyTypeOf(oldCert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	caOpts := CaCertOpts{
+		Name:           oldCert.Subject,
+		ExpirationDate: time.Now().Add(oldCert.NotAfter.Sub(oldCert.NotBefore)),
+		IpAddresses:    oldCert.IPAddresses,
+		SANs:           oldCert.DNSNames,
+		URIs:           oldCert.URIs,
+		EmailAddresses: oldCert.EmailAddresses,
+		KeyBits:        keyBits,
+		KeyType:        keyType,
+		KeyKDF:         format.KDFPBKDF2,
+		MaxPathLen:     oldCert.MaxPathLen,
+		NameConstraints: NameConstraints{
+			PermittedDNSDomains:     oldCert.PermittedDNSDomains,
+			ExcludedDNSDomains:      oldCert.ExcludedDNSDomains,
+			PermittedIPRanges:       oldCert.PermittedIPRanges,
+			ExcludedIPRanges:        oldCert.ExcludedIPRanges,
+			PermittedEmailAddresses: oldCert.PermittedEmailAddresses,
+			ExcludedEmailAddresses:  oldCert.ExcludedEmailAddresses,
+			PermittedURIDomains:     oldCert.PermittedURIDomains,
+			ExcludedURIDomains:      oldCert.ExcludedURIDomains,
+		},
+	}
+	if oldCert.MaxPathLen == 0 && !oldCert.MaxPathLenZero {
+		caOpts.MaxPathLen = -1
+	}
+	for _, opt := range opts {
+		opt(&caOpts)
+	}
+	if caOpts.PlaintextKey {
+		caOpts.KeyPassphrase = nil
+	} else if caOpts.KeyPassphrase == nil && isEncryptedKeyPem(oldKeyPem) {
+		caOpts.KeyPassphrase = passphrase
+	}
+	if keyBits == 0 && caOpts.KeyType == KeyTypeRSA {
+		caOpts.KeyBits = 4096
+	}
+
+	newCertDer, newKey, newKeyDer, err := newCaCert(caOpts)
+	if err != nil {
+		return nil, err
+	}
+	newCert, err := x509.ParseCertificate(newCertDer)
+	if err != nil {
+		return nil, err
+	}
+	newWithOld, err := crossSign(newCert, oldCert, oldKey, Validity{}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cross-sign the new root with the old root: %w", err)
+	}
+	// The new-with-old link is issued by the old root, whose CRL isn't the one published at the CA's URLs.
+	oldWithNew, err := crossSign(oldCert, newCert, newKey, Validity{}, d.Config.URLs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cross-sign the old root with the new root: %w", err)
+	}
+
+	rollover := &CaRollover{
+		At:          time.Now().UTC(),
+		OldSerial:   oldCert.SerialNumber.Text(16),
+		OldNotAfter: oldCert.NotAfter.UTC(),
+		NewSerial:   newCert.SerialNumber.Text(16),
+		NewNotAfter: newCert.NotAfter.UTC(),
+	}
+	rollover.Path = filepath.Join(d.Path, caDirRolloversDir, rollover.NewSerial)
+	if err := os.MkdirAll(rollover.Path, 0700); err != nil {
+		return nil, err
+	}
+	files := map[string][]byte{
+		rolloverOldCertFile: format.EncodeCerts(format.EncodingPem, oldCert.Raw),
+		rolloverOldKeyFile:  oldKeyPem,
+		rolloverNewWithOld:  format.EncodeCerts(format.EncodingPem, newWithOld),
+		rolloverOldWithNew:  format.EncodeCerts(format.EncodingPem, oldWithNew),
+		rolloverTrustBundle: format.EncodeCerts(format.EncodingPem, oldCert.Raw, newCertDer),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(rollover.Path, name), data, 0600); err != nil {
+			return nil, err
+		}
+	}
+
+	// The old root is saved above, so if replacing it fails part way, it's restored and the directory is left as it was.
+	entries, err := d.Index()
+	if err != nil {
+		return nil, err
+	}
+	config := d.Config
+	config.Rollovers = append([]CaRollover(nil), d.Config.Rollovers...)
+	if err := d.replaceRoot(rollover, newCertDer, newKeyDer, newWithOld, oldWithNew); err != nil {
+		d.Config = config
+		for _, restoreErr := range []error{
+			writeFileAtomic(d.KeyFile(), oldKeyPem),
+			writeFileAtomic(d.CertFile(), files[rolloverOldCertFile]),
+			d.saveIndex(entries),
+			d.SaveConfig(),
+		} {
+			if restoreErr != nil {
+				return nil, fmt.Errorf("%w, and restoring the old root failed: %v", err, restoreErr)
+			}
+		}
+		os.RemoveAll(rollover.Path)
+		return nil, err
+	}
+	return rollover, nil
+}
+
+// replaceRoot makes the new root the directory's CA, and records the rollover and its link certificates.
+func (d *CaDir) replaceRoot(rollover *CaRollover, newCertDer, newKeyDer, newWithOld, oldWithNew []byte) error {
+	if err := writeFileAtomic(d.KeyFile(), format.EncodePrivateKey(format.EncodingPem, newKeyDer)); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(d.CertFile(), format.EncodeCerts(format.EncodingPem, newCertDer)); err != nil {
+		return err
+	}
+	// The rollover is added before recording the links, so Record finds the old root as the issuer of new-with-old.
+	d.Config.Rollovers = append(d.Config.Rollovers, *rollover)
+	for _, link := range [][]byte{newWithOld, oldWithNew} {
+		if _, err := d.Record(link, rolloverLinkProfile); err != nil {
+			return fmt.Errorf("failed to record link certificate in CA index: %w", err)
+		}
+	}
+
+	// Delta CRLs from the new root can't refer to a full CRL from the old root.
+	d.Config.BaseCrlNumber = 0
+	d.Config.BaseCrlTime = nil
+	return d.SaveConfig()
+}
+
+// isEncryptedKeyPem returns true if the PEM or DER data holds an encrypted private key.
+func isEncryptedKeyPem(data []byte) bool {
+	for _, block := range decodePem(data) {
+		if format.IsEncryptedKey(block) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreviousCaCerts returns the roots that the directory's CA replaced with Rollover, oldest first.
+func (d *CaDir) PreviousCaCerts() ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for _, rollover := range d.Config.Rollovers {
+		cert, err := LoadCertFromFile(filepath.Join(rollover.Path, rolloverOldCertFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load previous CA certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+// caRoot is one of a CA directory's roots, along with the rollover that replaced it, which is nil for the current root.
+type caRoot struct {
+	cert     *x509.Certificate
+	serial   string
+	rollover *CaRollover
+}
+
+// roots returns the current root, followed by the roots it replaced, newest first.
+func (d *CaDir) roots() ([]*caRoot, error) {
+	caCert, err := LoadCertFromFile(d.CertFile())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	previous, err := d.PreviousCaCerts()
+	if err != nil {
+		return nil, err
+	}
+	roots := []*caRoot{{cert: caCert, serial: caCert.SerialNumber.Text(16)}}
+	for i := len(previous) - 1; i >= 0; i-- {
+		roots = append(roots, &caRoot{cert: previous[i], serial: previous[i].SerialNumber.Text(16), rollover: &d.Config.Rollovers[i]})
+	}
+	return roots, nil
+}
+
+// issuerOf returns the root that signed the certificate, or nil if none of them did.
+func issuerOf(cert *x509.Certificate, roots []*caRoot) *caRoot {
+	for _, root := range roots {
+		if cert.CheckSignatureFrom(root.cert) == nil {
+			return root
+		}
+	}
+	return nil
+}
+
+// entryIssuer returns the serial number of the root that issued an index entry. Entries recorded without an issuer
+// are matched against the roots by the stored certificate's signature, and are assumed to be from the current root
+// if that fails.
+func (d *CaDir) entryIssuer(entry *IndexEntry, roots []*caRoot) string {
+	if entry.Issuer != "" {
+		return entry.Issuer
+	}
+	if len(roots) > 1 {
+		if cert, err := LoadCertFromFile(d.IssuedCertFile(entry.Serial)); err == nil {
+			if root := issuerOf(cert, roots); root != nil {
+				return root.serial
+			}
+		}
+	}
+	return roots[0].serial
+}
+
+// cachedPassphrase returns a passphrase source that only reads the passphrase once, so a prompt isn't repeated
+// when the same passphrase is needed for more than one key.
+func cachedPassphrase(passphrase format.PassphraseFunc) format.PassphraseFunc {
+	if passphrase == nil {
+		return nil
+	}
+	var cached []byte
+	return func() ([]byte, error) {
+		if cached == nil {
+			pass, err := passphrase()
+			if err != nil {
+				return nil, err
+			}
+			cached = pass
+		}
+		return cached, nil
+	}
+}
+
+// checkIssued returns ErrNotIssuedByCa unless the certificate was issued by the current root or a previous one.
+func (d *CaDir) checkIssued(cert, caCert *x509.Certificate) error {
+	err := cert.CheckSignatureFrom(caCert)
+	if err == nil {
+		return nil
+	}
+	previous, loadErr := d.PreviousCaCerts()
+	if loadErr != nil {
+		return loadErr
+	}
+	for _, prevCert := range previous {
+		if cert.CheckSignatureFrom(prevCert) == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %v", ErrNotIssuedByCa, err)
+}
@@ -0,0 +1,271 @@
+package business
+
+import (
+	"bytes"
+	"crypto/x509"
+	"github.com/drognisep/certserver/business/format"
+	"golang.org/x/crypto/ocsp"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func loadTestCert(t *testing.T, file string) *x509.Certificate {
+	t.Helper()
+	cert, err := LoadCertFromFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func certPool(certs ...*x509.Certificate) *x509.CertPool {
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	return pool
+}
+
+func TestRolloverChains(t *testing.T) {
+	dir := newTestCaDir(t, CaMaxPathLen(1))
+	oldRoot := loadTestCert(t, dir.CertFile())
+	oldLeaf := issueTestCert(t, dir, "old.example.com")
+
+	rollover, err := dir.Rollover(testPassphrase)
+	if err != nil {
+		t.Fatalf("Rollover: %v", err)
+	}
+	newRoot := loadTestCert(t, dir.CertFile())
+	newLeaf := issueTestCert(t, dir, "new.example.com")
+	newWithOld := loadTestCert(t, rollover.NewWithOldFile())
+	oldWithNew := loadTestCert(t, rollover.OldWithNewFile())
+	bundle, err := LoadCertsFromFile(rollover.TrustBundleFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if newRoot.SerialNumber.Cmp(oldRoot.SerialNumber) == 0 {
+		t.Fatal("expected the root to be replaced")
+	}
+	if newRoot.Subject.CommonName != oldRoot.Subject.CommonName || newRoot.MaxPathLen != oldRoot.MaxPathLen {
+		t.Error("expected the new root to keep the old root's subject and path length")
+	}
+
+	tests := map[string]struct {
+		leaf          *x509.Certificate
+		roots         []*x509.Certificate
+		intermediates []*x509.Certificate
+		valid         bool
+	}{
+		"old leaf with old root":            {leaf: oldLeaf, roots: []*x509.Certificate{oldRoot}, valid: true},
+		"old leaf with new root and link":   {leaf: oldLeaf, roots: []*x509.Certificate{newRoot}, intermediates: []*x509.Certificate{oldWithNew}, valid: true},
+		"old leaf with new root only":       {leaf: oldLeaf, roots: []*x509.Certificate{newRoot}},
+		"new leaf with new root":            {leaf: newLeaf, roots: []*x509.Certificate{newRoot}, valid: true},
+		"new leaf with old root and link":   {leaf: newLeaf, roots: []*x509.Certificate{oldRoot}, intermediates: []*x509.Certificate{newWithOld}, valid: true},
+		"new leaf with old root only":       {leaf: newLeaf, roots: []*x509.Certificate{oldRoot}},
+		"old leaf with trust bundle":        {leaf: oldLeaf, roots: bundle, valid: true},
+		"new leaf with trust bundle":        {leaf: newLeaf, roots: bundle, valid: true},
+		"new leaf with wrong link for root": {leaf: newLeaf, roots: []*x509.Certificate{oldRoot}, intermediates: []*x509.Certificate{oldWithNew}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := tc.leaf.Verify(x509.VerifyOptions{
+				DNSName:       tc.leaf.DNSNames[0],
+				Roots:         certPool(tc.roots...),
+				Intermediates: certPool(tc.intermediates...),
+				KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			if tc.valid && err != nil {
+				t.Fatalf("expected the chain to validate: %v", err)
+			}
+			if !tc.valid && err == nil {
+				t.Fatal("expected the chain not to validate")
+			}
+		})
+	}
+
+	previous, err := dir.PreviousCaCerts()
+	if err != nil {
+		t.Fatalf("PreviousCaCerts: %v", err)
+	}
+	if len(previous) != 1 || !previous[0].Equal(oldRoot) {
+		t.Fatal("expected the old root to be kept as the previous root")
+	}
+	reopened, err := OpenCaDir(dir.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.Config.Rollovers) != 1 || reopened.Config.Rollovers[0].Path != rollover.Path {
+		t.Fatal("expected the rollover to be saved in the config with its path")
+	}
+}
+
+func TestRolloverScopesRevocation(t *testing.T) {
+	dir := newTestCaDir(t)
+	oldRoot := loadTestCert(t, dir.CertFile())
+	oldLeaf := issueTestCert(t, dir, "old.example.com")
+	rollover, err := dir.Rollover(testPassphrase)
+	if err != nil {
+		t.Fatalf("Rollover: %v", err)
+	}
+	newRoot := loadTestCert(t, dir.CertFile())
+	newLeaf := issueTestCert(t, dir, "new.example.com")
+	for _, leaf := range []*x509.Certificate{oldLeaf, newLeaf} {
+		if _, err := dir.Revoke(leaf.SerialNumber.Text(16), ReasonKeyCompromise, time.Now()); err != nil {
+			t.Fatalf("Revoke: %v", err)
+		}
+	}
+
+	if _, err := dir.NewCrl(CrlCaKeyPassphrase(testPassphrase)); err != nil {
+		t.Fatalf("NewCrl: %v", err)
+	}
+	crls := map[string]struct {
+		file   string
+		issuer *x509.Certificate
+		leaf   *x509.Certificate
+	}{
+		"current root": {file: dir.CrlFile(), issuer: newRoot, leaf: newLeaf},
+		"old root":     {file: rollover.CrlFile(), issuer: oldRoot, leaf: oldLeaf},
+	}
+	for name, tc := range crls {
+		t.Run("crl "+name, func(t *testing.T) {
+			der, err := ioutil.ReadFile(tc.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			crl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := crl.CheckSignatureFrom(tc.issuer); err != nil {
+				t.Fatalf("CRL signature: %v", err)
+			}
+			if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(tc.leaf.SerialNumber) != 0 {
+				t.Fatalf("expected only %s on the CRL", tc.leaf.SerialNumber.Text(16))
+			}
+		})
+	}
+
+	responder, err := NewOcspResponder(dir, OcspKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("NewOcspResponder: %v", err)
+	}
+	requests := map[string]struct {
+		leaf   *x509.Certificate
+		issuer *x509.Certificate
+		status int
+	}{
+		"old leaf from old root": {leaf: oldLeaf, issuer: oldRoot, status: ocsp.Revoked},
+		"new leaf from new root": {leaf: newLeaf, issuer: newRoot, status: ocsp.Revoked},
+		"old leaf from new root": {leaf: oldLeaf, issuer: newRoot, status: ocsp.Unknown},
+		"new leaf from old root": {leaf: newLeaf, issuer: oldRoot, status: ocsp.Unknown},
+	}
+	for name, tc := range requests {
+		t.Run("ocsp "+name, func(t *testing.T) {
+			req, err := ocsp.CreateRequest(tc.leaf, tc.issuer, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			respDer, err := responder.Respond(req)
+			if err != nil {
+				t.Fatalf("Respond: %v", err)
+			}
+			resp, err := ocsp.ParseResponse(respDer, tc.issuer)
+			if err != nil {
+				t.Fatalf("ParseResponse: %v", err)
+			}
+			if resp.Status != tc.status {
+				t.Fatalf("expected status %d, got %d", tc.status, resp.Status)
+			}
+		})
+	}
+}
+
+func TestRolloverKeyEncryption(t *testing.T) {
+	otherPassphrase := func() ([]byte, error) {
+		return []byte("other passphrase"), nil
+	}
+	tests := map[string]struct {
+		encryptOld bool
+		opts       []CaCertOpt
+		encrypted  bool
+		// passphrase decrypts the new key, if it's encrypted.
+		passphrase format.PassphraseFunc
+	}{
+		"plaintext stays plaintext":      {},
+		"encrypted reuses passphrase":    {encryptOld: true, encrypted: true, passphrase: testPassphrase},
+		"encrypted with new passphrase":  {encryptOld: true, opts: []CaCertOpt{CaEncryptKey(otherPassphrase, format.KDFScrypt)}, encrypted: true, passphrase: otherPassphrase},
+		"encryption turned off":          {encryptOld: true, opts: []CaCertOpt{CaPlaintextKey()}},
+		"plaintext encrypted on request": {opts: []CaCertOpt{CaEncryptKey(otherPassphrase, format.KDFScrypt)}, encrypted: true, passphrase: otherPassphrase},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var dirOpts []CaCertOpt
+			if tc.encryptOld {
+				dirOpts = append(dirOpts, CaEncryptKey(testPassphrase, format.KDFScrypt))
+			}
+			dir := newTestCaDir(t, dirOpts...)
+			if _, err := dir.Rollover(testPassphrase, tc.opts...); err != nil {
+				t.Fatalf("Rollover: %v", err)
+			}
+
+			keyPem, err := ioutil.ReadFile(dir.KeyFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isEncryptedKeyPem(keyPem) != tc.encrypted {
+				t.Fatalf("expected the new key to be encrypted: %v", tc.encrypted)
+			}
+			newKey, err := LoadPrivateKeyFromFile(dir.KeyFile(), tc.passphrase)
+			if err != nil {
+				t.Fatalf("failed to load the new key: %v", err)
+			}
+			if !publicKeysEqual(loadTestCert(t, dir.CertFile()).PublicKey, newKey.Public()) {
+				t.Fatal("the new key doesn't match the new root")
+			}
+		})
+	}
+}
+
+func TestRolloverFailureRestoresRoot(t *testing.T) {
+	dir := newTestCaDir(t)
+	issueTestCert(t, dir, "www.example.com")
+	files := map[string][]byte{}
+	for _, file := range []string{dir.CertFile(), dir.KeyFile(), filepath.Join(dir.Path, caDirIndexFile), filepath.Join(dir.Path, caDirConfigFile)} {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[file] = data
+	}
+	// Replacing the certs directory with a file makes recording the link certificates fail after the root is replaced.
+	certsDir := filepath.Join(dir.Path, caDirCertsDir)
+	if err := os.RemoveAll(certsDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(certsDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dir.Rollover(testPassphrase); err == nil {
+		t.Fatal("expected the rollover to fail")
+	}
+	for file, want := range files {
+		got, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("expected %s to be restored", filepath.Base(file))
+		}
+	}
+	if len(dir.Config.Rollovers) != 0 {
+		t.Error("expected the rollover to be removed from the config")
+	}
+	if rollovers, err := ioutil.ReadDir(filepath.Join(dir.Path, caDirRolloversDir)); err != nil || len(rollovers) != 0 {
+		t.Errorf("expected the rollover directory to be removed, got %d (%v)", len(rollovers), err)
+	}
+}
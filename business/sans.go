@@ -0,0 +1,116 @@
+package business
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const spiffeScheme = "spiffe"
+
+var ErrInvalidSAN = errors.New("invalid subject alternative name")
+
+var (
+	spiffeTrustDomain = regexp.MustCompile(`^[a-z0-9._-]+$`)
+	spiffePathSegment = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// ParseURISAN parses and validates a URI SAN, such as a SPIFFE ID like 'spiffe://cluster.local/ns/default/sa/web'.
+func ParseURISAN(rawURI string) (*url.URL, error) {
+	uri, err := url.Parse(rawURI)
+	if err != nil {
+		return nil, fmt.Errorf("%w: URI '%s': %v", ErrInvalidSAN, rawURI, err)
+	}
+	if err := validateURISAN(uri); err != nil {
+		return nil, err
+	}
+	return uri, nil
+}
+
+// ValidateEmailSAN checks that an email SAN is a bare ASCII address such as 'user@example.com'.
+func ValidateEmailSAN(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Name != "" || addr.Address != email || !isASCII(email) {
+		return fmt.Errorf("%w: '%s' is not an email address such as 'user@example.com'", ErrInvalidSAN, email)
+	}
+	return nil
+}
+
+// validateSANs checks URI and email SANs, and that there's at most one SPIFFE ID, as X509-SVIDs require.
+func validateSANs(uris []*url.URL, emails []string) error {
+	spiffeIds := 0
+	for _, uri := range uris {
+		if err := validateURISAN(uri); err != nil {
+			return err
+		}
+		if uri.Scheme == spiffeScheme {
+			spiffeIds++
+		}
+	}
+	if spiffeIds > 1 {
+		return fmt.Errorf("%w: only one SPIFFE ID is allowed in a certificate", ErrInvalidSAN)
+	}
+	for _, email := range emails {
+		if err := ValidateEmailSAN(email); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateURISAN(uri *url.URL) error {
+	rawURI := uri.String()
+	if uri.Scheme == "" || (uri.Host == "" && uri.Opaque == "") {
+		return fmt.Errorf("%w: URI '%s' must be absolute, such as 'spiffe://example.org/service'", ErrInvalidSAN, rawURI)
+	}
+	if !isASCII(rawURI) {
+		return fmt.Errorf("%w: URI '%s' must be ASCII", ErrInvalidSAN, rawURI)
+	}
+	if uri.Scheme == spiffeScheme {
+		return validateSpiffeID(uri)
+	}
+	return nil
+}
+
+// validateSpiffeID applies the SPIFFE ID rules: a lower case trust domain with no port or user info,
+// and a path of non-empty segments other than '.' and '..', with no query or fragment.
+func validateSpiffeID(uri *url.URL) error {
+	invalid := func(reason string) error {
+		return fmt.Errorf("%w: SPIFFE ID '%s' %s", ErrInvalidSAN, uri, reason)
+	}
+	switch {
+	case uri.Opaque != "":
+		return invalid("must start with 'spiffe://'")
+	case uri.User != nil:
+		return invalid("must not have user info")
+	case uri.Port() != "":
+		return invalid("must not have a port")
+	case !spiffeTrustDomain.MatchString(uri.Host):
+		return invalid("must have a trust domain of lower case letters, digits, '.', '-', and '_'")
+	case uri.RawQuery != "" || uri.ForceQuery:
+		return invalid("must not have a query")
+	case uri.Fragment != "":
+		return invalid("must not have a fragment")
+	}
+	if uri.Path == "" {
+		return nil
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(uri.Path, "/"), "/") {
+		if segment == "." || segment == ".." || !spiffePathSegment.MatchString(segment) {
+			return invalid("must have a path of non-empty segments of letters, digits, '.', '-', and '_', other than '.' and '..'")
+		}
+	}
+	return nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
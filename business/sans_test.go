@@ -0,0 +1,110 @@
+package business
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestParseURISAN(t *testing.T) {
+	tests := map[string]bool{
+		"spiffe://cluster.local/ns/default/sa/web":      true,
+		"spiffe://example.org":                          true,
+		"https://example.com/service":                   true,
+		"urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6": true,
+		"/service":                      false,
+		"spiffe://Example.org/web":      false,
+		"spiffe://example.org:8443/web": false,
+		"spiffe://user@example.org/web": false,
+		"spiffe://example.org/web?x=1":  false,
+		"spiffe://example.org/web#top":  false,
+		"spiffe://example.org//web":     false,
+		"spiffe://example.org/../web":   false,
+		"spiffe:example.org/web":        false,
+	}
+	for rawURI, valid := range tests {
+		_, err := ParseURISAN(rawURI)
+		if valid && err != nil {
+			t.Errorf("ParseURISAN(%s): %v", rawURI, err)
+		}
+		if !valid && !errors.Is(err, ErrInvalidSAN) {
+			t.Errorf("ParseURISAN(%s): expected ErrInvalidSAN, got %v", rawURI, err)
+		}
+	}
+}
+
+func TestValidateEmailSAN(t *testing.T) {
+	tests := map[string]bool{
+		"user@example.com":           true,
+		"first.last+tag@example.com": true,
+		"User <user@example.com>":    false,
+		"example.com":                false,
+		"usér@example.com":           false,
+		"":                           false,
+	}
+	for email, valid := range tests {
+		err := ValidateEmailSAN(email)
+		if valid != (err == nil) || (!valid && !errors.Is(err, ErrInvalidSAN)) {
+			t.Errorf("ValidateEmailSAN(%s): unexpected error %v", email, err)
+		}
+	}
+}
+
+func TestURIAndEmailSANs(t *testing.T) {
+	spiffeId := mustParseURISAN(t, "spiffe://example.org/ns/default/sa/web")
+	dir := newTestCaDir(t, CaURI(mustParseURISAN(t, "spiffe://example.org")), CaEmailAddress("ca@example.org"))
+	caCert, err := LoadCertFromFile(dir.CertFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(caCert.URIs) != 1 || len(caCert.EmailAddresses) != 1 {
+		t.Fatalf("expected the CA to have a URI and an email SAN, got %v and %v", caCert.URIs, caCert.EmailAddresses)
+	}
+
+	csrFile, _ := newTestCsr(t, "web.example.org", CsrAddURI(spiffeId), CsrAddEmail("web@example.org"))
+	der, _, err := SignCsr(csrFile, dir.CertFile(), dir.KeyFile(), BuiltinProfiles()[ProfileClient], SignRecordIn(dir), SignCaKeyPassphrase(testPassphrase))
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.URIs) != 1 || cert.URIs[0].String() != spiffeId.String() {
+		t.Fatalf("expected the SPIFFE ID in the certificate, got %v", cert.URIs)
+	}
+	if len(cert.EmailAddresses) != 1 || cert.EmailAddresses[0] != "web@example.org" {
+		t.Fatalf("expected the email SAN in the certificate, got %v", cert.EmailAddresses)
+	}
+	entry, err := dir.Lookup(cert.SerialNumber.Text(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entry.URIs) != 1 || len(entry.EmailAddresses) != 1 {
+		t.Fatalf("expected the SANs in the index, got %v and %v", entry.URIs, entry.EmailAddresses)
+	}
+
+	tests := map[string][]CsrOpt{
+		"two SPIFFE IDs": {CsrAddURI(spiffeId), CsrAddURI(mustParseURISAN(t, "spiffe://example.org/other"))},
+		"relative URI":   {CsrAddURI(&url.URL{Path: "web"})},
+		"bad email":      {CsrAddEmail("Web <web@example.org>")},
+	}
+	for name, opts := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, _, err := NewGeneratedCsr("web", pkix.Name{}, append(opts, CsrKeyType(KeyTypeECDSAP256))...); !errors.Is(err, ErrInvalidSAN) {
+				t.Fatalf("expected ErrInvalidSAN, got %v", err)
+			}
+		})
+	}
+}
+
+func mustParseURISAN(t *testing.T, rawURI string) *url.URL {
+	t.Helper()
+	uri, err := ParseURISAN(rawURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return uri
+}
@@ -1,56 +1,185 @@
 package business
 
 import (
-	"crypto/rand"
+	"crypto"
 	"crypto/x509"
 	"errors"
 	"fmt"
+	"github.com/drognisep/certserver/business/format"
 	"io/ioutil"
 	"time"
 )
 
-type CertType int
-
-const (
-	CertTypeServerAuth CertType = iota
-	CertTypeCA
-	CertTypeClientAuth
+var (
+	ErrNotACsr = errors.New("the file is not in a known format or does not contain a certificate request")
 )
 
-func SignCsr(csrFile, caCertFile, caKeyFile string, certType CertType) ([]byte, string, error) {
-	csrFileBytes, err := ioutil.ReadFile(csrFile)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read CSR file '%s': %w", csrFile, err)
+type signOpts struct {
+	keyPolicy       KeyStrengthPolicy
+	caKeyPassphrase format.PassphraseFunc
+	caDir           *CaDir
+	maxPathLen      int
+	maxPathLenSet   bool
+	nameConstraints NameConstraints
+	validity        Validity
+	policies        []*IssuancePolicy
+	extensions      []CustomExtension
+	certPolicies    []CertPolicy
+	mustStaple      bool
+	urls            *CaURLs
+}
+
+type SignOpt func(opts *signOpts)
+
+// SignKeyPolicy replaces DefaultKeyStrengthPolicy when checking the CSR's public key.
+func SignKeyPolicy(policy KeyStrengthPolicy) SignOpt {
+	return func(opts *signOpts) {
+		opts.keyPolicy = policy
 	}
-	caCertBytes, err := ioutil.ReadFile(caCertFile)
-	if err != nil {
-		return nil, "", fmt.Errorf("failed to read CA certificate '%s': %w", caCertFile, err)
+}
+
+// SignCaKeyPassphrase sets the source of the passphrase used if the CA key is encrypted.
+// By default, the user is prompted for it.
+func SignCaKeyPassphrase(passphrase format.PassphraseFunc) SignOpt {
+	return func(opts *signOpts) {
+		opts.caKeyPassphrase = passphrase
+	}
+}
+
+// SignRecordIn records the issued certificate in the CA directory's index, and enforces the directory's issuance policy.
+func SignRecordIn(caDir *CaDir) SignOpt {
+	return func(opts *signOpts) {
+		opts.caDir = caDir
+	}
+}
+
+// SignMaxPathLen sets the number of CAs allowed below an issued CA, where -1 is unlimited.
+// By default, it's one less than the issuing CA's path length, or unlimited if the issuing CA has none.
+func SignMaxPathLen(maxPathLen int) SignOpt {
+	return func(opts *signOpts) {
+		opts.maxPathLen = maxPathLen
+		opts.maxPathLenSet = true
+	}
+}
+
+// SignNameConstraints limits the names an issued CA may issue certificates for.
+func SignNameConstraints(constraints NameConstraints) SignOpt {
+	return func(opts *signOpts) {
+		opts.nameConstraints = constraints
+	}
+}
+
+// SignValidity sets the validity period. By default, the profile's validity period is used, clamped to the issuing CA's expiration.
+func SignValidity(validity Validity) SignOpt {
+	return func(opts *signOpts) {
+		opts.validity = validity
 	}
-	caKeyBytes, err := ioutil.ReadFile(caKeyFile)
+}
+
+// SignPolicy enforces an issuance policy on the CSR. May be given more than once, and every policy must be satisfied.
+func SignPolicy(policy *IssuancePolicy) SignOpt {
+	return func(opts *signOpts) {
+		opts.policies = append(opts.policies, policy)
+	}
+}
+
+// SignExtension adds a custom extension, replacing an extension of the profile with the same OID.
+func SignExtension(extension CustomExtension) SignOpt {
+	return func(opts *signOpts) {
+		opts.extensions = append(opts.extensions, extension)
+	}
+}
+
+// SignCertPolicy adds a certificate policy, in addition to the profile's policies.
+func SignCertPolicy(policy CertPolicy) SignOpt {
+	return func(opts *signOpts) {
+		opts.certPolicies = append(opts.certPolicies, policy)
+	}
+}
+
+// SignMustStaple adds the TLS feature extension, which requires the server to staple an OCSP response.
+func SignMustStaple() SignOpt {
+	return func(opts *signOpts) {
+		opts.mustStaple = true
+	}
+}
+
+// SignURLs sets the OCSP, CA issuer, and CRL distribution point URLs embedded in the certificate.
+// By default, a CA directory's URLs are used with SignRecordIn, and none are embedded otherwise.
+func SignURLs(urls *CaURLs) SignOpt {
+	return func(opts *signOpts) {
+		opts.urls = urls
+	}
+}
+
+// LoadCsrFromFile reads the first PEM or DER encoded certificate request in the file.
+func LoadCsrFromFile(filepath string) (*x509.CertificateRequest, error) {
+	fileBytes, err := ioutil.ReadFile(filepath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to read CA key '%s': %w", caKeyFile, err)
+		return nil, err
+	}
+	for _, block := range decodePem(fileBytes) {
+		csr, err := x509.ParseCertificateRequest(block)
+		if err != nil {
+			continue
+		}
+		return csr, nil
+	}
+	return nil, ErrNotACsr
+}
+
+// SignCsr issues a certificate for the CSR with the key usages, extensions, and validity of the profile.
+// The built-in server profile is used if the profile is nil.
+func SignCsr(csrFile, caCertFile, caKeyFile string, profile *Profile, opts ...SignOpt) ([]byte, string, error) {
+	if profile == nil {
+		profile = BuiltinProfiles()[ProfileServer]
+	}
+	_signOpts := &signOpts{
+		keyPolicy:       DefaultKeyStrengthPolicy,
+		caKeyPassphrase: format.PassphrasePrompt("CA key passphrase", false),
+	}
+	for _, opt := range opts {
+		opt(_signOpts)
 	}
 
-	csr, err := x509.ParseCertificateRequest(csrFileBytes)
+	csr, err := LoadCsrFromFile(csrFile)
 	if err != nil {
-		return nil, "", err
+		return nil, "", fmt.Errorf("failed to load CSR file '%s': %w", csrFile, err)
 	}
-	caCert, err := x509.ParseCertificate(caCertBytes)
+	caCert, err := LoadCertFromFile(caCertFile)
 	if err != nil {
-		return nil, "", err
+		return nil, "", fmt.Errorf("failed to load CA certificate '%s': %w", caCertFile, err)
 	}
-	caKey, err := x509.ParsePKCS1PrivateKey(caKeyBytes)
+	caKey, err := LoadPrivateKeyFromFile(caKeyFile, _signOpts.caKeyPassphrase)
 	if err != nil {
-		return nil, "", err
+		return nil, "", fmt.Errorf("failed to load CA key '%s': %w", caKeyFile, err)
 	}
 
 	if !caCert.IsCA {
 		return nil, "", fmt.Errorf("file '%s' is not a CA cert", caCertFile)
 	}
 
+	urls := _signOpts.urls
+	if urls == nil && _signOpts.caDir != nil {
+		urls = _signOpts.caDir.Config.URLs
+	}
+	if err := urls.Validate(); err != nil {
+		return nil, "", err
+	}
+
 	if err := csr.CheckSignature(); err != nil {
 		return nil, "", fmt.Errorf("error checking CSR signature: %w", err)
 	}
+	if err := profile.checkSANs(csr); err != nil {
+		return nil, "", err
+	}
+	policies := issuancePolicies(_signOpts.policies, _signOpts.caDir)
+	if err := checkRequest(csr, _signOpts.keyPolicy, policies); err != nil {
+		return nil, "", err
+	}
+	if !profile.IsCA && (_signOpts.maxPathLenSet || !_signOpts.nameConstraints.IsEmpty()) {
+		return nil, "", ErrCaOnlyConstraint
+	}
 
 	serial, err := generateSerialNumber()
 	if err != nil {
@@ -58,43 +187,99 @@ func SignCsr(csrFile, caCertFile, caKeyFile string, certType CertType) ([]byte,
 	}
 
 	template := &x509.Certificate{
-		SerialNumber: serial,
-		Subject:      csr.Subject,
-		DNSNames:     csr.DNSNames,
-		IPAddresses:  csr.IPAddresses,
-		NotBefore:    time.Now(),
+		SerialNumber:   serial,
+		Subject:        csr.Subject,
+		DNSNames:       csr.DNSNames,
+		IPAddresses:    csr.IPAddresses,
+		EmailAddresses: csr.EmailAddresses,
+		URIs:           csr.URIs,
 	}
 	template.Subject.SerialNumber = serial.String()
+	for _, policy := range policies {
+		policy.apply(template)
+	}
+	if err := profile.apply(template); err != nil {
+		return nil, "", err
+	}
+	if len(_signOpts.extensions) > 0 || len(_signOpts.certPolicies) > 0 || _signOpts.mustStaple {
+		certPolicies := append(append([]CertPolicy{}, profile.Policies...), _signOpts.certPolicies...)
+		if err := applyExtensions(template, _signOpts.extensions, certPolicies, _signOpts.mustStaple, true); err != nil {
+			return nil, "", err
+		}
+	}
+	if profile.IsCA {
+		maxPathLen, maxPathLenSet := _signOpts.maxPathLen, _signOpts.maxPathLenSet
+		if !maxPathLenSet && profile.MaxPathLen != nil {
+			maxPathLen, maxPathLenSet = *profile.MaxPathLen, true
+		}
+		maxPathLen, err := subCaPathLen(caCert, maxPathLen, maxPathLenSet)
+		if err != nil {
+			return nil, "", err
+		}
+		applyMaxPathLen(template, maxPathLen)
+		if err := _signOpts.nameConstraints.apply(template); err != nil {
+			return nil, "", err
+		}
+	}
 
-	switch certType {
-	case CertTypeServerAuth:
-		template.NotAfter = time.Now().AddDate(0, 3, 0)
-		template.KeyUsage = x509.KeyUsageDigitalSignature
-		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
-	case CertTypeClientAuth:
-		template.NotAfter = time.Now().AddDate(0, 0, 30)
-		template.KeyUsage = x509.KeyUsageDigitalSignature
-		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
-	case CertTypeCA:
-		template.NotAfter = time.Now().AddDate(0, 6, 0)
-		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign
-		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
-	default:
-		return nil, "", errors.New("unknown certificate type")
-	}
-
-	cert, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+	profileValidity, err := profile.validity()
+	if err != nil {
+		return nil, "", err
+	}
+	template.NotBefore, template.NotAfter, err = _signOpts.validity.period(caCert, func(start time.Time) time.Time {
+		return start.Add(profileValidity)
+	})
 	if err != nil {
 		return nil, "", err
 	}
 
-	newCert, err := x509.ParseCertificate(cert)
+	urls.apply(template)
+
+	cert, newCert, err := issueCert(template, caCert, csr.PublicKey, caKey)
 	if err != nil {
 		return nil, "", err
 	}
-	if err := newCert.CheckSignatureFrom(caCert); err != nil {
-		return nil, "", fmt.Errorf("unable to verify CA signature: %w", err)
+	if _signOpts.caDir != nil {
+		if _, err := _signOpts.caDir.Record(cert, profile.Name); err != nil {
+			return nil, "", fmt.Errorf("failed to record certificate in CA index: %w", err)
+		}
 	}
 
 	return cert, newCert.Subject.CommonName, nil
 }
+
+// issuancePolicies returns the policies given as options, along with the CA directory's policy if it has one.
+func issuancePolicies(policies []*IssuancePolicy, caDir *CaDir) []*IssuancePolicy {
+	if caDir != nil && caDir.Config.Policy != nil {
+		policies = append(append([]*IssuancePolicy{}, policies...), caDir.Config.Policy)
+	}
+	return policies
+}
+
+// checkRequest runs the checks that every issued certificate's request must pass: the key strength policy,
+// the syntax of URI and email SANs, and the issuance policies.
+func checkRequest(request *x509.CertificateRequest, keyPolicy KeyStrengthPolicy, policies []*IssuancePolicy) error {
+	if err := keyPolicy.Check(request.PublicKey); err != nil {
+		return fmt.Errorf("public key rejected: %w", err)
+	}
+	if err := validateSANs(request.URIs, request.EmailAddresses); err != nil {
+		return err
+	}
+	return checkPolicies(request, policies)
+}
+
+// issueCert signs the certificate, and checks that its names are within the issuing CA's name constraints.
+func issueCert(template, caCert *x509.Certificate, pub crypto.PublicKey, caKey crypto.Signer) ([]byte, *x509.Certificate, error) {
+	cert, err := createVerifiedCert(template, caCert, pub, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	newCert, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkIssuerNameConstraints(newCert, caCert); err != nil {
+		return nil, nil, err
+	}
+	return cert, newCert, nil
+}
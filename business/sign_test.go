@@ -0,0 +1,64 @@
+package business
+
+import (
+	"encoding/pem"
+	"errors"
+	"github.com/drognisep/certserver/business/format"
+	"io/ioutil"
+	"testing"
+)
+
+// reencodeTestFile rewrites a DER file in the encoding, with encode choosing the PEM block type.
+func reencodeTestFile(t *testing.T, file string, enc format.Encoding, encode func(format.Encoding, []byte) []byte) string {
+	t.Helper()
+	der, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return writeTestFile(t, "reencoded", encode(enc, der))
+}
+
+func TestSignCsrInputEncodings(t *testing.T) {
+	encodeCert := func(enc format.Encoding, der []byte) []byte {
+		return format.EncodeCerts(enc, der)
+	}
+	for _, enc := range []format.Encoding{format.EncodingDer, format.EncodingPem} {
+		t.Run(enc.String(), func(t *testing.T) {
+			caCertFile, caKeyFile := newTestCa(t)
+			csrFile, _ := newTestCsr(t, "www.example.com")
+			cert, err := signTestCsr(t,
+				reencodeTestFile(t, csrFile, enc, format.EncodeCSR),
+				reencodeTestFile(t, caCertFile, enc, encodeCert),
+				reencodeTestFile(t, caKeyFile, enc, format.EncodePrivateKey),
+			)
+			if err != nil {
+				t.Fatalf("SignCsr: %v", err)
+			}
+			if cert.Subject.CommonName != "www.example.com" {
+				t.Fatalf("unexpected subject %s", cert.Subject)
+			}
+		})
+	}
+}
+
+func TestLoadCsrFromFile(t *testing.T) {
+	csrFile, keyFile := newTestCsr(t, "www.example.com")
+	pemFile := reencodeTestFile(t, csrFile, format.EncodingPem, format.EncodeCSR)
+	pemData, err := ioutil.ReadFile(pemFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(pemData); block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Fatal("expected a CERTIFICATE REQUEST PEM block")
+	}
+	csr, err := LoadCsrFromFile(pemFile)
+	if err != nil {
+		t.Fatalf("LoadCsrFromFile: %v", err)
+	}
+	if csr.Subject.CommonName != "www.example.com" {
+		t.Fatalf("unexpected subject %s", csr.Subject)
+	}
+	if _, err := LoadCsrFromFile(keyFile); !errors.Is(err, ErrNotACsr) {
+		t.Fatalf("expected ErrNotACsr for a key, got %v", err)
+	}
+}
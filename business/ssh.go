@@ -0,0 +1,276 @@
+package business
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/drognisep/certserver/business/format"
+	"golang.org/x/crypto/ssh"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+type SshCertType uint32
+
+const (
+	SshCertTypeUser = SshCertType(ssh.UserCert)
+	SshCertTypeHost = SshCertType(ssh.HostCert)
+)
+
+var (
+	ErrNoPrincipals = errors.New("at least one principal is required")
+)
+
+// DefaultSshUserExtensions are the extensions that ssh-keygen adds to user certificates by default.
+var DefaultSshUserExtensions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+type sshSignOpts struct {
+	certType        SshCertType
+	keyId           string
+	principals      []string
+	validAfter      time.Time
+	validBefore     time.Time
+	criticalOptions map[string]string
+	extensions      map[string]string
+	// noDefaultExtensions leaves out DefaultSshUserExtensions from user certificates.
+	noDefaultExtensions bool
+	caKeyPassphrase     format.PassphraseFunc
+}
+
+type SshSignOpt func(opts *sshSignOpts)
+
+// SshHostCert issues a host certificate instead of a user certificate. Host certificates have no default extensions.
+func SshHostCert() SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.certType = SshCertTypeHost
+	}
+}
+
+// SshKeyId sets the certificate's key ID, which is logged by sshd. Default is the first principal.
+func SshKeyId(keyId string) SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.keyId = keyId
+	}
+}
+
+// SshPrincipal adds a user name or host name that the certificate is valid for.
+func SshPrincipal(principal string) SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.principals = append(opts.principals, principal)
+	}
+}
+
+// SshValidity sets the validity period, starting at validAfter.
+func SshValidity(validAfter time.Time, validFor time.Duration) SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.validAfter = validAfter
+		opts.validBefore = validAfter.Add(validFor)
+	}
+}
+
+// SshCriticalOption adds a critical option, such as "force-command" or "source-address".
+func SshCriticalOption(name, value string) SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.criticalOptions[name] = value
+	}
+}
+
+// SshExtension adds an extension, such as "permit-pty".
+func SshExtension(name, value string) SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.extensions[name] = value
+	}
+}
+
+// SshClearExtensions removes any extensions added so far, including the defaults for user certificates.
+func SshClearExtensions() SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.extensions = map[string]string{}
+		opts.noDefaultExtensions = true
+	}
+}
+
+// SshCaKeyPassphrase sets the source of the passphrase used if the CA key is encrypted.
+func SshCaKeyPassphrase(passphrase format.PassphraseFunc) SshSignOpt {
+	return func(opts *sshSignOpts) {
+		opts.caKeyPassphrase = passphrase
+	}
+}
+
+// SignSshKey issues an OpenSSH certificate for the public key, signed by the CA key.
+// The public key may be in authorized_keys format, or any key or certificate format the format command reads.
+// The CA key may be a key from root-ca, or an OpenSSH private key. The certificate is returned in authorized_keys format.
+func SignSshKey(pubKeyFile, caKeyFile string, opts ...SshSignOpt) ([]byte, error) {
+	now := time.Now()
+	_sshOpts := &sshSignOpts{
+		certType:        SshCertTypeUser,
+		validAfter:      now,
+		validBefore:     now.Add(24 * time.Hour),
+		criticalOptions: map[string]string{},
+		extensions:      map[string]string{},
+		caKeyPassphrase: format.PassphrasePrompt("CA key passphrase", false),
+	}
+	for _, opt := range opts {
+		opt(_sshOpts)
+	}
+	// The defaults are added once the certificate type is known, and don't replace extensions that were set explicitly.
+	if _sshOpts.certType == SshCertTypeUser && !_sshOpts.noDefaultExtensions {
+		for name, value := range DefaultSshUserExtensions {
+			if _, ok := _sshOpts.extensions[name]; !ok {
+				_sshOpts.extensions[name] = value
+			}
+		}
+	}
+	if len(_sshOpts.principals) == 0 {
+		return nil, ErrNoPrincipals
+	}
+	if _sshOpts.keyId == "" {
+		_sshOpts.keyId = _sshOpts.principals[0]
+	}
+
+	pubKey, comment, err := LoadSshPublicKeyFromFile(pubKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load public key '%s': %w", pubKeyFile, err)
+	}
+	caSigner, err := loadSshSigner(caKeyFile, _sshOpts.caKeyPassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA key '%s': %w", caKeyFile, err)
+	}
+
+	var serialBytes [8]byte
+	if _, err := rand.Read(serialBytes[:]); err != nil {
+		return nil, err
+	}
+	cert := &ssh.Certificate{
+		Key:             pubKey,
+		Serial:          binary.BigEndian.Uint64(serialBytes[:]),
+		CertType:        uint32(_sshOpts.certType),
+		KeyId:           _sshOpts.keyId,
+		ValidPrincipals: _sshOpts.principals,
+		ValidAfter:      uint64(_sshOpts.validAfter.Unix()),
+		ValidBefore:     uint64(_sshOpts.validBefore.Unix()),
+		Permissions: ssh.Permissions{
+			CriticalOptions: _sshOpts.criticalOptions,
+			Extensions:      _sshOpts.extensions,
+		},
+	}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		return nil, err
+	}
+
+	line := bytes.TrimSpace(ssh.MarshalAuthorizedKey(cert))
+	if comment != "" {
+		line = append(line, ' ')
+		line = append(line, comment...)
+	}
+	return append(line, '\n'), nil
+}
+
+// SshCaPublicKeyLine formats the CA's public key for sshd's TrustedUserCAKeys file, or as a known_hosts
+// @cert-authority line if host patterns are given. The CA file may be a certificate, public key, or private key.
+func SshCaPublicKeyLine(caFile string, hostPatterns []string, passphrase format.PassphraseFunc) ([]byte, error) {
+	pubKey, comment, err := LoadSshPublicKeyFromFile(caFile)
+	if err != nil && passphrase != nil {
+		var signer ssh.Signer
+		signer, err = loadSshSigner(caFile, passphrase)
+		if err == nil {
+			pubKey = signer.PublicKey()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA public key '%s': %w", caFile, err)
+	}
+
+	line := string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(pubKey)))
+	if comment != "" {
+		line += " " + comment
+	}
+	if len(hostPatterns) > 0 {
+		line = fmt.Sprintf("@cert-authority %s %s", strings.Join(hostPatterns, ","), line)
+	}
+	return []byte(line + "\n"), nil
+}
+
+// LoadSshPublicKeyFromFile reads a public key in authorized_keys format, or from a certificate, public key,
+// or unencrypted private key. Certificates use their subject common name as the key comment.
+func LoadSshPublicKeyFromFile(filepath string) (ssh.PublicKey, string, error) {
+	fileBytes, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return nil, "", err
+	}
+	_, objects, err := format.Detect(fileBytes)
+	if err != nil {
+		return nil, "", err
+	}
+	objects, err = format.ExpandPKCS7(objects)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, obj := range objects {
+		var (
+			pub     crypto.PublicKey
+			comment string
+		)
+		switch {
+		case obj.Type == format.ObjectSSHPublicKey:
+			pubKey, err := ssh.ParsePublicKey(obj.Der)
+			if err != nil {
+				return nil, "", err
+			}
+			return pubKey, obj.Comment, nil
+		case obj.Type == format.ObjectCertificate:
+			cert, err := x509.ParseCertificate(obj.Der)
+			if err != nil {
+				return nil, "", err
+			}
+			pub, comment = cert.PublicKey, cert.Subject.CommonName
+		case obj.Type == format.ObjectCSR:
+			csr, err := x509.ParseCertificateRequest(obj.Der)
+			if err != nil {
+				return nil, "", err
+			}
+			pub, comment = csr.PublicKey, csr.Subject.CommonName
+		case obj.Type == format.ObjectPKIXPublicKey:
+			pub, err = x509.ParsePKIXPublicKey(obj.Der)
+		case obj.Type == format.ObjectPKCS1PublicKey:
+			pub, err = x509.ParsePKCS1PublicKey(obj.Der)
+		case obj.Type.IsPrivateKey() && !format.IsEncryptedKey(obj.Der):
+			var priv crypto.Signer
+			priv, err = parsePrivateKey(obj.Der)
+			if err == nil {
+				pub = priv.Public()
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		sshPub, err := ssh.NewPublicKey(pub)
+		if err != nil {
+			return nil, "", err
+		}
+		return sshPub, comment, nil
+	}
+	return nil, "", ErrNoKeyMaterial
+}
+
+// loadSshSigner reads a private key in any format LoadPrivateKeyFromFile supports, including the OpenSSH private key format.
+func loadSshSigner(filepath string, passphrase format.PassphraseFunc) (ssh.Signer, error) {
+	key, err := LoadPrivateKeyFromFile(filepath, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromSigner(key)
+}
@@ -0,0 +1,158 @@
+package business
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestSshKey writes a new Ed25519 public key in authorized_keys format, with the comment.
+func newTestSshKey(t *testing.T, comment string) string {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment + "\n"
+	return writeTestFile(t, "id_ed25519.pub", []byte(line))
+}
+
+func TestSignSshKey(t *testing.T) {
+	_, caKeyFile := newTestCa(t)
+	pubKeyFile := newTestSshKey(t, "user@example.com")
+	validAfter := time.Now().Truncate(time.Second)
+
+	tests := map[string]struct {
+		opts []SshSignOpt
+		// wantErr is checked with errors.Is, and nil expects the signing to succeed.
+		wantErr        error
+		wantType       uint32
+		wantKeyId      string
+		wantExtensions map[string]string
+	}{
+		"user defaults": {
+			opts:           []SshSignOpt{SshPrincipal("alice")},
+			wantType:       ssh.UserCert,
+			wantKeyId:      "alice",
+			wantExtensions: DefaultSshUserExtensions,
+		},
+		"host": {
+			opts:           []SshSignOpt{SshHostCert(), SshPrincipal("host.example.com"), SshKeyId("host")},
+			wantType:       ssh.HostCert,
+			wantKeyId:      "host",
+			wantExtensions: map[string]string{},
+		},
+		"host with extension": {
+			opts:           []SshSignOpt{SshExtension("permit-pty", ""), SshHostCert(), SshPrincipal("host.example.com")},
+			wantType:       ssh.HostCert,
+			wantKeyId:      "host.example.com",
+			wantExtensions: map[string]string{"permit-pty": ""},
+		},
+		"user extension kept": {
+			opts:           []SshSignOpt{SshPrincipal("alice"), SshExtension("permit-pty", "yes")},
+			wantType:       ssh.UserCert,
+			wantKeyId:      "alice",
+			wantExtensions: mergeTestExtensions(DefaultSshUserExtensions, map[string]string{"permit-pty": "yes"}),
+		},
+		"cleared extensions": {
+			opts:           []SshSignOpt{SshPrincipal("alice"), SshClearExtensions(), SshExtension("permit-pty", "")},
+			wantType:       ssh.UserCert,
+			wantKeyId:      "alice",
+			wantExtensions: map[string]string{"permit-pty": ""},
+		},
+		"no principals": {wantErr: ErrNoPrincipals},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			opts := append([]SshSignOpt{SshValidity(validAfter, time.Hour)}, tc.opts...)
+			line, err := SignSshKey(pubKeyFile, caKeyFile, opts...)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignSshKey: %v", err)
+			}
+			pub, comment, _, _, err := ssh.ParseAuthorizedKey(line)
+			if err != nil {
+				t.Fatalf("failed to parse the certificate: %v", err)
+			}
+			if comment != "user@example.com" {
+				t.Fatalf("expected the key's comment to be kept, got '%s'", comment)
+			}
+			cert, ok := pub.(*ssh.Certificate)
+			if !ok {
+				t.Fatalf("expected a certificate, got %s", pub.Type())
+			}
+			if cert.CertType != tc.wantType || cert.KeyId != tc.wantKeyId {
+				t.Fatalf("unexpected certificate type %d and key ID '%s'", cert.CertType, cert.KeyId)
+			}
+			if cert.ValidAfter != uint64(validAfter.Unix()) || cert.ValidBefore != uint64(validAfter.Add(time.Hour).Unix()) {
+				t.Fatal("unexpected validity period")
+			}
+			if len(cert.Extensions) != len(tc.wantExtensions) {
+				t.Fatalf("expected extensions %v, got %v", tc.wantExtensions, cert.Extensions)
+			}
+			for name, value := range tc.wantExtensions {
+				if got, ok := cert.Extensions[name]; !ok || got != value {
+					t.Fatalf("expected extensions %v, got %v", tc.wantExtensions, cert.Extensions)
+				}
+			}
+
+			caLine, err := SshCaPublicKeyLine(caKeyFile, nil, testPassphrase)
+			if err != nil {
+				t.Fatalf("SshCaPublicKeyLine: %v", err)
+			}
+			caPub, _, _, _, err := ssh.ParseAuthorizedKey(caLine)
+			if err != nil {
+				t.Fatal(err)
+			}
+			checker := &ssh.CertChecker{}
+			if err := checker.CheckCert(cert.ValidPrincipals[0], cert); err != nil {
+				t.Fatalf("CheckCert: %v", err)
+			}
+			if string(cert.SignatureKey.Marshal()) != string(caPub.Marshal()) {
+				t.Fatal("expected the certificate to be signed by the CA key")
+			}
+		})
+	}
+}
+
+func TestSshCaPublicKeyLine(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	fromKey, err := SshCaPublicKeyLine(caKeyFile, nil, testPassphrase)
+	if err != nil {
+		t.Fatalf("SshCaPublicKeyLine: %v", err)
+	}
+	if !strings.HasPrefix(string(fromKey), "ecdsa-sha2-nistp256 ") {
+		t.Fatalf("unexpected key line '%s'", fromKey)
+	}
+	fromCert, err := SshCaPublicKeyLine(caCertFile, []string{"*.example.com", "example.com"}, nil)
+	if err != nil {
+		t.Fatalf("SshCaPublicKeyLine: %v", err)
+	}
+	want := "@cert-authority *.example.com,example.com " + strings.TrimSpace(string(fromKey))
+	if !strings.HasPrefix(string(fromCert), want) {
+		t.Fatalf("expected '%s', got '%s'", want, fromCert)
+	}
+}
+
+func mergeTestExtensions(extensions ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, ext := range extensions {
+		for name, value := range ext {
+			merged[name] = value
+		}
+	}
+	return merged
+}
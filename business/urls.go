@@ -0,0 +1,67 @@
+package business
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+var ErrInvalidCaURL = errors.New("invalid CA URL")
+
+// CaURLs are embedded in the certificates a CA issues, so clients can find revocation info and download the CA certificate.
+type CaURLs struct {
+	// OCSP lists the OCSP responders for the CA, such as one run with 'ocsp-serve'. It's added to the Authority Information Access extension.
+	OCSP []string `json:"ocsp,omitempty"`
+	// Issuer lists URLs where the DER CA certificate may be downloaded. It's added to the Authority Information Access extension.
+	Issuer []string `json:"issuer,omitempty"`
+	// CRL lists the CRL distribution points, where the CA's full CRL is published.
+	CRL []string `json:"crl,omitempty"`
+}
+
+// IsEmpty reports whether no URLs are set.
+func (u *CaURLs) IsEmpty() bool {
+	return u == nil || len(u.OCSP) == 0 && len(u.Issuer) == 0 && len(u.CRL) == 0
+}
+
+// Validate checks that every URL is absolute.
+func (u *CaURLs) Validate() error {
+	if u == nil {
+		return nil
+	}
+	for _, urls := range [][]string{u.OCSP, u.Issuer, u.CRL} {
+		for _, rawURL := range urls {
+			parsed, err := url.Parse(rawURL)
+			if err != nil || parsed.Scheme == "" || (parsed.Host == "" && parsed.Opaque == "") {
+				return fmt.Errorf("%w '%s', must be an absolute URL such as 'http://ca.example.com/ca.crl'", ErrInvalidCaURL, rawURL)
+			}
+		}
+	}
+	return nil
+}
+
+// apply sets the URLs on the template, replacing any Authority Information Access or CRL Distribution Points extension
+// copied from another certificate. Nothing is changed if the URLs are nil.
+func (u *CaURLs) apply(template *x509.Certificate) {
+	if u == nil {
+		return
+	}
+	replaceAIA := len(u.OCSP) > 0 || len(u.Issuer) > 0
+	replaceCDP := len(u.CRL) > 0
+	var extensions []pkix.Extension
+	for _, ext := range template.ExtraExtensions {
+		if (replaceAIA && ext.Id.Equal(oidAuthorityInfoAccess)) || (replaceCDP && ext.Id.Equal(oidCrlDistributionPoints)) {
+			continue
+		}
+		extensions = append(extensions, ext)
+	}
+	template.ExtraExtensions = extensions
+	if replaceAIA {
+		template.OCSPServer = u.OCSP
+		template.IssuingCertificateURL = u.Issuer
+	}
+	if replaceCDP {
+		template.CRLDistributionPoints = u.CRL
+	}
+}
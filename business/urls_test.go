@@ -0,0 +1,123 @@
+package business
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestCaURLsValidate(t *testing.T) {
+	tests := map[string]struct {
+		urls    *CaURLs
+		wantErr bool
+	}{
+		"nil":      {},
+		"absolute": {urls: &CaURLs{OCSP: []string{"http://ocsp.example.com"}, CRL: []string{"http://example.com/ca.crl"}}},
+		"ldap":     {urls: &CaURLs{CRL: []string{"ldap://ldap.example.com/cn=CA?certificateRevocationList"}}},
+		"relative": {urls: &CaURLs{Issuer: []string{"/ca.cer"}}, wantErr: true},
+		"no host":  {urls: &CaURLs{OCSP: []string{"http://"}}, wantErr: true},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := tc.urls.Validate()
+			if tc.wantErr != errors.Is(err, ErrInvalidCaURL) || (!tc.wantErr && err != nil) {
+				t.Fatalf("unexpected error %v", err)
+			}
+		})
+	}
+}
+
+func TestSignURLs(t *testing.T) {
+	dir := newTestCaDir(t)
+	dirURLs := &CaURLs{
+		OCSP:   []string{"http://ocsp.example.com"},
+		Issuer: []string{"http://example.com/ca.cer"},
+		CRL:    []string{"http://example.com/ca.crl"},
+	}
+	dir.Config.URLs = dirURLs
+	if err := dir.SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	flagURLs := &CaURLs{CRL: []string{"http://crl.example.com/ca.crl"}}
+
+	tests := map[string]struct {
+		opts []SignOpt
+		want *CaURLs
+	}{
+		"none":         {want: &CaURLs{}},
+		"CA directory": {opts: []SignOpt{SignRecordIn(dir)}, want: dirURLs},
+		"option":       {opts: []SignOpt{SignURLs(flagURLs)}, want: flagURLs},
+		"option first": {opts: []SignOpt{SignRecordIn(dir), SignURLs(flagURLs)}, want: flagURLs},
+		"invalid":      {opts: []SignOpt{SignURLs(&CaURLs{OCSP: []string{"ocsp"}})}},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			csrFile, _ := newTestCsr(t, "www.example.com")
+			opts := append([]SignOpt{SignCaKeyPassphrase(testPassphrase)}, tc.opts...)
+			cert, err := signTestCsr(t, csrFile, dir.CertFile(), dir.KeyFile(), opts...)
+			if tc.want == nil {
+				if !errors.Is(err, ErrInvalidCaURL) {
+					t.Fatalf("expected ErrInvalidCaURL, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SignCsr: %v", err)
+			}
+			got := &CaURLs{OCSP: cert.OCSPServer, Issuer: cert.IssuingCertificateURL, CRL: cert.CRLDistributionPoints}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("expected URLs %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCreateVerifiedCertKeyIds(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t)
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKey, err := LoadPrivateKeyFromFile(caKeyFile, testPassphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The x509 package always gives new CA certificates a subject key ID, so one without it is simulated.
+	caCert.SubjectKeyId = nil
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := createVerifiedCert(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		NotBefore:    caCert.NotBefore,
+		NotAfter:     caCert.NotAfter,
+	}, caCert, key.Public(), caKey)
+	if err != nil {
+		t.Fatalf("createVerifiedCert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantSubject, err := subjectKeyId(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAuthority, err := subjectKeyId(caKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.SubjectKeyId, wantSubject) || !bytes.Equal(cert.AuthorityKeyId, wantAuthority) {
+		t.Fatal("expected the key IDs to be derived from the keys")
+	}
+}
@@ -0,0 +1,113 @@
+package business
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type IssuerExpiry int
+
+const (
+	// IssuerExpiryClamp shortens the validity period to end when the issuing CA's certificate does.
+	IssuerExpiryClamp IssuerExpiry = iota
+	// IssuerExpiryFail refuses to issue a certificate that would outlive the issuing CA's certificate.
+	IssuerExpiryFail
+)
+
+var (
+	ErrOutlivesIssuer      = errors.New("the certificate would expire after the issuing CA's certificate")
+	ErrInvalidValidity     = errors.New("invalid validity period")
+	ErrUnknownIssuerExpiry = errors.New("unknown issuer expiry handling")
+)
+
+var issuerExpiryNames = map[IssuerExpiry]string{
+	IssuerExpiryClamp: "clamp",
+	IssuerExpiryFail:  "fail",
+}
+
+func (e IssuerExpiry) String() string {
+	if name, ok := issuerExpiryNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("IssuerExpiry(%d)", int(e))
+}
+
+func ParseIssuerExpiry(name string) (IssuerExpiry, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for expiry, expiryName := range issuerExpiryNames {
+		if expiryName == name {
+			return expiry, nil
+		}
+	}
+	return 0, fmt.Errorf("%w '%s', must be one of clamp, fail", ErrUnknownIssuerExpiry, name)
+}
+
+// ParseDuration parses a Go duration such as '36h', or a whole number of days such as '90d'.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Validity sets the validity period of an issued certificate. The zero value uses the default period, starting now,
+// and clamps it to the issuing CA's expiration.
+type Validity struct {
+	// Duration is how long the certificate is valid for, measured from the start time. Zero uses the default.
+	Duration time.Duration
+	// Backdate moves NotBefore earlier than the start time, to tolerate clock skew between clients and the CA.
+	Backdate time.Duration
+	// Start is the start of the validity period, which may be in the future. Zero is now.
+	Start time.Time
+	// IssuerExpiry sets what happens when the certificate would outlive the issuing CA's certificate.
+	IssuerExpiry IssuerExpiry
+}
+
+// period returns the NotBefore and NotAfter times for a certificate issued by the issuer.
+// The default function returns NotAfter for the start time if no duration is set.
+// NotBefore is never earlier than the issuer's NotBefore.
+func (v Validity) period(issuer *x509.Certificate, defaultNotAfter func(start time.Time) time.Time) (notBefore, notAfter time.Time, err error) {
+	if v.Duration < 0 || v.Backdate < 0 {
+		return notBefore, notAfter, fmt.Errorf("%w: durations must not be negative", ErrInvalidValidity)
+	}
+	start := v.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	if !start.Before(issuer.NotAfter) {
+		return notBefore, notAfter, fmt.Errorf("%w: the start time %s is not before the issuing CA's expiration at %s",
+			ErrInvalidValidity, start.Format(time.RFC3339), issuer.NotAfter.Format(time.RFC3339))
+	}
+
+	notBefore = start.Add(-v.Backdate)
+	if notBefore.Before(issuer.NotBefore) {
+		notBefore = issuer.NotBefore
+	}
+	if v.Duration > 0 {
+		notAfter = start.Add(v.Duration)
+	} else {
+		notAfter = defaultNotAfter(start)
+	}
+
+	if !notAfter.After(notBefore) {
+		return notBefore, notAfter, fmt.Errorf("%w: the certificate would expire at %s, before it becomes valid",
+			ErrInvalidValidity, notAfter.Format(time.RFC3339))
+	}
+	if notAfter.After(issuer.NotAfter) {
+		if v.IssuerExpiry == IssuerExpiryFail {
+			return notBefore, notAfter, fmt.Errorf("%w: %s is after %s", ErrOutlivesIssuer,
+				notAfter.Format(time.RFC3339), issuer.NotAfter.Format(time.RFC3339))
+		}
+		notAfter = issuer.NotAfter
+	}
+	return notBefore, notAfter, nil
+}
@@ -0,0 +1,116 @@
+package business
+
+import (
+	"crypto/x509"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]struct {
+		want    time.Duration
+		wantErr bool
+	}{
+		"90d":   {want: 90 * 24 * time.Hour},
+		" 36h ": {want: 36 * time.Hour},
+		"1h30m": {want: 90 * time.Minute},
+		"1.5d":  {wantErr: true},
+		"d":     {wantErr: true},
+		"week":  {wantErr: true},
+	}
+	for input, tc := range tests {
+		got, err := ParseDuration(input)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("ParseDuration(%s): expected %s, got %s (%v)", input, tc.want, got, err)
+		}
+	}
+}
+
+func TestParseIssuerExpiry(t *testing.T) {
+	for _, expiry := range []IssuerExpiry{IssuerExpiryClamp, IssuerExpiryFail} {
+		got, err := ParseIssuerExpiry(" " + expiry.String() + " ")
+		if err != nil || got != expiry {
+			t.Errorf("ParseIssuerExpiry(%s): got %s (%v)", expiry, got, err)
+		}
+	}
+	if _, err := ParseIssuerExpiry("extend"); !errors.Is(err, ErrUnknownIssuerExpiry) {
+		t.Fatalf("expected ErrUnknownIssuerExpiry, got %v", err)
+	}
+}
+
+func TestValidityPeriod(t *testing.T) {
+	start := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	issuer := &x509.Certificate{
+		NotBefore: start.AddDate(0, 0, -10),
+		NotAfter:  start.AddDate(1, 0, 0),
+	}
+	defaultNotAfter := func(start time.Time) time.Time {
+		return start.AddDate(0, 0, 30)
+	}
+	tests := map[string]struct {
+		validity      Validity
+		wantNotBefore time.Time
+		wantNotAfter  time.Time
+		// wantErr is checked with errors.Is, and nil expects the period to be valid.
+		wantErr error
+	}{
+		"default":          {validity: Validity{Start: start}, wantNotBefore: start, wantNotAfter: start.AddDate(0, 0, 30)},
+		"duration":         {validity: Validity{Start: start, Duration: 48 * time.Hour}, wantNotBefore: start, wantNotAfter: start.Add(48 * time.Hour)},
+		"backdate":         {validity: Validity{Start: start, Backdate: time.Hour}, wantNotBefore: start.Add(-time.Hour), wantNotAfter: start.AddDate(0, 0, 30)},
+		"backdate clamped": {validity: Validity{Start: start, Backdate: 30 * 24 * time.Hour}, wantNotBefore: issuer.NotBefore, wantNotAfter: start.AddDate(0, 0, 30)},
+		"expiry clamped":   {validity: Validity{Start: start, Duration: 2 * 365 * 24 * time.Hour}, wantNotBefore: start, wantNotAfter: issuer.NotAfter},
+		"expiry fails": {
+			validity: Validity{Start: start, Duration: 2 * 365 * 24 * time.Hour, IssuerExpiry: IssuerExpiryFail},
+			wantErr:  ErrOutlivesIssuer,
+		},
+		"negative duration":    {validity: Validity{Start: start, Duration: -time.Hour}, wantErr: ErrInvalidValidity},
+		"start after issuer":   {validity: Validity{Start: issuer.NotAfter}, wantErr: ErrInvalidValidity},
+		"expires before start": {validity: Validity{Start: issuer.NotBefore.Add(-48 * time.Hour), Duration: time.Hour}, wantErr: ErrInvalidValidity},
+	}
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			notBefore, notAfter, err := tc.validity.period(issuer, defaultNotAfter)
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("expected %v, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("period: %v", err)
+			}
+			if !notBefore.Equal(tc.wantNotBefore) || !notAfter.Equal(tc.wantNotAfter) {
+				t.Fatalf("expected %s to %s, got %s to %s", tc.wantNotBefore, tc.wantNotAfter, notBefore, notAfter)
+			}
+		})
+	}
+}
+
+func TestSignValidity(t *testing.T) {
+	caCertFile, caKeyFile := newTestCa(t, CaExpirationDays(30))
+	caCert, err := LoadCertFromFile(caCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrFile, _ := newTestCsr(t, "www.example.com")
+
+	cert, err := signTestCsr(t, csrFile, caCertFile, caKeyFile, SignValidity(Validity{Duration: 7 * 24 * time.Hour}))
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	if got := cert.NotAfter.Sub(cert.NotBefore); got != 7*24*time.Hour {
+		t.Fatalf("expected a validity of 7 days, got %s", got)
+	}
+	cert, err = signTestCsr(t, csrFile, caCertFile, caKeyFile)
+	if err != nil {
+		t.Fatalf("SignCsr: %v", err)
+	}
+	if !cert.NotAfter.Equal(caCert.NotAfter) {
+		t.Fatalf("expected the default validity to be clamped to the CA's expiration at %s, got %s", caCert.NotAfter, cert.NotAfter)
+	}
+	_, err = signTestCsr(t, csrFile, caCertFile, caKeyFile, SignValidity(Validity{IssuerExpiry: IssuerExpiryFail}))
+	if !errors.Is(err, ErrOutlivesIssuer) {
+		t.Fatalf("expected ErrOutlivesIssuer, got %v", err)
+	}
+}
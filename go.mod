@@ -1,8 +1,14 @@
 module github.com/drognisep/certserver
 
-go 1.18
+go 1.21
 
 require (
 	github.com/google/uuid v1.3.0
 	github.com/spf13/pflag v1.0.5
+	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
+	gopkg.in/yaml.v3 v3.0.1
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
+
+require golang.org/x/sys v0.15.0 // indirect